@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"NetworkProtocol/HTTP/pkg/tritonhttp"
+)
+
+// loadCGIHandlers parses the value of -cgi, a comma-separated list of
+// "pattern=executable" entries such as "/cgi-bin/hello=/srv/cgi/hello.sh",
+// and returns a CGIHandler for each pattern. A pattern ending with a slash
+// runs the script for every path below it, passed as PATH_INFO.
+func loadCGIHandlers(spec string, timeout time.Duration, maxProcs int) (map[string]*tritonhttp.CGIHandler, error) {
+	handlers := make(map[string]*tritonhttp.CGIHandler)
+	for _, entry := range strings.Split(spec, ",") {
+		i := strings.Index(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid cgi entry %q", entry)
+		}
+		pattern, path := strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		if !strings.HasPrefix(pattern, "/") || path == "" {
+			return nil, fmt.Errorf("invalid cgi entry %q", entry)
+		}
+		handlers[pattern] = &tritonhttp.CGIHandler{
+			Path:     path,
+			Root:     strings.TrimSuffix(pattern, "/"),
+			Timeout:  timeout,
+			MaxProcs: maxProcs,
+		}
+	}
+	return handlers, nil
+}
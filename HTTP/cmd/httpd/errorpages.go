@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+
+	"NetworkProtocol/HTTP/pkg/tritonhttp"
+)
+
+// loadErrorPages builds the error pages given with -error_pages, a
+// comma-separated list such as "404=/errors/404.html,500=/errors/50x.html"
+// of files below the doc root, and -error_template, the path of an HTML
+// template used for every other error status.
+func loadErrorPages(files, templatePath string) (*tritonhttp.ErrorPages, error) {
+	pages := &tritonhttp.ErrorPages{}
+	if files != "" {
+		pages.Files = make(map[int]string)
+		for _, kv := range strings.Split(files, ",") {
+			i := strings.Index(kv, "=")
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid error_pages entry %q", kv)
+			}
+			code, err := strconv.Atoi(strings.TrimSpace(kv[:i]))
+			if err != nil || code < 400 || code > 599 {
+				return nil, fmt.Errorf("invalid status code in error_pages entry %q", kv)
+			}
+			pages.Files[code] = strings.TrimSpace(kv[i+1:])
+		}
+	}
+	if templatePath != "" {
+		tmpl, err := template.ParseFiles(templatePath)
+		if err != nil {
+			return nil, err
+		}
+		pages.Template = tmpl
+	}
+	return pages, nil
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"NetworkProtocol/HTTP/pkg/tritonhttp"
 )
@@ -15,6 +22,37 @@ func main() {
 	var useDefault = flag.Bool("use_default", false, "whether to use the Golang standard library HTTP server")
 	var port = flag.Int("port", 8080, "the localhost port to listen on")
 	var docRoot = flag.String("doc_root", DOC_ROOT, "path to the doc root directory")
+	var readHeaderTimeout = flag.Duration("read_header_timeout", tritonhttp.DefaultReadHeaderTimeout, "time allowed to read the request line and headers")
+	var readTimeout = flag.Duration("read_timeout", 0, "time allowed to read a request body, 0 for no limit")
+	var writeTimeout = flag.Duration("write_timeout", 0, "time allowed to handle a request and write its response, 0 for no limit")
+	var idleTimeout = flag.Duration("idle_timeout", tritonhttp.DefaultIdleTimeout, "time a keep-alive connection may wait for its next request")
+	var maxHeaderBytes = flag.Int("max_header_bytes", tritonhttp.DefaultMaxHeaderBytes, "maximum size of the request line and headers")
+	var maxRequestsPerConn = flag.Int("max_requests_per_conn", 0, "requests served on a connection before closing it, 0 for no limit")
+	var maxConns = flag.Int("max_conns", 0, "maximum number of simultaneous connections, 0 for no limit")
+	var accessLog = flag.String("access_log", "", "path of the access log file, \"-\" for stdout, empty to disable")
+	var accessLogFormat = flag.String("access_log_format", "common", "access log format: common, combined or json")
+	var accessLogMaxBytes = flag.Int64("access_log_max_bytes", 0, "size at which the access log file is rotated, 0 to never rotate")
+	var accessLogBackups = flag.Int("access_log_backups", 5, "number of rotated access log files to keep")
+	var mimeTypes = flag.String("mime_types", "", "comma-separated Content-Type overrides, e.g. \".log=text/plain,.dat=application/x-dat\"")
+	var charset = flag.String("charset", tritonhttp.DefaultCharset, "charset declared for text files, \"none\" to leave it out")
+	var compress = flag.Bool("compress", false, "compress responses with gzip or deflate when the client accepts it")
+	var compressLevel = flag.Int("compress_level", 0, "gzip or deflate level from 1 to 9, 0 for the default")
+	var compressMinSize = flag.Int64("compress_min_size", tritonhttp.DefaultCompressMinSize, "smallest file compressed on the fly, in bytes")
+	var vhosts = flag.String("vhosts", "", "path to a JSON file describing name-based virtual hosts")
+	var tlsCert = flag.String("tls_cert", "", "comma-separated certificate files, one per hostname, to serve HTTPS")
+	var tlsKey = flag.String("tls_key", "", "comma-separated key files matching tls_cert")
+	var confineSymlinks = flag.Bool("confine_symlinks", true, "refuse to serve files through symlinks leading out of doc_root")
+	var autoindex = flag.String("autoindex", "off", "listing for directories without index.html: off, html or json")
+	var logLevel = flag.String("log_level", "warn", "minimum level of the error log: debug, info, warn or error")
+	var errorPages = flag.String("error_pages", "", "comma-separated error pages below doc_root, e.g. \"404=/errors/404.html,500=/errors/50x.html\"")
+	var errorTemplate = flag.String("error_template", "", "path to an HTML template for error responses without an error page")
+	var proxy = flag.String("proxy", "", "comma-separated upstream servers to forward every request to instead of serving doc_root")
+	var proxyBalancing = flag.String("proxy_balancing", "round_robin", "how requests are spread across the upstreams: round_robin or least_conn")
+	var cgi = flag.String("cgi", "", "comma-separated CGI scripts, e.g. \"/cgi-bin/hello=/srv/cgi/hello.sh\"; a pattern ending with / runs the script below it")
+	var cgiTimeout = flag.Duration("cgi_timeout", tritonhttp.DefaultCGITimeout, "time a CGI script may run before it is killed")
+	var cgiMaxProcs = flag.Int("cgi_max_procs", 0, "maximum number of processes running each CGI script, 0 for no limit")
+	var h2c = flag.Bool("h2c", false, "serve HTTP/2 over cleartext to clients using prior knowledge or \"Upgrade: h2c\"")
+	var maxConcurrentStreams = flag.Uint("max_concurrent_streams", tritonhttp.DefaultMaxConcurrentStreams, "maximum number of streams an HTTP/2 client may have open at once")
 	flag.Parse()
 
 	// Log server configs
@@ -22,6 +60,37 @@ func main() {
 	log.Printf("  use_default: %v", *useDefault)
 	log.Printf("  port: %v", *port)
 	log.Printf("  doc_root: %v", *docRoot)
+	log.Printf("  read_header_timeout: %v", *readHeaderTimeout)
+	log.Printf("  read_timeout: %v", *readTimeout)
+	log.Printf("  write_timeout: %v", *writeTimeout)
+	log.Printf("  idle_timeout: %v", *idleTimeout)
+	log.Printf("  max_header_bytes: %v", *maxHeaderBytes)
+	log.Printf("  max_requests_per_conn: %v", *maxRequestsPerConn)
+	log.Printf("  max_conns: %v", *maxConns)
+	log.Printf("  access_log: %v", *accessLog)
+	log.Printf("  access_log_format: %v", *accessLogFormat)
+	log.Printf("  access_log_max_bytes: %v", *accessLogMaxBytes)
+	log.Printf("  access_log_backups: %v", *accessLogBackups)
+	log.Printf("  mime_types: %v", *mimeTypes)
+	log.Printf("  charset: %v", *charset)
+	log.Printf("  compress: %v", *compress)
+	log.Printf("  compress_level: %v", *compressLevel)
+	log.Printf("  compress_min_size: %v", *compressMinSize)
+	log.Printf("  vhosts: %v", *vhosts)
+	log.Printf("  tls_cert: %v", *tlsCert)
+	log.Printf("  tls_key: %v", *tlsKey)
+	log.Printf("  confine_symlinks: %v", *confineSymlinks)
+	log.Printf("  autoindex: %v", *autoindex)
+	log.Printf("  log_level: %v", *logLevel)
+	log.Printf("  error_pages: %v", *errorPages)
+	log.Printf("  error_template: %v", *errorTemplate)
+	log.Printf("  proxy: %v", *proxy)
+	log.Printf("  proxy_balancing: %v", *proxyBalancing)
+	log.Printf("  cgi: %v", *cgi)
+	log.Printf("  cgi_timeout: %v", *cgiTimeout)
+	log.Printf("  cgi_max_procs: %v", *cgiMaxProcs)
+	log.Printf("  h2c: %v", *h2c)
+	log.Printf("  max_concurrent_streams: %v", *maxConcurrentStreams)
 
 	// Start server
 	addr := fmt.Sprintf(":%v", *port)
@@ -35,11 +104,148 @@ func main() {
 		log.Fatal(s.ListenAndServe())
 	} else { //启动tritonhttp服务器
 		log.Printf("Starting TritonHTTP server")
-		log.Printf("You can browse the website at http://localhost:%v/", *port)
+		scheme := "http"
+		if *tlsCert != "" {
+			scheme = "https"
+		}
+		log.Printf("You can browse the website at %v://localhost:%v/", scheme, *port)
 		s := &tritonhttp.Server{
 			Addr:    addr,     //地址
 			DocRoot: *docRoot, //文件根目录
+
+			ReadHeaderTimeout:  *readHeaderTimeout,
+			ReadTimeout:        *readTimeout,
+			WriteTimeout:       *writeTimeout,
+			IdleTimeout:        *idleTimeout,
+			MaxHeaderBytes:     *maxHeaderBytes,
+			MaxRequestsPerConn: *maxRequestsPerConn,
+			MaxConns:           *maxConns,
+			Charset:            *charset,
+			Compress:           *compress,
+			CompressLevel:      *compressLevel,
+			CompressMinSize:    *compressMinSize,
+			ConfineSymlinks:    *confineSymlinks,
+
+			H2C:                  *h2c,
+			MaxConcurrentStreams: uint32(*maxConcurrentStreams),
 		}
-		log.Fatal(s.ListenAndServe())
+		if *compressLevel < 0 || *compressLevel > 9 {
+			log.Fatalf("invalid compress_level %v", *compressLevel)
+		}
+		if *mimeTypes != "" {
+			s.MIMETypes = make(map[string]string)
+			for _, kv := range strings.Split(*mimeTypes, ",") {
+				i := strings.Index(kv, "=")
+				if i <= 0 {
+					log.Fatalf("invalid mime_types entry %q", kv)
+				}
+				ext := strings.ToLower(strings.TrimSpace(kv[:i]))
+				if !strings.HasPrefix(ext, ".") {
+					ext = "." + ext
+				}
+				s.MIMETypes[ext] = strings.TrimSpace(kv[i+1:])
+			}
+		}
+		autoindexFormat, err := tritonhttp.ParseAutoindexFormat(*autoindex)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Autoindex = autoindexFormat
+		if *errorPages != "" || *errorTemplate != "" {
+			s.ErrorPages, err = loadErrorPages(*errorPages, *errorTemplate)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		level, err := tritonhttp.ParseLogLevel(*logLevel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.ErrorLog = tritonhttp.NewErrorLogger(os.Stderr, level)
+		if *proxy != "" { // 反向代理 所有请求转发给上游服务器
+			p, err := newProxy(strings.Split(*proxy, ","), *proxyBalancing)
+			if err != nil {
+				log.Fatal(err)
+			}
+			p.ErrorLog = s.ErrorLog
+			s.Handler = p
+		}
+		if *accessLog != "" {
+			format, err := tritonhttp.ParseLogFormat(*accessLogFormat)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if *accessLog == "-" {
+				s.AccessLog = tritonhttp.NewAccessLogger(os.Stdout, format)
+			} else {
+				rf, err := tritonhttp.OpenRotatingFile(*accessLog, *accessLogMaxBytes, *accessLogBackups)
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer rf.Close()
+				s.AccessLog = tritonhttp.NewAccessLogger(rf, format)
+			}
+		}
+		if *vhosts != "" { // 根据Host选择doc root 未知的主机使用默认主机或者doc_root
+			s.VirtualHosts, s.DefaultHost, err = loadVirtualHosts(*vhosts)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *tlsCert != "" { // 每个证书对应一个主机名 根据SNI选择 文件更新后自动重新加载
+			certs, keys := strings.Split(*tlsCert, ","), strings.Split(*tlsKey, ",")
+			if len(certs) != len(keys) {
+				log.Fatal("tls_cert and tls_key must list as many files")
+			}
+			reloader := &tritonhttp.CertReloader{ErrorLog: s.ErrorLog}
+			for i := range certs {
+				if err := reloader.Add(strings.TrimSpace(certs[i]), strings.TrimSpace(keys[i])); err != nil {
+					log.Fatal(err)
+				}
+			}
+			s.TLSConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+		}
+		if *cgi != "" { // CGI脚本以外的请求仍然交给静态文件或者反向代理
+			scripts, err := loadCGIHandlers(*cgi, *cgiTimeout, *cgiMaxProcs)
+			if err != nil {
+				log.Fatal(err)
+			}
+			mux := tritonhttp.NewServeMux()
+			if _, ok := scripts["/"]; !ok {
+				if s.Handler != nil {
+					mux.Handle("/", s.Handler)
+				} else {
+					mux.Handle("/", s.FileHandler())
+				}
+			}
+			for pattern, h := range scripts {
+				h.ErrorLog = s.ErrorLog
+				mux.Handle(pattern, h)
+			}
+			s.Handler = mux
+		}
+		// 收到Ctrl+C或者kill信号时 等待正在处理的请求完成后再退出
+		idle := make(chan struct{})
+		go func() {
+			defer close(idle)
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			<-sig
+			log.Printf("Shutting down TritonHTTP server")
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := s.Shutdown(ctx); err != nil {
+				log.Printf("Shutdown: %v", err)
+				s.Close()
+			}
+		}()
+		serve := s.ListenAndServe
+		if s.TLSConfig != nil {
+			serve = func() error { return s.ListenAndServeTLS("", "") }
+		}
+		if err := serve(); err != tritonhttp.ErrServerClosed {
+			log.Fatal(err)
+		}
+		<-idle
 	}
 }
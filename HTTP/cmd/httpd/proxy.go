@@ -0,0 +1,20 @@
+package main
+
+import (
+	"NetworkProtocol/HTTP/pkg/tritonhttp"
+)
+
+// newProxy returns a reverse proxy spreading requests across upstreams
+// with the load balancing named balancing.
+func newProxy(upstreams []string, balancing string) (*tritonhttp.ReverseProxy, error) {
+	b, err := tritonhttp.ParseBalancing(balancing)
+	if err != nil {
+		return nil, err
+	}
+	p, err := tritonhttp.NewReverseProxy(upstreams...)
+	if err != nil {
+		return nil, err
+	}
+	p.Balancing = b
+	return p, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"NetworkProtocol/HTTP/pkg/tritonhttp"
+)
+
+// vhostsConfig is the format of the file given with -vhosts, e.g.
+//
+//	{
+//		"default": "example.com",
+//		"hosts": {
+//			"example.com": {"doc_root": "/srv/example"},
+//			"*.example.org:8080": {"doc_root": "/srv/example-org"},
+//			"api.example.com": {"proxy": ["10.0.0.1:8080", "10.0.0.2:8080"], "balancing": "least_conn"}
+//		}
+//	}
+type vhostsConfig struct {
+	Default string `json:"default"`
+	Hosts   map[string]struct {
+		DocRoot   string   `json:"doc_root"`
+		Proxy     []string `json:"proxy"`
+		Balancing string   `json:"balancing"`
+	} `json:"hosts"`
+}
+
+// loadVirtualHosts reads the virtual hosts described in the file at path
+// and returns them along with the name of the default host.
+func loadVirtualHosts(path string) (map[string]*tritonhttp.VirtualHost, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var config vhostsConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, "", fmt.Errorf("%v: %v", path, err)
+	}
+	hosts := make(map[string]*tritonhttp.VirtualHost, len(config.Hosts))
+	for name, h := range config.Hosts {
+		if len(h.Proxy) > 0 { // 转发给上游服务器的主机不需要doc_root
+			p, err := newProxy(h.Proxy, h.Balancing)
+			if err != nil {
+				return nil, "", fmt.Errorf("%v: host %q: %v", path, name, err)
+			}
+			hosts[strings.ToLower(name)] = &tritonhttp.VirtualHost{Handler: p}
+			continue
+		}
+		if h.DocRoot == "" {
+			return nil, "", fmt.Errorf("%v: host %q has neither doc_root nor proxy", path, name)
+		}
+		if fi, err := os.Stat(h.DocRoot); err != nil || !fi.IsDir() {
+			return nil, "", fmt.Errorf("%v: doc_root of host %q is not a directory", path, name)
+		}
+		hosts[strings.ToLower(name)] = &tritonhttp.VirtualHost{DocRoot: h.DocRoot}
+	}
+	defaultHost := strings.ToLower(config.Default)
+	if _, ok := hosts[defaultHost]; defaultHost != "" && !ok {
+		return nil, "", fmt.Errorf("%v: default host %q is not listed in hosts", path, config.Default)
+	}
+	return hosts, defaultHost, nil
+}
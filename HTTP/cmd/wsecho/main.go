@@ -0,0 +1,70 @@
+// Command wsecho is a WebSocket echo server built on tritonhttp.
+// Browse http://localhost:8080/ to chat with it, or connect any
+// WebSocket client to ws://localhost:8080/echo.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+
+	"NetworkProtocol/HTTP/pkg/tritonhttp"
+)
+
+// page is a minimal client sending what is typed and showing the echoes.
+const page = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>WebSocket echo</title></head>
+<body>
+<form id="form"><input id="input" autofocus> <button>Send</button></form>
+<pre id="log"></pre>
+<script>
+const log = (s) => document.getElementById("log").textContent += s + "\n";
+const ws = new WebSocket("ws://" + location.host + "/echo");
+ws.onopen = () => log("connected");
+ws.onmessage = (e) => log("< " + e.data);
+ws.onclose = (e) => log("closed: " + e.code);
+document.getElementById("form").onsubmit = (e) => {
+	e.preventDefault();
+	const input = document.getElementById("input");
+	ws.send(input.value);
+	log("> " + input.value);
+	input.value = "";
+};
+</script>
+</body>
+</html>
+`
+
+func main() {
+	var port = flag.Int("port", 8080, "the localhost port to listen on")
+	flag.Parse()
+
+	mux := tritonhttp.NewServeMux()
+	mux.HandleFunc("/", func(w tritonhttp.ResponseWriter, req *tritonhttp.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, page)
+	})
+	mux.Handle("/echo", tritonhttp.WebSocketHandler(func(ws *tritonhttp.WebSocket, req *tritonhttp.Request) {
+		log.Printf("%v: connected", ws.RemoteAddr())
+		for {
+			typ, p, err := ws.ReadMessage()
+			if err != nil {
+				log.Printf("%v: %v", ws.RemoteAddr(), err)
+				return
+			}
+			if err := ws.WriteMessage(typ, p); err != nil {
+				log.Printf("%v: %v", ws.RemoteAddr(), err)
+				return
+			}
+		}
+	}))
+
+	s := &tritonhttp.Server{
+		Addr:    fmt.Sprintf(":%v", *port),
+		Handler: mux,
+	}
+	log.Printf("You can browse the echo page at http://localhost:%v/", *port)
+	log.Fatal(s.ListenAndServe())
+}
@@ -0,0 +1,167 @@
+package tritonhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AutoindexFormat selects the directory listing generated by a FileHandler.
+type AutoindexFormat int
+
+const (
+	// AutoindexOff disables directory listings.
+	AutoindexOff AutoindexFormat = iota
+
+	// AutoindexHTML lists a directory as an HTML table.
+	AutoindexHTML
+
+	// AutoindexJSON lists a directory as a JSON object, e.g.
+	// {"path":"/docs/","entries":[{"name":"a.txt","dir":false,"size":3,"mod_time":"…"}]}
+	AutoindexJSON
+)
+
+// ParseAutoindexFormat returns the AutoindexFormat named "off", "html" or "json".
+func ParseAutoindexFormat(name string) (AutoindexFormat, error) {
+	switch strings.ToLower(name) {
+	case "off", "":
+		return AutoindexOff, nil
+	case "html":
+		return AutoindexHTML, nil
+	case "json":
+		return AutoindexJSON, nil
+	}
+	return 0, fmt.Errorf("unknown autoindex format %q", name)
+}
+
+// dirEntry is one line of a directory listing.
+type dirEntry struct {
+	Name    string `json:"name"`
+	Dir     bool   `json:"dir"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"mod_time"`
+
+	modTime time.Time
+}
+
+// listableDir returns the local path of the directory req refers to,
+// if it should be listed: the URL names a directory, ends with "/",
+// and the directory has no IndexFile.
+func (f *FileHandler) listableDir(req *Request) (string, bool) {
+	dir, fileInfo, err := ResolvePath(f.DocRoot, req.URL, f.ConfineSymlinks)
+	if err != nil || !fileInfo.IsDir() {
+		return "", false
+	}
+	if urlPath, _ := CleanPath(req.URL); !strings.HasSuffix(urlPath, "/") {
+		return "", false
+	}
+	if _, err := os.Stat(filepath.Join(dir, IndexFile)); err == nil {
+		return "", false
+	}
+	return dir, true
+}
+
+// readDirEntries returns the entries of dir, directories first, each
+// group sorted by name. Hidden files, whose name starts with ".", are
+// left out.
+func readDirEntries(dir string) ([]dirEntry, error) {
+	infos, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]dirEntry, 0, len(infos))
+	for _, d := range infos {
+		if strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		fileInfo, err := d.Info()
+		if err != nil {
+			continue // 文件在读取目录后被删除
+		}
+		e := dirEntry{
+			Name:    d.Name(),
+			Dir:     fileInfo.IsDir(),
+			modTime: fileInfo.ModTime().UTC(),
+		}
+		if !e.Dir {
+			e.Size = fileInfo.Size()
+		}
+		e.ModTime = e.modTime.Format(time.RFC3339)
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Dir != entries[j].Dir {
+			return entries[i].Dir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// serveAutoindex writes the listing of the local directory dir,
+// which req refers to, in the given format. Failures go to log.
+func serveAutoindex(w ResponseWriter, req *Request, dir string, format AutoindexFormat, log *ErrorLogger) {
+	entries, err := readDirEntries(dir)
+	if err != nil {
+		log.Warnf("autoindex %v: %v", dir, err)
+		res := &Response{}
+		res.HandleNotFound(req)
+		res.Serve(w)
+		return
+	}
+	urlPath, _ := CleanPath(req.URL)
+
+	var body []byte
+	if format == AutoindexJSON {
+		body, err = json.Marshal(struct {
+			Path    string     `json:"path"`
+			Entries []dirEntry `json:"entries"`
+		}{urlPath, entries})
+		if err != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		body = []byte(autoindexHTML(urlPath, entries))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(200)
+	w.Write(body)
+}
+
+// autoindexHTML renders entries as an HTML page. Names are escaped
+// both as URL path segments in links and as HTML text.
+func autoindexHTML(urlPath string, entries []dirEntry) string {
+	title := html.EscapeString("Index of " + urlPath)
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString("<title>" + title + "</title>\n</head>\n<body>\n")
+	b.WriteString("<h1>" + title + "</h1>\n<table>\n")
+	b.WriteString("<tr><th>Name</th><th>Size</th><th>Last modified</th></tr>\n")
+	if urlPath != "/" {
+		b.WriteString("<tr><td><a href=\"../\">../</a></td><td>-</td><td>-</td></tr>\n")
+	}
+	for _, e := range entries {
+		name, size := e.Name, strconv.FormatInt(e.Size, 10)
+		if e.Dir {
+			name += "/"
+			size = "-"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		if strings.Contains(e.Name, ":") {
+			href = "./" + href // 避免被当成URL的scheme
+		}
+		fmt.Fprintf(&b, "<tr><td><a href=\"%s\">%s</a></td><td>%s</td><td>%s</td></tr>\n",
+			html.EscapeString(href), html.EscapeString(name), size, e.modTime.Format("2006-01-02 15:04:05"))
+	}
+	b.WriteString("</table>\n</body>\n</html>\n")
+	return b.String()
+}
@@ -0,0 +1,142 @@
+package tritonhttp
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirectoryIndex(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle("/static/", StripPrefix("/static", FileServer("testdata")))
+	mux.Handle("/", FileServer("testdata"))
+	s := &Server{Handler: mux}
+
+	var tests = []struct {
+		name       string
+		url        string
+		wantLine   string
+		wantHeader string
+		wantBody   string
+	}{
+		{"Root", "/", "HTTP/1.1 200 OK", "Content-Length: 12", "Hello World\n"},
+		{"Subdir", "/subdir/", "HTTP/1.1 200 OK", "Content-Length: 8", ""},
+		{"Redirect", "/subdir", "HTTP/1.1 301 Moved Permanently", "Location: subdir/", ""},
+		{"RedirectBelowPrefix", "/static/subdir", "HTTP/1.1 301 Moved Permanently", "Location: subdir/", ""},
+		{"File", "/subdir/index.html", "HTTP/1.1 200 OK", "Content-Length: 8", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := roundTrip(t, s, "GET "+tt.url+" HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+			if !strings.HasPrefix(got, tt.wantLine+"\r\n") {
+				t.Fatalf("got response: %q, want status line %q", got, tt.wantLine)
+			}
+			if !strings.Contains(got, "\r\n"+tt.wantHeader+"\r\n") {
+				t.Fatalf("got response: %q, want header %q", got, tt.wantHeader)
+			}
+			if tt.wantBody != "" && !strings.HasSuffix(got, "\r\n\r\n"+tt.wantBody) {
+				t.Fatalf("got response: %q, want body %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+// makeListingDir creates a directory to be listed, with names
+// that need escaping.
+func makeListingDir(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"b.txt":                     "bb",
+		"a&<script>.txt":            "a",
+		".hidden":                   "secret",
+		"docs/" + IndexFile:         "index",
+		"zdir/" + "nested.txt":      "n",
+		"with space \"quoted\".txt": "",
+	} {
+		p := filepath.Join(root, "list", name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestAutoindex(t *testing.T) {
+	root := makeListingDir(t)
+
+	t.Run("Off", func(t *testing.T) {
+		s := &Server{DocRoot: root}
+		got := roundTrip(t, s, "GET /list/ HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		if !strings.HasPrefix(got, "HTTP/1.1 404 Not Found\r\n") {
+			t.Fatalf("got response: %q, want 404", got)
+		}
+	})
+
+	t.Run("HTML", func(t *testing.T) {
+		s := &Server{DocRoot: root, Autoindex: AutoindexHTML}
+		got := roundTrip(t, s, "GET /list/ HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		if !strings.HasPrefix(got, "HTTP/1.1 200 OK\r\n") || !strings.Contains(got, "Content-Type: text/html; charset=utf-8\r\n") {
+			t.Fatalf("got response: %q", got)
+		}
+		wants := []string{
+			`<a href="../">../</a>`,
+			`<a href="docs/">docs/</a>`,
+			`<a href="zdir/">zdir/</a>`,
+			`<a href="a&amp;%3Cscript%3E.txt">a&amp;&lt;script&gt;.txt</a></td><td>1</td>`,
+			`<a href="b.txt">b.txt</a></td><td>2</td>`,
+			`<a href="with%20space%20%22quoted%22.txt">with space &#34;quoted&#34;.txt</a>`,
+		}
+		last := -1
+		for _, want := range wants {
+			i := strings.Index(got, want)
+			if i == -1 {
+				t.Fatalf("got listing: %q, want it to contain %q", got, want)
+			}
+			if i < last {
+				t.Fatalf("got listing: %q, want %q in sorted order", got, want)
+			}
+			last = i
+		}
+		if strings.Contains(got, ".hidden") {
+			t.Fatalf("got listing with hidden file: %q", got)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		s := &Server{DocRoot: root, Autoindex: AutoindexJSON}
+		got := roundTrip(t, s, "GET /list/zdir/ HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		i := strings.Index(got, "\r\n\r\n")
+		if !strings.Contains(got[:i+2], "Content-Type: application/json\r\n") {
+			t.Fatalf("got response: %q", got)
+		}
+		var listing struct {
+			Path    string
+			Entries []struct {
+				Name string
+				Dir  bool
+				Size int64
+			}
+		}
+		if err := json.Unmarshal([]byte(got[i+4:]), &listing); err != nil {
+			t.Fatalf("got invalid JSON %q: %v", got[i+4:], err)
+		}
+		if listing.Path != "/list/zdir/" || len(listing.Entries) != 1 ||
+			listing.Entries[0].Name != "nested.txt" || listing.Entries[0].Dir || listing.Entries[0].Size != 1 {
+			t.Fatalf("got listing: %+v", listing)
+		}
+	})
+
+	t.Run("IndexFileWins", func(t *testing.T) {
+		s := &Server{DocRoot: root, Autoindex: AutoindexHTML}
+		got := roundTrip(t, s, "GET /list/docs/ HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		if !strings.HasSuffix(got, "\r\n\r\nindex") {
+			t.Fatalf("got response: %q, want the index file", got)
+		}
+	})
+}
@@ -0,0 +1,243 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxBodyBytes is the request body limit used when
+// Server.MaxBodyBytes is zero.
+const DefaultMaxBodyBytes = 10 << 20 // 10 MB
+
+// ErrBodyTooLarge is returned when reading a request body
+// exceeds the maximum body size of the server.
+var ErrBodyTooLarge = errors.New("tritonhttp: request body too large")
+
+// ErrBadChunk is returned when a chunked body is malformed.
+var ErrBadChunk = errors.New("tritonhttp: malformed chunked encoding")
+
+// setBody prepares req.Body from the "Content-Length" or the
+// "Transfer-Encoding" header. The body is left nil when there is none.
+func (req *Request) setBody(br *bufio.Reader, headers Header) error {
+	te, hasTE := headers.Join("Transfer-Encoding"), headers.Has("Transfer-Encoding")
+	cl, hasCL := headers.Get("Content-Length"), headers.Has("Content-Length")
+	if hasTE {
+		if hasCL {
+			// Both headers at once is the classic request smuggling vector
+			return badRequest("both Transfer-Encoding and Content-Length present")
+		}
+		if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			return &ProtocolError{501, "unsupported Transfer-Encoding " + strconv.Quote(te)}
+		}
+		req.ContentLength = -1
+		req.Body = &chunkedReader{br: br, req: req}
+		return nil
+	}
+	if hasCL {
+		n, err := parseContentLength(cl)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = n
+		if n > 0 {
+			req.Body = &contentLengthReader{r: br, n: n}
+		}
+	}
+	return nil
+}
+
+// parseContentLength parses a "Content-Length" value. A list of
+// identical lengths such as "42, 42", left by a proxy merging repeated
+// headers, is accepted; differing ones are not.
+func parseContentLength(cl string) (int64, error) {
+	n := int64(-1)
+	for _, v := range strings.Split(cl, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" || strings.Trim(v, "0123456789") != "" {
+			return 0, badRequest("invalid Content-Length %q", cl)
+		}
+		m, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || (n != -1 && m != n) {
+			return 0, badRequest("invalid Content-Length %q", cl)
+		}
+		n = m
+	}
+	return n, nil
+}
+
+// contentLengthReader reads exactly n bytes from r, reporting
+// io.ErrUnexpectedEOF if r ends early.
+type contentLengthReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *contentLengthReader) Read(p []byte) (int, error) {
+	if cr.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > cr.n {
+		p = p[:cr.n]
+	}
+	n, err := cr.r.Read(p)
+	cr.n -= int64(n)
+	if err == io.EOF && cr.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && cr.n == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// maxChunkLineBytes limits the length of a chunk-size line,
+// extensions included.
+const maxChunkLineBytes = 4 << 10
+
+// chunkedReader decodes a body sent with "Transfer-Encoding: chunked".
+// Trailer fields following the last chunk are stored in req.Trailer.
+type chunkedReader struct {
+	br   *bufio.Reader
+	req  *Request
+	n    int64 // unread bytes in the current chunk
+	err  error
+	done bool
+
+	// maxTrailer limits the size of the trailer section, like
+	// MaxHeaderBytes does for the header. If zero,
+	// DefaultMaxHeaderBytes is used.
+	maxTrailer int64
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.n == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		if cr.err = cr.nextChunk(); cr.err != nil {
+			return 0, cr.err
+		}
+		if cr.done {
+			cr.err = io.EOF
+			return 0, io.EOF
+		}
+	}
+	if int64(len(p)) > cr.n {
+		p = p[:cr.n]
+	}
+	n, err := cr.br.Read(p)
+	cr.n -= int64(n)
+	if cr.n == 0 && err == nil {
+		// Every chunk ends with CRLF
+		var line string
+		if line, err = ReadLine(cr.br); err == nil && line != "" {
+			err = ErrBadChunk
+		}
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	cr.err = err
+	return n, err
+}
+
+// nextChunk reads the next chunk-size line, or the trailer section
+// when the last chunk is reached.
+func (cr *chunkedReader) nextChunk() error {
+	line, err := readLineLimit(cr.br, maxChunkLineBytes)
+	if err == errLineTooLong {
+		return ErrBadChunk
+	}
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	size, err := parseChunkSize(line)
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		cr.n = size
+		return nil
+	}
+	cr.done = true
+	left := cr.maxTrailer
+	if left <= 0 {
+		left = DefaultMaxHeaderBytes
+	}
+	for {
+		line, err := readLineLimit(cr.br, left)
+		if err == errLineTooLong {
+			return ErrBadChunk
+		}
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if line == "" {
+			return nil
+		}
+		left -= int64(len(line)) + 2
+		key, value, err := parseHeaderLine(line)
+		if err != nil {
+			return ErrBadChunk
+		}
+		if cr.req.Trailer == nil {
+			cr.req.Trailer = make(Header)
+		}
+		cr.req.Trailer.Add(key, value)
+	}
+}
+
+// parseChunkSize parses a chunk-size line, RFC 9112 section 7.1: the
+// size in hexadecimal digits only, optionally followed by extensions,
+// which are ignored. Anything looser, such as a sign or spaces, could be
+// read differently by a proxy in front of the server.
+func parseChunkSize(line string) (int64, error) {
+	if i := strings.IndexByte(line, ';'); i != -1 {
+		line = strings.TrimRight(line[:i], " \t") // 扩展之前允许空白
+	}
+	if line == "" {
+		return 0, ErrBadChunk
+	}
+	for i := 0; i < len(line); i++ {
+		if c := line[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return 0, ErrBadChunk
+		}
+	}
+	size, err := strconv.ParseInt(line, 16, 64)
+	if err != nil { // 超出int64
+		return 0, ErrBadChunk
+	}
+	return size, nil
+}
+
+// maxBytesReader limits the number of body bytes a handler can read,
+// returning ErrBodyTooLarge past the limit.
+type maxBytesReader struct {
+	r        io.Reader
+	n        int64 // bytes left before the limit
+	tooLarge bool
+}
+
+func (mr *maxBytesReader) Read(p []byte) (int, error) {
+	if mr.tooLarge {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > mr.n+1 {
+		p = p[:mr.n+1]
+	}
+	n, err := mr.r.Read(p)
+	if int64(n) <= mr.n {
+		mr.n -= int64(n)
+		return n, err
+	}
+	mr.tooLarge = true
+	n = int(mr.n)
+	mr.n = 0
+	return n, ErrBodyTooLarge
+}
@@ -0,0 +1,224 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRequestBody(t *testing.T) {
+	var tests = []struct {
+		name        string
+		reqText     string
+		bodyWant    string
+		lengthWant  int64
+		trailerWant Header
+	}{
+		{
+			"ContentLength",
+			"POST /api HTTP/1.1\r\n" +
+				"Host: test\r\n" +
+				"Content-Length: 11\r\n" +
+				"\r\n" +
+				"hello world",
+			"hello world",
+			11,
+			nil,
+		},
+		{
+			"Chunked",
+			"POST /api HTTP/1.1\r\n" +
+				"Host: test\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"5\r\nhello\r\n" +
+				"6;ext=1\r\n world\r\n" +
+				"0\r\n" +
+				"\r\n",
+			"hello world",
+			-1,
+			nil,
+		},
+		{
+			"ChunkedTrailer",
+			"PUT /api HTTP/1.1\r\n" +
+				"Host: test\r\n" +
+				"transfer-encoding: chunked\r\n" +
+				"\r\n" +
+				"b\r\nhello world\r\n" +
+				"0\r\n" +
+				"checksum: abc\r\n" +
+				"\r\n",
+			"hello world",
+			-1,
+			Header{"Checksum": {"abc"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := "GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n"
+			br := bufio.NewReader(strings.NewReader(tt.reqText + next))
+			req, _, err := ReadRequest(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.ContentLength != tt.lengthWant {
+				t.Fatalf("content length got: %v, want: %v", req.ContentLength, tt.lengthWant)
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.bodyWant {
+				t.Fatalf("body got: %q, want: %q", body, tt.bodyWant)
+			}
+			if !reflect.DeepEqual(req.Trailer, tt.trailerWant) {
+				t.Fatalf("trailer got: %v, want: %v", req.Trailer, tt.trailerWant)
+			}
+			// The body must not leak into the next request
+			req, _, err = ReadRequest(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.URL != "/index.html" || req.Body != nil {
+				t.Fatalf("next request got: %v", req)
+			}
+		})
+	}
+}
+
+func TestReadBadRequestBody(t *testing.T) {
+	var tests = []struct {
+		name    string
+		reqText string
+	}{
+		{
+			"BothLengths",
+			"POST /api HTTP/1.1\r\nHost: test\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n",
+		},
+		{
+			"InvalidLength",
+			"POST /api HTTP/1.1\r\nHost: test\r\nContent-Length: -3\r\n\r\n",
+		},
+		{
+			"UnknownEncoding",
+			"POST /api HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: gzip\r\n\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqGot, _, err := ReadRequest(bufio.NewReader(strings.NewReader(tt.reqText)))
+			checkBadRequest(t, err, reqGot)
+		})
+	}
+}
+
+func TestReadMalformedChunks(t *testing.T) {
+	var tests = []struct {
+		name string
+		body string
+		want error
+	}{
+		{"BadSize", "zz\r\nhello\r\n0\r\n\r\n", ErrBadChunk},
+		{"SignedSize", "+5\r\nhello\r\n0\r\n\r\n", ErrBadChunk},
+		{"NegativeZero", "5\r\nhello\r\n-0\r\n\r\n", ErrBadChunk},
+		{"SpaceBeforeSize", " 5\r\nhello\r\n0\r\n\r\n", ErrBadChunk},
+		{"SpaceAfterSize", "5 \r\nhello\r\n0\r\n\r\n", ErrBadChunk},
+		{"EmptySize", ";ext\r\nhello\r\n0\r\n\r\n", ErrBadChunk},
+		{"HugeSize", "10000000000000000\r\nhello\r\n0\r\n\r\n", ErrBadChunk},
+		{"BadTrailerName", "5\r\nhello\r\n0\r\nX Sum: 1\r\n\r\n", ErrBadChunk},
+		{"LongChunkLine", "5;" + strings.Repeat("x", maxChunkLineBytes) + "\r\nhello\r\n0\r\n\r\n", ErrBadChunk},
+		{"LongTrailer", "5\r\nhello\r\n0\r\n" + strings.Repeat("X-Sum: 1\r\n", DefaultMaxHeaderBytes/10+1) + "\r\n", ErrBadChunk},
+		{"BadTrailerValue", "5\r\nhello\r\n0\r\nX-Sum: a\x00b\r\n\r\n", ErrBadChunk},
+		{"MissingCRLF", "5\r\nhelloX\r\n0\r\n\r\n", ErrBadChunk},
+		{"Truncated", "5\r\nhel", io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqText := "POST /api HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n" + tt.body
+			req, _, err := ReadRequest(bufio.NewReader(strings.NewReader(reqText)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(req.Body); !errors.Is(err, tt.want) {
+				t.Fatalf("got error: %v, want: %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeRequestBody(t *testing.T) {
+	echo := HandlerFunc(func(w ResponseWriter, req *Request) {
+		body, err := io.ReadAll(req.Body)
+		if errors.Is(err, ErrBodyTooLarge) {
+			res := &Response{}
+			res.HandlePayloadTooLarge(req)
+			res.Serve(w)
+			return
+		}
+		w.Write(body)
+	})
+	ignore := HandlerFunc(func(w ResponseWriter, req *Request) {
+		io.WriteString(w, "ignored")
+	})
+
+	var tests = []struct {
+		name     string
+		handler  Handler
+		reqText  string
+		wantLine string
+		wantBody string
+	}{
+		{
+			"Echo",
+			echo,
+			"POST /echo HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\n\r\nhello" +
+				"POST /echo HTTP/1.1\r\nHost: test\r\nConnection: close\r\nContent-Length: 3\r\n\r\nbye",
+			"HTTP/1.1 200 OK",
+			"bye",
+		},
+		{
+			"UnreadBody",
+			ignore,
+			"POST /echo HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\n\r\nhello" +
+				"GET /echo HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 200 OK",
+			"ignored",
+		},
+		{
+			"ContentLengthTooLarge",
+			echo,
+			"POST /echo HTTP/1.1\r\nHost: test\r\nContent-Length: 100\r\n\r\n",
+			"HTTP/1.1 413 Content Too Large",
+			"413 Content Too Large\n",
+		},
+		{
+			"ChunkedTooLarge",
+			ignore,
+			"POST /echo HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"20\r\n" + strings.Repeat("x", 32) + "\r\n0\r\n\r\n",
+			"HTTP/1.1 413 Content Too Large",
+			"413 Content Too Large\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Handler: tt.handler, MaxBodyBytes: 16}
+			got := roundTrip(t, s, tt.reqText)
+			i := strings.LastIndex(got, "HTTP/1.1 ")
+			if !strings.HasPrefix(got[i:], tt.wantLine+"\r\n") {
+				t.Fatalf("got response: %q, want status line %q", got, tt.wantLine)
+			}
+			if !strings.HasSuffix(got, "\r\n\r\n"+tt.wantBody) {
+				t.Fatalf("got response: %q, want body %q", got, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,466 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCGITimeout is used when CGIHandler.Timeout is zero.
+const DefaultCGITimeout = 30 * time.Second
+
+// maxCGIHeaderBytes limits the size of the header block of a script.
+const maxCGIHeaderBytes = 64 << 10
+
+// maxCGIStderrBytes limits how much of the standard error of a script is
+// logged; the rest is discarded.
+const maxCGIStderrBytes = 64 << 10
+
+// cgiWaitDelay is how long the output of a script is still read once it
+// exited or was killed. Processes it started in the background may keep
+// the pipes open; they are closed after it.
+const cgiWaitDelay = time.Second
+
+// A CGIHandler runs an executable for each request, following the Common
+// Gateway Interface of RFC 3875. The request is described to the script
+// by environment variables, such as REQUEST_METHOD, PATH_INFO,
+// QUERY_STRING and one HTTP_* variable per header, and its body is
+// written to the standard input of the script.
+//
+// The script writes a header block followed by the body of the response
+// to its standard output. A "Status" header sets the status code; a
+// "Location" header without one redirects the client with 302 Found.
+// Invalid output is answered with 502 Bad Gateway, and a script running
+// past Timeout is killed, answered with 504 Gateway Timeout if it had not
+// sent its headers yet.
+type CGIHandler struct {
+	// Path is the path of the executable.
+	Path string
+
+	// Root is the URL prefix the handler is mounted at, e.g.
+	// "/cgi-bin/hello". It is passed as SCRIPT_NAME, and the rest of
+	// the request path as PATH_INFO. Requests for paths outside of
+	// it are answered with 404 Not Found.
+	Root string
+
+	// Dir is the working directory of the script. If empty, the
+	// directory of Path is used.
+	Dir string
+
+	// Args are the arguments passed to the script.
+	Args []string
+
+	// Env holds extra environment variables, as "KEY=value".
+	Env []string
+
+	// InheritEnv lists the environment variables of the server passed
+	// on to the script, in addition to PATH.
+	InheritEnv []string
+
+	// Timeout limits the running time of the script.
+	// If zero, DefaultCGITimeout is used.
+	Timeout time.Duration
+
+	// MaxProcs limits the number of scripts running at the same time.
+	// Requests beyond it are answered with 503 Service Unavailable.
+	// If zero, there is no limit.
+	MaxProcs int
+
+	// ErrorLog receives the failures and the standard error of scripts.
+	// If nil, they are logged to the default error log.
+	ErrorLog *ErrorLogger
+
+	once  sync.Once
+	procs chan struct{} // one token per running script
+}
+
+// ServeHTTP runs the script for req and copies its response to w.
+func (h *CGIHandler) ServeHTTP(w ResponseWriter, req *Request) {
+	pathInfo, ok := h.pathInfo(req.URL)
+	if !ok {
+		res := &Response{}
+		res.HandleNotFound(req)
+		res.Serve(w)
+		return
+	}
+	if !h.acquire() {
+		h.errorLog().Warnf("cgi %v: %v scripts running", h.Path, h.MaxProcs)
+		res := &Response{Request: req}
+		res.HandleServiceUnavailable()
+		res.Serve(w)
+		return
+	}
+	defer h.release()
+
+	// CGI需要CONTENT_LENGTH 分块的body先读完再交给脚本
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			res := &Response{}
+			if errors.Is(err, ErrBodyTooLarge) {
+				res.HandlePayloadTooLarge(req)
+			} else {
+				res.HandleBadRequest()
+			}
+			res.Serve(w)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.Path, h.Args...)
+	cmd.Dir = h.Dir
+	if cmd.Dir == "" {
+		cmd.Dir = filepath.Dir(h.Path)
+	}
+	cmd.Env = h.env(req, pathInfo, len(body))
+	cmd.Stdin = bytes.NewReader(body)
+	out, err := h.start(ctx, cmd)
+	if err != nil {
+		h.errorLog().Errorf("cgi %v: %v", h.Path, err)
+		res := &Response{}
+		res.HandleBadGateway(req)
+		res.Serve(w)
+		return
+	}
+	defer out.Close()
+	defer func() {
+		if s := strings.TrimSpace(out.Stderr()); s != "" {
+			if out.truncated {
+				s += " [truncated]"
+			}
+			h.errorLog().Warnf("cgi %v: stderr: %v", h.Path, s)
+		}
+	}()
+
+	br := bufio.NewReader(out.stdout)
+	res, err := readCGIHeader(br)
+	if err != nil {
+		cancel()
+		io.Copy(io.Discard, br)
+		out.Wait()
+		reply := &Response{}
+		if ctx.Err() == context.DeadlineExceeded {
+			h.errorLog().Warnf("cgi %v: killed after %v", h.Path, h.timeout())
+			reply.HandleGatewayTimeout(req)
+		} else {
+			h.errorLog().Warnf("cgi %v: %v", h.Path, err)
+			reply.HandleBadGateway(req)
+		}
+		reply.Serve(w)
+		return
+	}
+	header := w.Header()
+	for k, v := range res.Header {
+		header[k] = v
+	}
+	w.WriteHeader(res.StatusCode)
+	if _, err := io.Copy(w, br); err != nil {
+		// 客户端断开 不再需要脚本的输出
+		cancel()
+		io.Copy(io.Discard, br)
+	}
+	if err := out.Wait(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			h.errorLog().Warnf("cgi %v: killed after %v", h.Path, h.timeout())
+		} else {
+			h.errorLog().Warnf("cgi %v: %v", h.Path, err)
+		}
+	}
+}
+
+// cgiOutput holds the output pipes of a running script. It collects
+// its standard error, and the result of its Wait.
+type cgiOutput struct {
+	stdout, stderr *os.File
+
+	buf       bytes.Buffer
+	truncated bool
+	done      chan struct{} // closed once buf is complete
+	exited    chan struct{} // closed once the script exited
+	err       error
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Write keeps the first maxCGIStderrBytes of the standard error.
+func (o *cgiOutput) Write(p []byte) (int, error) {
+	if n := maxCGIStderrBytes - o.buf.Len(); len(p) > n {
+		o.buf.Write(p[:n])
+		o.truncated = true
+	} else {
+		o.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// Stderr returns the standard error, once the script is done with it.
+func (o *cgiOutput) Stderr() string {
+	<-o.done
+	return o.buf.String()
+}
+
+// Wait waits for the script to exit and returns the error of cmd.Wait.
+func (o *cgiOutput) Wait() error {
+	<-o.exited
+	return o.err
+}
+
+// Close closes the pipes, making pending reads fail.
+func (o *cgiOutput) Close() {
+	o.closeOnce.Do(func() {
+		close(o.closed)
+		o.stdout.Close()
+		o.stderr.Close()
+	})
+}
+
+// start starts cmd with pipes for its output. Once ctx is done or the
+// script exited, the pipes are closed after cgiWaitDelay even if other
+// processes still hold them, as exec.Cmd.WaitDelay does.
+func (h *CGIHandler) start(ctx context.Context, cmd *exec.Cmd) (*cgiOutput, error) {
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		stdoutR.Close()
+		stdoutW.Close()
+		return nil, err
+	}
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+	err = cmd.Start()
+	// 子进程已经继承了写端
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		stdoutR.Close()
+		stderrR.Close()
+		return nil, err
+	}
+
+	o := &cgiOutput{
+		stdout: stdoutR,
+		stderr: stderrR,
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	go func() {
+		io.Copy(o, stderrR)
+		close(o.done)
+	}()
+	go func() {
+		o.err = cmd.Wait()
+		close(o.exited)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-o.exited:
+		case <-o.closed:
+			return
+		}
+		t := time.NewTimer(cgiWaitDelay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			o.Close()
+		case <-o.closed:
+		}
+	}()
+	return o, nil
+}
+
+// readCGIHeader reads the header block a script writes before the body,
+// RFC 3875 section 6. Lines may end with "\n" instead of "\r\n".
+func readCGIHeader(br *bufio.Reader) (*Response, error) {
+	res := &Response{StatusCode: 200, Proto: "HTTP/1.1", Header: make(Header)}
+	size := 0
+	for {
+		line, err := br.ReadString('\n')
+		size += len(line)
+		if size > maxCGIHeaderBytes {
+			return nil, errors.New("header block too large")
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("end of output before the end of the headers")
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		k, v, err := parseHeaderLine(line)
+		if err != nil {
+			return nil, err
+		}
+		res.Header.Add(k, v)
+	}
+
+	if status := res.Header.Get("Status"); status != "" {
+		// e.g. "Status: 404 Not Found"
+		code, err := strconv.Atoi(strings.SplitN(status, " ", 2)[0])
+		if err != nil || code < 100 || code > 999 {
+			return nil, fmt.Errorf("invalid Status header %q", status)
+		}
+		res.StatusCode = code
+		res.Header.Del("Status")
+	} else if res.Header.Has("Location") {
+		res.StatusCode = 302
+	}
+	// 分帧由服务器决定
+	for _, k := range hopHeaders {
+		res.Header.Del(k)
+	}
+	return res, nil
+}
+
+// pathInfo returns the part of the request target following Root,
+// unescaped. It reports false when the path is not Root or below it:
+// with Root "/cgi-bin", "/cgi-binx/foo" does not belong to the script.
+func (h *CGIHandler) pathInfo(target string) (string, bool) {
+	path := target
+	if i := strings.IndexByte(path, '?'); i != -1 {
+		path = path[:i]
+	}
+	root := strings.TrimSuffix(h.Root, "/")
+	if path != root && !strings.HasPrefix(path, root+"/") {
+		return "", false
+	}
+	pathInfo := path[len(root):]
+	if p, err := url.PathUnescape(pathInfo); err == nil {
+		pathInfo = p
+	}
+	return pathInfo, true
+}
+
+// env returns the environment of the script for req, whose body is
+// contentLength bytes long.
+func (h *CGIHandler) env(req *Request, pathInfo string, contentLength int) []string {
+	query := ""
+	if i := strings.IndexByte(req.URL, '?'); i != -1 {
+		query = req.URL[i+1:]
+	}
+
+	serverName, serverPort := splitHostPort(req.Host)
+	if serverPort == "" {
+		serverPort = "80"
+		if req.TLS != nil {
+			serverPort = "443"
+		}
+	}
+	remoteAddr, remotePort, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remoteAddr = req.RemoteAddr
+	}
+
+	env := []string{
+		"GATEWAY_INTERFACE=CGI/1.1",
+		"SERVER_SOFTWARE=tritonhttp",
+		"SERVER_PROTOCOL=" + req.Proto,
+		"SERVER_NAME=" + strings.Trim(serverName, "[]"),
+		"SERVER_PORT=" + serverPort,
+		"REQUEST_METHOD=" + req.Method,
+		"REQUEST_URI=" + req.URL,
+		"SCRIPT_NAME=" + h.Root,
+		"PATH_INFO=" + pathInfo,
+		"QUERY_STRING=" + query,
+		"REMOTE_ADDR=" + remoteAddr,
+		"REMOTE_HOST=" + remoteAddr,
+	}
+	if remotePort != "" {
+		env = append(env, "REMOTE_PORT="+remotePort)
+	}
+	if req.TLS != nil {
+		env = append(env, "HTTPS=on")
+	}
+	if req.Body != nil {
+		env = append(env, "CONTENT_LENGTH="+strconv.Itoa(contentLength))
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		env = append(env, "CONTENT_TYPE="+ct)
+	}
+	if req.Host != "" {
+		env = append(env, "HTTP_HOST="+req.Host)
+	}
+	for k, v := range req.Header {
+		switch k {
+		case "Content-Length", "Content-Type", "Host":
+			continue
+		case "Proxy":
+			// 防止脚本把它当成HTTP_PROXY代理设置 (httpoxy)
+			continue
+		}
+		sep := ", "
+		if k == "Cookie" {
+			sep = "; "
+		}
+		name := "HTTP_" + strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+		env = append(env, name+"="+strings.Join(v, sep))
+	}
+
+	for _, name := range append([]string{"PATH"}, h.InheritEnv...) {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	return append(env, h.Env...)
+}
+
+// acquire reserves a process slot, reporting false if none is free.
+func (h *CGIHandler) acquire() bool {
+	if h.MaxProcs <= 0 {
+		return true
+	}
+	h.once.Do(func() {
+		h.procs = make(chan struct{}, h.MaxProcs)
+	})
+	select {
+	case h.procs <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees the slot reserved by acquire.
+func (h *CGIHandler) release() {
+	if h.MaxProcs > 0 {
+		<-h.procs
+	}
+}
+
+func (h *CGIHandler) timeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	return DefaultCGITimeout
+}
+
+func (h *CGIHandler) errorLog() *ErrorLogger {
+	if h.ErrorLog != nil {
+		return h.ErrorLog
+	}
+	return defaultErrorLog
+}
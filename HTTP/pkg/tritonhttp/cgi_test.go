@@ -0,0 +1,168 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeScript writes a shell script with body to a temporary directory
+// and returns its path.
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("CGI tests need a POSIX shell")
+	}
+	path := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCGIHandler(t *testing.T) {
+	env := writeScript(t, `printf 'Content-Type: text/plain\n\n'
+for v in REQUEST_METHOD SCRIPT_NAME PATH_INFO QUERY_STRING SERVER_NAME SERVER_PORT CONTENT_LENGTH CONTENT_TYPE HTTP_HOST HTTP_X_TOKEN HTTP_PROXY; do
+	eval "printf '%s=%s\n' $v \"\$$v\""
+done
+printf 'body=%s\n' "$(cat)"
+`)
+
+	var tests = []struct {
+		name     string
+		script   string
+		reqText  string
+		wantLine string
+		contains []string
+	}{
+		{
+			"Environment",
+			env,
+			"POST /cgi-bin/env/a%20b?q=1 HTTP/1.1\r\nHost: example.com:8080\r\nX-Token: t\r\nProxy: evil\r\n" +
+				"Content-Type: text/plain\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			"HTTP/1.1 200 OK",
+			[]string{
+				"Content-Type: text/plain\r\n",
+				"REQUEST_METHOD=POST\nSCRIPT_NAME=/cgi-bin/env\nPATH_INFO=/a b\nQUERY_STRING=q=1\n" +
+					"SERVER_NAME=example.com\nSERVER_PORT=8080\nCONTENT_LENGTH=5\nCONTENT_TYPE=text/plain\n" +
+					"HTTP_HOST=example.com:8080\nHTTP_X_TOKEN=t\nHTTP_PROXY=\nbody=hello\n",
+			},
+		},
+		{
+			"Status",
+			writeScript(t, "printf 'Status: 404 Not Found\\r\\nContent-Type: text/plain\\r\\nX-Script: yes\\r\\n\\r\\nmissing'"),
+			"GET /cgi-bin/env HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 404 Not Found",
+			[]string{"X-Script: yes\r\n", "\r\n\r\nmissing"},
+		},
+		{
+			"Redirect",
+			writeScript(t, "printf 'Location: http://test/elsewhere\\n\\n'"),
+			"GET /cgi-bin/env HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 302 Found",
+			[]string{"Location: http://test/elsewhere\r\n"},
+		},
+		{
+			"MissingHeaders",
+			writeScript(t, "printf 'no headers'"),
+			"GET /cgi-bin/env HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 502 Bad Gateway",
+			nil,
+		},
+		{
+			"SiblingPrefix",
+			env,
+			"GET /cgi-bin/envx/foo HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 404 Not Found",
+			nil,
+		},
+		{
+			"Timeout",
+			writeScript(t, "exec sleep 5"),
+			"GET /cgi-bin/env HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 504 Gateway Timeout",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &CGIHandler{Path: tt.script, Root: "/cgi-bin/env", Timeout: 200 * time.Millisecond}
+			got := roundTrip(t, &Server{Handler: h}, tt.reqText)
+			if !strings.HasPrefix(got, tt.wantLine+"\r\n") {
+				t.Fatalf("got response: %q, want status line %q", got, tt.wantLine)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(got, s) {
+					t.Fatalf("got response: %q, want it to contain %q", got, s)
+				}
+			}
+		})
+	}
+}
+
+func TestCGIHandlerMaxProcs(t *testing.T) {
+	h := &CGIHandler{
+		Path:     writeScript(t, "sleep 0.3\nprintf 'Content-Type: text/plain\\n\\ndone'"),
+		MaxProcs: 1,
+	}
+	h.acquire() // creates h.procs
+	h.release()
+	reqText := "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"
+	first := make(chan string)
+	go func() {
+		first <- roundTrip(t, &Server{Handler: h}, reqText)
+	}()
+	for i := 0; i < 100 && len(h.procs) == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := roundTrip(t, &Server{Handler: h}, reqText); !strings.HasPrefix(got, "HTTP/1.1 503 Service Unavailable\r\n") {
+		t.Fatalf("second request got: %q", got)
+	}
+	if got := <-first; !strings.HasSuffix(got, "\r\n\r\ndone") {
+		t.Fatalf("first request got: %q", got)
+	}
+	if got := roundTrip(t, &Server{Handler: h}, reqText); !strings.HasSuffix(got, "\r\n\r\ndone") {
+		t.Fatalf("third request got: %q", got)
+	}
+}
+
+func TestCGIHandlerBackgroundProcess(t *testing.T) {
+	var log bytes.Buffer
+	h := &CGIHandler{
+		// sleep继承了stdout和stderr
+		Path:     writeScript(t, "printf 'Content-Type: text/plain\\n\\ndone'\nsleep 10 &"),
+		Timeout:  5 * time.Second,
+		ErrorLog: NewErrorLogger(&log, LevelWarn),
+	}
+	start := time.Now()
+	got := roundTrip(t, &Server{Handler: h}, "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	if !strings.HasSuffix(got, "\r\n\r\ndone") {
+		t.Fatalf("got response: %q", got)
+	}
+	if d := time.Since(start); d > 3*cgiWaitDelay {
+		t.Fatalf("response took %v, want at most %v", d, 3*cgiWaitDelay)
+	}
+}
+
+func TestCGIHandlerStderrLimit(t *testing.T) {
+	var log bytes.Buffer
+	h := &CGIHandler{
+		Path:     writeScript(t, "head -c 200000 /dev/zero | tr '\\0' x >&2\nprintf 'Content-Type: text/plain\\n\\ndone'"),
+		ErrorLog: NewErrorLogger(&log, LevelWarn),
+	}
+	got := roundTrip(t, &Server{Handler: h}, "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	if !strings.HasSuffix(got, "\r\n\r\ndone") {
+		t.Fatalf("got response: %q", got)
+	}
+	if n := strings.Count(log.String(), "x"); n != maxCGIStderrBytes {
+		t.Fatalf("logged %v bytes of stderr, want %v", n, maxCGIStderrBytes)
+	}
+	if !strings.Contains(log.String(), "[truncated]") {
+		t.Fatalf("got log without the truncation mark")
+	}
+}
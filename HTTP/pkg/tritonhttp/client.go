@@ -0,0 +1,735 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxRedirects is used when Client.MaxRedirects is zero.
+	DefaultMaxRedirects = 10
+
+	// DefaultMaxIdleConnsPerHost is used when Client.MaxIdleConnsPerHost is zero.
+	DefaultMaxIdleConnsPerHost = 2
+
+	// DefaultIdleConnTimeout is used when Client.IdleConnTimeout is zero.
+	DefaultIdleConnTimeout = 90 * time.Second
+)
+
+// ErrTooManyRedirects is returned by Client.Do, along with the last
+// response, when a request is redirected more than MaxRedirects times.
+var ErrTooManyRedirects = errors.New("tritonhttp: too many redirects")
+
+// ErrResponseTooLarge is returned by Client.Do when a response body
+// exceeds Client.MaxBodyBytes.
+var ErrResponseTooLarge = errors.New("tritonhttp: response body too large")
+
+// A Client sends requests and reads their responses over HTTP/1.1.
+// Keep-alive connections are kept in a pool per host and reused by later
+// requests. A Client is safe for concurrent use; the zero value is
+// ready to use.
+//
+// The body of each response is read to the end into Response.Body,
+// so that its connection can go back to the pool at once.
+type Client struct {
+	// TLSConfig is used for "https" URLs. If nil, the default
+	// configuration is used.
+	TLSConfig *tls.Config
+
+	// MaxRedirects is how many redirects Do follows for a request.
+	// If zero, DefaultMaxRedirects is used; if negative, redirects
+	// are returned to the caller.
+	MaxRedirects int
+
+	// MaxIdleConnsPerHost is how many idle connections are kept per
+	// host. If zero, DefaultMaxIdleConnsPerHost is used.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection stays in the pool.
+	// If zero, DefaultIdleConnTimeout is used.
+	IdleConnTimeout time.Duration
+
+	// MaxBodyBytes limits the size of response bodies. If zero,
+	// DefaultMaxBodyBytes is used.
+	MaxBodyBytes int64
+
+	mu   sync.Mutex
+	idle map[string][]*clientConn // by "scheme://host:port", oldest first
+}
+
+// clientConn is a connection to a server, with its buffers.
+type clientConn struct {
+	key    string
+	conn   net.Conn
+	br     *bufio.Reader
+	bw     *bufio.Writer
+	idleAt time.Time
+	reused bool
+}
+
+// NewRequest returns a request for the absolute "http" or "https" URL
+// rawURL. The request keeps the whole URL in req.URL, from which the
+// client knows where to send it. If body is a *bytes.Buffer, a
+// *bytes.Reader or a *strings.Reader, ContentLength is set to its length;
+// other bodies are sent chunked.
+func NewRequest(method, rawURL string, body io.Reader) (*Request, error) {
+	if !isToken(method) {
+		return nil, fmt.Errorf("tritonhttp: invalid method %q", method)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("tritonhttp: unsupported URL scheme %q", u.Scheme)
+	}
+	if u.Host == "" || !ValidHost(u.Host) {
+		return nil, fmt.Errorf("tritonhttp: invalid host in URL %q", rawURL)
+	}
+	u.Fragment = ""
+	req := &Request{
+		Method: method,
+		URL:    u.String(),
+		Proto:  "HTTP/1.1",
+		Header: make(Header),
+		Host:   u.Host,
+		Body:   body,
+	}
+	switch b := body.(type) {
+	case nil:
+	case *bytes.Buffer:
+		req.ContentLength = int64(b.Len())
+	case *bytes.Reader:
+		req.ContentLength = int64(b.Len())
+	case *strings.Reader:
+		req.ContentLength = int64(b.Len())
+	default:
+		req.ContentLength = -1
+	}
+	if req.ContentLength == 0 {
+		req.Body = nil
+	}
+	return req, nil
+}
+
+// requestURL returns the absolute URL req is sent to. A request whose
+// URL is a path, as read by ReadRequest, goes to req.Host over "http".
+func requestURL(req *Request) (*url.URL, error) {
+	if strings.HasPrefix(req.URL, "/") {
+		if req.Host == "" {
+			return nil, errors.New("tritonhttp: request without host")
+		}
+		return url.Parse("http://" + req.Host + req.URL)
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("tritonhttp: unsupported URL %q", req.URL)
+	}
+	return u, nil
+}
+
+// connKey returns the pool key and the dial address of u.
+func connKey(u *url.URL) (key, addr string) {
+	addr = u.Host
+	if _, port := splitHostPort(addr); port == "" {
+		if u.Scheme == "https" {
+			addr += ":443"
+		} else {
+			addr += ":80"
+		}
+	}
+	return u.Scheme + "://" + strings.ToLower(addr), addr
+}
+
+// Write writes req to w in the wire format, as a client sends it.
+// An absolute URL is sent in origin-form, its host in the "Host" header.
+// The body is sent with "Content-Length" when ContentLength is positive,
+// and chunked otherwise.
+func (req *Request) Write(w io.Writer) error {
+	target, host := req.URL, req.Host
+	if !strings.HasPrefix(target, "/") && target != "*" {
+		u, err := requestURL(req)
+		if err != nil {
+			return err
+		}
+		target = u.RequestURI()
+		if host == "" {
+			host = u.Host
+		}
+	}
+	proto := req.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(req.Method + " " + target + " " + proto + CRLF)
+	bw.WriteString("Host: " + host + CRLF)
+
+	// 消息的分帧由Write决定 忽略调用者设置的相关头部
+	header := req.Header.Clone()
+	if header == nil {
+		header = make(Header)
+	}
+	for _, k := range []string{"Host", "Connection", "Content-Length", "Transfer-Encoding"} {
+		header.Del(k)
+	}
+	if req.Close {
+		header.Set("Connection", "close")
+	}
+	switch {
+	case req.Body != nil && req.ContentLength > 0:
+		header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	case req.Body != nil:
+		header.Set("Transfer-Encoding", "chunked")
+	case req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH":
+		header.Set("Content-Length", "0")
+	}
+	if err := header.Write(bw); err != nil {
+		return err
+	}
+	bw.WriteString(CRLF)
+
+	if req.Body != nil {
+		if req.ContentLength > 0 {
+			n, err := io.CopyN(bw, req.Body, req.ContentLength)
+			if err != nil {
+				return fmt.Errorf("tritonhttp: request body: wrote %v of %v bytes: %v", n, req.ContentLength, err)
+			}
+		} else {
+			buf := make([]byte, 32<<10)
+			for {
+				n, err := req.Body.Read(buf)
+				if n > 0 {
+					fmt.Fprintf(bw, "%x"+CRLF, n)
+					bw.Write(buf[:n])
+					bw.WriteString(CRLF)
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+			}
+			bw.WriteString("0" + CRLF + CRLF)
+		}
+	}
+	return bw.Flush()
+}
+
+// readResponse reads the response to req from br, with its body up to
+// maxBody bytes. keepAlive reports whether the connection can carry
+// another request.
+func readResponse(br *bufio.Reader, req *Request, maxBody int64) (res *Response, keepAlive bool, err error) {
+	res, err = readResponseHeader(br)
+	if err != nil {
+		return nil, false, err
+	}
+	res.Request = req
+	body, keepAlive, err := responseBody(br, res)
+	if err != nil || body == nil {
+		return res, keepAlive, err
+	}
+	if cl, ok := body.(*contentLengthReader); ok && cl.n > maxBody {
+		return nil, false, ErrResponseTooLarge
+	}
+	res.Body, err = io.ReadAll(&maxBytesReader{r: body, n: maxBody})
+	if errors.Is(err, ErrBodyTooLarge) {
+		err = ErrResponseTooLarge
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if cr, ok := body.(*chunkedReader); ok {
+		res.Trailer = cr.req.Trailer
+	}
+	return res, keepAlive, nil
+}
+
+// responseBody returns the reader of the body of res, which answers
+// res.Request, or nil if it has none. keepAlive reports whether the
+// connection can carry another request once the body is read.
+func responseBody(br *bufio.Reader, res *Response) (body io.Reader, keepAlive bool, err error) {
+	req := res.Request
+	conn := res.Header.Join("Connection")
+	keepAlive = !hasToken(conn, "close") && !req.Close &&
+		(res.Proto == "HTTP/1.1" || hasToken(conn, "keep-alive"))
+
+	// RFC 9112 6.3: 按顺序确定响应body的长度
+	switch {
+	case res.StatusCode == 101:
+		return nil, false, nil
+	case req.Method == "HEAD" || res.StatusCode == 204 || res.StatusCode == 304:
+		return nil, keepAlive, nil
+	case res.Header.Has("Transfer-Encoding"):
+		if !strings.EqualFold(strings.TrimSpace(res.Header.Join("Transfer-Encoding")), "chunked") {
+			return nil, false, fmt.Errorf("tritonhttp: unsupported Transfer-Encoding %q", res.Header.Join("Transfer-Encoding"))
+		}
+		return &chunkedReader{br: br, req: &Request{}}, keepAlive, nil
+	case res.Header.Has("Content-Length"):
+		n, err := parseContentLength(res.Header.Join("Content-Length"))
+		if err != nil {
+			return nil, false, err
+		}
+		return &contentLengthReader{r: br, n: n}, keepAlive, nil
+	}
+	// body以关闭连接结束
+	return br, false, nil
+}
+
+// readResponseHeader reads the status line and the headers of the final
+// response from br. Interim 1xx responses other than 101 are skipped.
+func readResponseHeader(br *bufio.Reader) (*Response, error) {
+	for {
+		res, err := readStatusAndHeader(br)
+		if err != nil || res.StatusCode >= 200 || res.StatusCode == 101 {
+			return res, err
+		}
+	}
+}
+
+// readStatusAndHeader reads a status line and the headers following it.
+func readStatusAndHeader(br *bufio.Reader) (*Response, error) {
+	line, err := ReadLine(br)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("tritonhttp: malformed status line %q", line)
+	}
+	if err := checkVersion(parts[0]); err != nil {
+		return nil, fmt.Errorf("tritonhttp: malformed status line %q", line)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 3 || code < 100 {
+		return nil, fmt.Errorf("tritonhttp: malformed status code in %q", line)
+	}
+	res := &Response{StatusCode: code, Proto: parts[0], Header: make(Header)}
+	for {
+		line, err := ReadLine(br)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if line == "" {
+			return res, nil
+		}
+		k, v, err := parseHeaderLine(line)
+		if err != nil {
+			return nil, err
+		}
+		res.Header.Add(k, v)
+	}
+}
+
+// Get sends a GET request for rawURL. See Do.
+func (c *Client) Get(ctx context.Context, rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, req)
+}
+
+// Do sends req and returns its response, following redirects up to
+// MaxRedirects. ctx bounds the whole exchange, redirects included:
+// its deadline applies to dialing, writing and reading, and cancelling
+// it aborts the request.
+//
+// A 303 redirect, and a 301 or 302 redirect of a POST, is followed with
+// GET and no body. 307 and 308 keep the method and the body, which is
+// only possible for a body implementing io.Seeker; otherwise the redirect
+// response is returned. Credentials are not sent to another host.
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	for hops := 0; ; hops++ {
+		res, err := c.roundTrip(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		next := c.redirect(req, res)
+		if next == nil {
+			return res, nil
+		}
+		if hops >= c.maxRedirects() {
+			return res, ErrTooManyRedirects
+		}
+		req = next
+	}
+}
+
+// redirect returns the request following the redirect res,
+// or nil if res is not to be followed.
+func (c *Client) redirect(req *Request, res *Response) *Request {
+	if c.MaxRedirects < 0 {
+		return nil
+	}
+	switch res.StatusCode {
+	case 301, 302, 303, 307, 308:
+	default:
+		return nil
+	}
+	loc := res.Header.Get("Location")
+	if loc == "" {
+		return nil
+	}
+	base, err := requestURL(req)
+	if err != nil {
+		return nil
+	}
+	ref, err := url.Parse(loc)
+	if err != nil {
+		return nil
+	}
+	u := base.ResolveReference(ref)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil
+	}
+	u.Fragment = ""
+
+	next := &Request{
+		Method: req.Method,
+		URL:    u.String(),
+		Proto:  req.Proto,
+		Header: req.Header.Clone(),
+		Host:   u.Host,
+		Close:  req.Close,
+	}
+	if next.Header == nil {
+		next.Header = make(Header)
+	}
+	if res.StatusCode == 303 && req.Method != "HEAD" ||
+		(res.StatusCode == 301 || res.StatusCode == 302) && req.Method == "POST" {
+		next.Method = "GET"
+		next.Header.Del("Content-Type")
+	} else if req.Body != nil {
+		s, ok := req.Body.(io.Seeker)
+		if !ok {
+			return nil
+		}
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return nil
+		}
+		next.Body, next.ContentLength = req.Body, req.ContentLength
+	}
+	if !strings.EqualFold(u.Host, base.Host) {
+		next.Header.Del("Authorization")
+		next.Header.Del("Cookie")
+	}
+	return next
+}
+
+// roundTrip sends req on a pooled or new connection and reads its
+// response. A request failing on a reused connection, which the server
+// may have closed in the meantime, is retried once on a new one when it
+// is safe to send it again.
+func (c *Client) roundTrip(ctx context.Context, req *Request) (*Response, error) {
+	u, err := requestURL(req)
+	if err != nil {
+		return nil, err
+	}
+	key, addr := connKey(u)
+	for {
+		cc, err := c.getConn(ctx, key, u.Scheme, addr)
+		if err != nil {
+			return nil, err
+		}
+		stop := watchContext(ctx, cc.conn)
+		res, keepAlive, err := c.exchange(cc, req)
+		if err == nil && stop() {
+			if keepAlive {
+				c.putConn(cc)
+			} else {
+				cc.conn.Close()
+			}
+			return res, nil
+		}
+		stop()
+		cc.conn.Close()
+		if err := contextErr(ctx); err != nil {
+			return nil, err
+		}
+		if cc.reused && req.Body == nil && isIdempotent(req.Method) {
+			continue
+		}
+		return nil, err
+	}
+}
+
+// exchange writes req to cc and reads its response.
+func (c *Client) exchange(cc *clientConn, req *Request) (*Response, bool, error) {
+	if err := req.Write(cc.bw); err != nil {
+		return nil, false, err
+	}
+	if err := cc.bw.Flush(); err != nil {
+		return nil, false, err
+	}
+	return readResponse(cc.br, req, c.maxBodyBytes())
+}
+
+// DoPipelined sends reqs on a single connection without waiting for the
+// responses, which are read back in order. All requests must go to the
+// same host and use idempotent methods without a body, so that the ones
+// left unanswered when the server closes the connection can be sent
+// again on a new one. Redirects are not followed.
+func (c *Client) DoPipelined(ctx context.Context, reqs []*Request) ([]*Response, error) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	var key, scheme, addr string
+	for i, req := range reqs {
+		if req.Body != nil || !isIdempotent(req.Method) {
+			return nil, fmt.Errorf("tritonhttp: cannot pipeline %v request with a body or a non-idempotent method", req.Method)
+		}
+		u, err := requestURL(req)
+		if err != nil {
+			return nil, err
+		}
+		k, a := connKey(u)
+		if i > 0 && k != key {
+			return nil, fmt.Errorf("tritonhttp: cannot pipeline requests to %v and %v", key, k)
+		}
+		key, scheme, addr = k, u.Scheme, a
+	}
+
+	responses := make([]*Response, 0, len(reqs))
+	for len(responses) < len(reqs) {
+		pending := reqs[len(responses):]
+		cc, err := c.getConn(ctx, key, scheme, addr)
+		if err != nil {
+			return responses, err
+		}
+		stop := watchContext(ctx, cc.conn)
+
+		// 在另一个goroutine中写请求 避免双方的缓冲区都满时死锁
+		writeErr := make(chan error, 1)
+		go func() {
+			for _, req := range pending {
+				if err := req.Write(cc.bw); err != nil {
+					writeErr <- err
+					return
+				}
+			}
+			writeErr <- cc.bw.Flush()
+		}()
+		keepAlive := true
+		got := 0
+		for _, req := range pending {
+			var res *Response
+			res, keepAlive, err = readResponse(cc.br, req, c.maxBodyBytes())
+			if err != nil {
+				break
+			}
+			responses = append(responses, res)
+			got++
+			if !keepAlive {
+				break
+			}
+		}
+		werr := <-writeErr
+		stop()
+		if err := contextErr(ctx); err != nil {
+			cc.conn.Close()
+			return responses, err
+		}
+		if got == len(pending) && keepAlive && werr == nil {
+			c.putConn(cc)
+			break
+		}
+		cc.conn.Close()
+		if got == 0 && !cc.reused {
+			// 新连接上一个响应都没有 不再重试
+			if err == nil {
+				err = werr
+			}
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return responses, err
+		}
+	}
+	return responses, nil
+}
+
+// isIdempotent reports whether a request with method can be sent again
+// without changing its effect, RFC 9110 section 9.2.2.
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// watchContext applies the deadline of ctx to conn and interrupts conn
+// when ctx is cancelled. The returned stop function ends the watch and
+// reports whether ctx was still alive.
+func watchContext(ctx context.Context, conn net.Conn) (stop func() bool) {
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+	var once sync.Once
+	var alive bool
+	return func() bool {
+		once.Do(func() {
+			close(done)
+			alive = !<-interrupted && ctx.Err() == nil
+			conn.SetDeadline(time.Time{})
+		})
+		return alive
+	}
+}
+
+// contextErr returns the error of ctx, wrapped, if ctx is done or its
+// deadline has passed, and nil otherwise. The deadline set on a
+// connection by watchContext can expire just before ctx does, failing
+// an operation with a timeout while ctx.Err() is still nil.
+func contextErr(ctx context.Context) error {
+	if ctx.Err() == nil {
+		deadline, ok := ctx.Deadline()
+		if !ok || time.Now().Before(deadline) {
+			return nil
+		}
+		<-ctx.Done()
+	}
+	return fmt.Errorf("tritonhttp: %w", ctx.Err())
+}
+
+// getConn returns an idle connection for key, or dials a new one.
+func (c *Client) getConn(ctx context.Context, key, scheme, addr string) (*clientConn, error) {
+	c.mu.Lock()
+	conns := c.idle[key]
+	for len(conns) > 0 {
+		cc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(cc.idleAt) < c.idleConnTimeout() {
+			c.idle[key] = conns
+			c.mu.Unlock()
+			cc.reused = true
+			return cc, nil
+		}
+		cc.conn.Close()
+	}
+	delete(c.idle, key)
+	c.mu.Unlock()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		if cerr := contextErr(ctx); cerr != nil {
+			return nil, cerr
+		}
+		return nil, err
+	}
+	if scheme == "https" {
+		var config *tls.Config
+		if c.TLSConfig != nil {
+			config = c.TLSConfig.Clone()
+		} else {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			config.ServerName, _ = splitHostPort(addr)
+			config.ServerName = strings.Trim(config.ServerName, "[]")
+		}
+		if len(config.NextProtos) == 0 {
+			config.NextProtos = []string{"http/1.1"}
+		}
+		tc := tls.Client(conn, config)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			if cerr := contextErr(ctx); cerr != nil {
+				return nil, cerr
+			}
+			return nil, err
+		}
+		conn = tc
+	}
+	return &clientConn{
+		key:  key,
+		conn: conn,
+		br:   bufio.NewReader(conn),
+		bw:   bufio.NewWriter(conn),
+	}, nil
+}
+
+// putConn returns cc to the pool, or closes it if the pool is full.
+func (c *Client) putConn(cc *clientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idle == nil {
+		c.idle = make(map[string][]*clientConn)
+	}
+	if len(c.idle[cc.key]) >= c.maxIdleConnsPerHost() {
+		cc.conn.Close()
+		return
+	}
+	cc.idleAt = time.Now()
+	c.idle[cc.key] = append(c.idle[cc.key], cc)
+}
+
+// CloseIdleConnections closes the connections kept in the pool.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conns := range c.idle {
+		for _, cc := range conns {
+			cc.conn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+func (c *Client) maxRedirects() int {
+	if c.MaxRedirects > 0 {
+		return c.MaxRedirects
+	}
+	return DefaultMaxRedirects
+}
+
+func (c *Client) maxIdleConnsPerHost() int {
+	if c.MaxIdleConnsPerHost > 0 {
+		return c.MaxIdleConnsPerHost
+	}
+	return DefaultMaxIdleConnsPerHost
+}
+
+func (c *Client) idleConnTimeout() time.Duration {
+	if c.IdleConnTimeout > 0 {
+		return c.IdleConnTimeout
+	}
+	return DefaultIdleConnTimeout
+}
+
+func (c *Client) maxBodyBytes() int64 {
+	if c.MaxBodyBytes > 0 {
+		return c.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
@@ -0,0 +1,337 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestWrite(t *testing.T) {
+	var tests = []struct {
+		name    string
+		method  string
+		url     string
+		body    io.Reader
+		header  Header
+		close   bool
+		reqWant string
+	}{
+		{
+			"Get",
+			"GET",
+			"http://test:8080/a/b?q=1#frag",
+			nil,
+			Header{"User-Agent": {"triton"}, "Connection": {"upgrade"}},
+			false,
+			"GET /a/b?q=1 HTTP/1.1\r\nHost: test:8080\r\nUser-Agent: triton\r\n\r\n",
+		},
+		{
+			"ContentLength",
+			"POST",
+			"http://test/api",
+			strings.NewReader("hello"),
+			nil,
+			true,
+			"POST /api HTTP/1.1\r\nHost: test\r\nConnection: close\r\nContent-Length: 5\r\n\r\nhello",
+		},
+		{
+			"Chunked",
+			"PUT",
+			"http://test",
+			io.MultiReader(strings.NewReader("hello")),
+			nil,
+			false,
+			"PUT / HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+		},
+		{
+			"EmptyPost",
+			"POST",
+			"http://test/api",
+			nil,
+			nil,
+			false,
+			"POST /api HTTP/1.1\r\nHost: test\r\nContent-Length: 0\r\n\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := NewRequest(tt.method, tt.url, tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			req.Close = tt.close
+			var buf bytes.Buffer
+			if err := req.Write(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.reqWant {
+				t.Fatalf("got: %q, want: %q", buf.String(), tt.reqWant)
+			}
+			// What is written must read back as the same request
+			got, _, err := ReadRequest(bufio.NewReader(&buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Method != tt.method || got.Close != tt.close {
+				t.Fatalf("read back: %v", got)
+			}
+		})
+	}
+
+	for _, url := range []string{"/path", "ftp://test/", "http://", "http://a b/"} {
+		if _, err := NewRequest("GET", url, nil); err == nil {
+			t.Errorf("NewRequest(%q) got no error", url)
+		}
+	}
+}
+
+func TestReadResponse(t *testing.T) {
+	var tests = []struct {
+		name          string
+		method        string
+		resText       string
+		statusWant    int
+		bodyWant      string
+		trailerWant   Header
+		keepAliveWant bool
+	}{
+		{
+			"ContentLength",
+			"GET",
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+			200, "hello", nil, true,
+		},
+		{
+			"Chunked",
+			"GET",
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nChecksum: abc\r\n\r\n",
+			200, "hello", Header{"Checksum": {"abc"}}, true,
+		},
+		{
+			"UntilClose",
+			"GET",
+			"HTTP/1.0 200 OK\r\n\r\nhello",
+			200, "hello", nil, false,
+		},
+		{
+			"Head",
+			"HEAD",
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n",
+			200, "", nil, true,
+		},
+		{
+			"Interim",
+			"GET",
+			"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n",
+			204, "", nil, false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Method: tt.method}
+			res, keepAlive, err := readResponse(bufio.NewReader(strings.NewReader(tt.resText)), req, 16)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.statusWant || string(res.Body) != tt.bodyWant || keepAlive != tt.keepAliveWant {
+				t.Fatalf("got: %v %q keep-alive %v, want: %v %q keep-alive %v",
+					res.StatusCode, res.Body, keepAlive, tt.statusWant, tt.bodyWant, tt.keepAliveWant)
+			}
+			if !reflect.DeepEqual(res.Trailer, tt.trailerWant) {
+				t.Fatalf("trailer got: %v, want: %v", res.Trailer, tt.trailerWant)
+			}
+		})
+	}
+
+	resText := "HTTP/1.1 200 OK\r\nContent-Length: 17\r\n\r\n" + strings.Repeat("x", 17)
+	_, _, err := readResponse(bufio.NewReader(strings.NewReader(resText)), &Request{Method: "GET"}, 16)
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("got error: %v, want: %v", err, ErrResponseTooLarge)
+	}
+}
+
+// clientTestServer starts a server for the client tests. Every response
+// carries the remote address of the client, to tell connections apart.
+func clientTestServer(t *testing.T) (string, *Server) {
+	t.Helper()
+	mux := NewServeMux()
+	mux.HandleFunc("/addr", func(w ResponseWriter, req *Request) {
+		io.WriteString(w, req.RemoteAddr)
+	})
+	mux.HandleFunc("/echo", func(w ResponseWriter, req *Request) {
+		io.WriteString(w, req.Method+" ")
+		if req.Body != nil {
+			io.Copy(w, req.Body)
+		}
+	})
+	mux.HandleFunc("/large", func(w ResponseWriter, req *Request) {
+		io.WriteString(w, strings.Repeat("x", bufferBeforeChunking+1))
+	})
+	mux.HandleFunc("/redirect", func(w ResponseWriter, req *Request) {
+		w.Header().Set("Location", "/echo")
+		w.WriteHeader(303)
+	})
+	mux.HandleFunc("/keep", func(w ResponseWriter, req *Request) {
+		w.Header().Set("Location", "echo")
+		w.WriteHeader(307)
+	})
+	mux.HandleFunc("/loop", func(w ResponseWriter, req *Request) {
+		w.Header().Set("Location", "http://"+req.Host+"/loop")
+		w.WriteHeader(302)
+	})
+	mux.HandleFunc("/slow", func(w ResponseWriter, req *Request) {
+		time.Sleep(500 * time.Millisecond)
+	})
+	s := &Server{Handler: mux}
+	addr, _ := startServer(t, s)
+	return "http://" + addr, s
+}
+
+func TestClientDo(t *testing.T) {
+	base, s := clientTestServer(t)
+	defer s.Close()
+
+	var tests = []struct {
+		name       string
+		method     string
+		path       string
+		body       io.Reader
+		statusWant int
+		bodyWant   string
+		errWant    error
+	}{
+		{"ContentLength", "POST", "/echo", strings.NewReader("hello"), 200, "POST hello", nil},
+		{"ChunkedRequest", "PUT", "/echo", io.MultiReader(strings.NewReader("hello")), 200, "PUT hello", nil},
+		{"ChunkedResponse", "GET", "/large", nil, 200, strings.Repeat("x", bufferBeforeChunking+1), nil},
+		{"SeeOther", "POST", "/redirect", strings.NewReader("hello"), 200, "GET ", nil},
+		{"TemporaryRedirect", "POST", "/keep", strings.NewReader("hello"), 200, "POST hello", nil},
+		{"TooManyRedirects", "GET", "/loop", nil, 302, "", ErrTooManyRedirects},
+	}
+
+	c := &Client{MaxRedirects: 3}
+	defer c.CloseIdleConnections()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := NewRequest(tt.method, base+tt.path, tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := c.Do(context.Background(), req)
+			if err != tt.errWant {
+				t.Fatalf("got error: %v, want: %v", err, tt.errWant)
+			}
+			if res.StatusCode != tt.statusWant {
+				t.Fatalf("got status: %v, want: %v", res.StatusCode, tt.statusWant)
+			}
+			if !strings.HasPrefix(string(res.Body), tt.bodyWant) {
+				t.Fatalf("got body: %q, want: %q", res.Body, tt.bodyWant)
+			}
+		})
+	}
+}
+
+func TestClientReusesConnections(t *testing.T) {
+	base, s := clientTestServer(t)
+	defer s.Close()
+
+	c := &Client{}
+	defer c.CloseIdleConnections()
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		res, err := c.Get(context.Background(), base+"/addr")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, string(res.Body))
+	}
+	if addrs[0] != addrs[1] || addrs[1] != addrs[2] {
+		t.Fatalf("got client addresses: %v, want a single connection", addrs)
+	}
+
+	// A pooled connection closed by the server is replaced transparently
+	c.mu.Lock()
+	for _, cc := range c.idle {
+		cc[0].conn.Close()
+	}
+	c.mu.Unlock()
+	res, err := c.Get(context.Background(), base+"/addr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Body) == addrs[0] {
+		t.Fatalf("got the closed connection %v again", addrs[0])
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	base, s := clientTestServer(t)
+	defer s.Close()
+
+	c := &Client{}
+	defer c.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Get(ctx, base+"/slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error: %v, want: %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 400*time.Millisecond {
+		t.Fatalf("request returned after %v", d)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := c.Get(ctx, base+"/slow"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error: %v, want: %v", err, context.Canceled)
+	}
+}
+
+func TestClientPipelined(t *testing.T) {
+	base, s := clientTestServer(t)
+	defer s.Close()
+
+	c := &Client{}
+	defer c.CloseIdleConnections()
+	var reqs []*Request
+	for _, path := range []string{"/addr", "/echo", "/large", "/addr"} {
+		req, err := NewRequest("GET", base+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqs = append(reqs, req)
+	}
+	responses, err := c.DoPipelined(context.Background(), reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != len(reqs) {
+		t.Fatalf("got %v responses, want: %v", len(responses), len(reqs))
+	}
+	for i, res := range responses {
+		if res.Request != reqs[i] || res.StatusCode != 200 {
+			t.Fatalf("response %v: got %v for %v", i, res.StatusCode, res.Request.URL)
+		}
+	}
+	if string(responses[1].Body) != "GET " || len(responses[2].Body) != bufferBeforeChunking+1 {
+		t.Fatalf("got bodies %q and %v bytes", responses[1].Body, len(responses[2].Body))
+	}
+	if string(responses[0].Body) != string(responses[3].Body) {
+		t.Fatalf("got client addresses: %s and %s, want a single connection", responses[0].Body, responses[3].Body)
+	}
+
+	post, _ := NewRequest("POST", base+"/echo", strings.NewReader("x"))
+	if _, err := c.DoPipelined(context.Background(), []*Request{reqs[0], post}); err == nil {
+		t.Fatal("pipelined a POST request")
+	}
+}
@@ -0,0 +1,208 @@
+package tritonhttp
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressMinSize is the smallest file compressed on the fly
+// when FileHandler.CompressMinSize is zero. Below it, the framing of
+// gzip costs about as much as it saves.
+const DefaultCompressMinSize = 1024
+
+// PrecompressedExt is the extension of the precompressed sibling of a
+// file, e.g. "app.js.gz" next to "app.js", sent to clients accepting gzip.
+const PrecompressedExt = ".gz"
+
+// compressibleTypes are the types worth compressing besides text/*.
+var compressibleTypes = map[string]bool{
+	"application/javascript":   true,
+	"application/json":         true,
+	"application/wasm":         true,
+	"application/xml":          true,
+	"image/svg+xml":            true,
+	"image/vnd.microsoft.icon": true,
+}
+
+// compressible reports whether content of type ctype shrinks when
+// compressed. Images, audio and video are compressed already.
+func compressible(ctype string) bool {
+	if i := strings.IndexByte(ctype, ';'); i != -1 {
+		ctype = ctype[:i]
+	}
+	ctype = strings.ToLower(strings.TrimSpace(ctype))
+	return strings.HasPrefix(ctype, "text/") || compressibleTypes[ctype] ||
+		strings.HasSuffix(ctype, "+json") || strings.HasSuffix(ctype, "+xml")
+}
+
+// negotiateEncoding picks the content-coding of offers the "Accept-Encoding"
+// header accept prefers, as in RFC 9110 section 12.5.3. Ties go to the
+// earlier offer. It returns "" when the content should not be encoded,
+// including when accept is empty.
+func negotiateEncoding(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return ""
+	}
+	qs := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		coding, q := part, 1.0
+		if i := strings.IndexByte(part, ';'); i != -1 {
+			coding = part[:i]
+			param := strings.TrimSpace(part[i+1:])
+			if !strings.HasPrefix(strings.ToLower(param), "q=") {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(param[2:]), 64)
+			if err != nil || v < 0 || v > 1 {
+				continue
+			}
+			q = v
+		}
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+		if coding != "" {
+			qs[coding] = q
+		}
+	}
+	qOf := func(coding string) float64 {
+		if q, ok := qs[coding]; ok {
+			return q
+		}
+		if q, ok := qs["*"]; ok {
+			return q
+		}
+		if coding == "identity" {
+			return 0.001 // identity is acceptable unless excluded
+		}
+		return 0
+	}
+	// Encoding wins ties with identity, e.g. for "*"
+	best, bestQ := "", qOf("identity")
+	for _, coding := range offers {
+		q := qOf(coding)
+		if q > bestQ || (q > 0 && q == bestQ && best == "") {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// addVary adds field to the "Vary" header in h.
+func addVary(h Header, field string) {
+	for _, f := range strings.Split(h.Join("Vary"), ",") {
+		if strings.EqualFold(strings.TrimSpace(f), field) {
+			return
+		}
+	}
+	h.Add("Vary", field)
+}
+
+// encodedETag derives the entity tag of the coding representation
+// from the tag etag of the identity representation.
+func encodedETag(etag, coding string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + coding + `"`
+}
+
+// encode negotiates the content-coding of the file prepared in res.
+// A precompressed sibling is served in place of the file by changing
+// res; otherwise the headers are set for compressing on the fly and
+// the coding to apply is returned. It returns "" when nothing is left
+// to do.
+func (f *FileHandler) encode(req *Request, res *Response) string {
+	gzPath := res.FilePath + PrecompressedExt
+	gzInfo, err := os.Stat(gzPath)
+	precompressed := err == nil && gzInfo.Mode().IsRegular() &&
+		(!f.ConfineSymlinks || withinRoot(f.DocRoot, gzPath))
+	if !precompressed && !compressible(res.Header.Get("Content-Type")) {
+		return ""
+	}
+	// The response depends on "Accept-Encoding" even when not encoded
+	addVary(res.Header, "Accept-Encoding")
+
+	size, _ := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	minSize := f.CompressMinSize
+	if minSize == 0 {
+		minSize = DefaultCompressMinSize
+	}
+	var offers []string
+	if precompressed {
+		offers = []string{"gzip"}
+	} else if _, ok := f.compressLevel(); !ok {
+		// 级别无效时不能在线压缩 文件原样发送
+		f.errorLog().Warnf("invalid CompressLevel %v, %v sent uncompressed", f.CompressLevel, res.FilePath)
+	} else if size >= minSize {
+		offers = []string{"gzip", "deflate"}
+	}
+	coding := negotiateEncoding(req.Header.Join("Accept-Encoding"), offers)
+	if coding == "" {
+		return ""
+	}
+	res.Header.Set("Content-Encoding", coding)
+	if precompressed {
+		res.FilePath = gzPath
+		res.Header.Set("Content-Length", strconv.FormatInt(gzInfo.Size(), 10))
+		res.Header.Set("Etag", FileETag(gzInfo, strings.HasPrefix(res.Header.Get("Etag"), "W/")))
+		return ""
+	}
+	// Compressed on the fly: the length is unknown and ranges of the
+	// compressed stream cannot be served
+	res.Header.Set("Etag", encodedETag(res.Header.Get("Etag"), coding))
+	res.Header.Del("Content-Length")
+	res.Header.Del("Accept-Ranges")
+	return coding
+}
+
+// serveCompressed sends the file prepared in res, compressed with coding.
+// HEAD requests are compressed too, so that the length announced by
+// the ResponseWriter is the one GET would get.
+func (f *FileHandler) serveCompressed(w ResponseWriter, res *Response, coding string) {
+	file, err := os.Open(res.FilePath)
+	if err != nil {
+		nf := &Response{}
+		nf.HandleNotFound(res.Request)
+		if f.ErrorPages != nil {
+			nf.setErrorPage(f.ErrorPages, f.DocRoot, f.errorLog())
+		}
+		nf.Serve(w)
+		return
+	}
+	defer file.Close()
+
+	h := w.Header()
+	for k, v := range res.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(200)
+
+	// encode only offers compression on the fly with a valid level
+	level, _ := f.compressLevel()
+	var zw io.WriteCloser
+	if coding == "deflate" {
+		// "deflate" is the zlib format, as RFC 9110 section 8.4.1.2 says
+		zw, _ = zlib.NewWriterLevel(w, level)
+	} else {
+		zw, _ = gzip.NewWriterLevel(w, level)
+	}
+	if _, err := io.Copy(zw, file); err != nil {
+		f.errorLog().Infof("compressing %v: %v", res.FilePath, err)
+	}
+	zw.Close()
+}
+
+// compressLevel returns the level of compression on the fly, and false
+// if CompressLevel is out of range.
+func (f *FileHandler) compressLevel() (int, bool) {
+	if f.CompressLevel == 0 {
+		return gzip.DefaultCompression, true
+	}
+	return f.CompressLevel, f.CompressLevel >= gzip.HuffmanOnly && f.CompressLevel <= gzip.BestCompression
+}
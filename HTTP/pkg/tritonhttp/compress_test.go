@@ -0,0 +1,179 @@
+package tritonhttp
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	offers := []string{"gzip", "deflate"}
+	var tests = []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"GZIP;Q=0.8", "gzip"},
+		{"x-gzip", "gzip"},
+		{"br", ""},
+		{"*", "gzip"},
+		{"*;q=0.5, gzip;q=0", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"identity;q=1, gzip;q=0.5", ""},
+		{"identity;q=0, gzip;q=0.1", "gzip"},
+		{"gzip;q=bogus, deflate", "deflate"},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.accept, offers); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) got: %q, want: %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+// splitResponse splits a response with a "Content-Length" into its
+// header section and its body.
+func splitResponse(t *testing.T, resp string) (string, string) {
+	t.Helper()
+	i := strings.Index(resp, "\r\n\r\n")
+	if i == -1 {
+		t.Fatalf("got response without header end: %q", resp)
+	}
+	return resp[:i+2], resp[i+4:]
+}
+
+func TestFileHandlerCompress(t *testing.T) {
+	dir := t.TempDir()
+	page := strings.Repeat("<p>compress me</p>\n", 200)
+	files := map[string]string{
+		"page.html":      page,
+		"small.css":      "body {}",
+		"photo.png":      "\x89PNG\r\n\x1a\n" + strings.Repeat("x", 2000),
+		"app.js":         "console.log('plain')",
+		"app.js" + ".gz": "precompressed bytes",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{DocRoot: dir, Compress: true, CompressLevel: gzip.BestSpeed}
+	get := func(method, url, accept string) (string, string) {
+		reqText := method + " " + url + " HTTP/1.1\r\nHost: test\r\nConnection: close\r\n"
+		if accept != "" {
+			reqText += "Accept-Encoding: " + accept + "\r\n"
+		}
+		return splitResponse(t, roundTrip(t, s, reqText+"\r\n"))
+	}
+
+	t.Run("Gzip", func(t *testing.T) {
+		header, body := get("GET", "/page.html", "gzip, deflate")
+		for _, want := range []string{"Content-Encoding: gzip\r\n", "Vary: Accept-Encoding\r\n", "-gzip\"\r\n"} {
+			if !strings.Contains(header, want) {
+				t.Fatalf("got header: %q, want %q", header, want)
+			}
+		}
+		if strings.Contains(header, "Accept-Ranges") {
+			t.Fatalf("got header: %q, want no Accept-Ranges", header)
+		}
+		zr, err := gzip.NewReader(strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(zr); err != nil || string(got) != page {
+			t.Fatalf("got decompressed body of %v bytes, %v", len(got), err)
+		}
+	})
+
+	t.Run("Deflate", func(t *testing.T) {
+		header, body := get("GET", "/page.html", "gzip;q=0.5, deflate")
+		if !strings.Contains(header, "Content-Encoding: deflate\r\n") {
+			t.Fatalf("got header: %q", header)
+		}
+		zr, err := zlib.NewReader(strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(zr); err != nil || string(got) != page {
+			t.Fatalf("got decompressed body of %v bytes, %v", len(got), err)
+		}
+	})
+
+	t.Run("Head", func(t *testing.T) {
+		_, body := get("GET", "/page.html", "gzip")
+		header, _ := get("HEAD", "/page.html", "gzip")
+		if !strings.Contains(header, "Content-Length: "+strconv.Itoa(len(body))+"\r\n") {
+			t.Fatalf("got header: %q, want the compressed length %v", header, len(body))
+		}
+	})
+
+	t.Run("Identity", func(t *testing.T) {
+		header, body := get("GET", "/page.html", "br")
+		if strings.Contains(header, "Content-Encoding") || !strings.Contains(header, "Vary: Accept-Encoding\r\n") || body != page {
+			t.Fatalf("got header: %q", header)
+		}
+	})
+
+	t.Run("BelowMinSize", func(t *testing.T) {
+		header, body := get("GET", "/small.css", "gzip")
+		if strings.Contains(header, "Content-Encoding") || body != files["small.css"] {
+			t.Fatalf("got header: %q, body: %q", header, body)
+		}
+	})
+
+	t.Run("InvalidLevel", func(t *testing.T) {
+		s := &Server{DocRoot: dir, Compress: true, CompressLevel: 42}
+		reqText := "GET /page.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\nAccept-Encoding: deflate\r\n\r\n"
+		header, body := splitResponse(t, roundTrip(t, s, reqText))
+		if strings.Contains(header, "Content-Encoding") || body != page {
+			t.Fatalf("got header: %q, body of %v bytes", header, len(body))
+		}
+	})
+
+	t.Run("NotCompressible", func(t *testing.T) {
+		header, _ := get("GET", "/photo.png", "gzip")
+		if strings.Contains(header, "Content-Encoding") || strings.Contains(header, "Vary") {
+			t.Fatalf("got header: %q", header)
+		}
+	})
+
+	t.Run("Precompressed", func(t *testing.T) {
+		header, body := get("GET", "/app.js", "gzip")
+		for _, want := range []string{"Content-Encoding: gzip\r\n", "Content-Type: text/javascript; charset=utf-8\r\n", "Accept-Ranges: bytes\r\n"} {
+			if !strings.Contains(header, want) {
+				t.Fatalf("got header: %q, want %q", header, want)
+			}
+		}
+		if body != files["app.js.gz"] {
+			t.Fatalf("got body: %q", body)
+		}
+		header, body = get("GET", "/app.js", "")
+		if strings.Contains(header, "Content-Encoding") || body != files["app.js"] {
+			t.Fatalf("got header: %q, body: %q", header, body)
+		}
+	})
+
+	t.Run("NotModified", func(t *testing.T) {
+		header, _ := get("GET", "/page.html", "gzip")
+		etag := header[strings.Index(header, "Etag: ")+6:]
+		etag = etag[:strings.Index(etag, "\r\n")]
+		reqText := "GET /page.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\nAccept-Encoding: gzip\r\nIf-None-Match: " + etag + "\r\n\r\n"
+		got := roundTrip(t, s, reqText)
+		if !strings.HasPrefix(got, "HTTP/1.1 304 Not Modified\r\n") || !strings.Contains(got, "Vary: Accept-Encoding\r\n") {
+			t.Fatalf("got response: %q", got)
+		}
+		// The identity representation has another tag
+		reqText = strings.Replace(reqText, "Accept-Encoding: gzip\r\n", "", 1)
+		if got := roundTrip(t, s, reqText); !strings.HasPrefix(got, "HTTP/1.1 200 OK\r\n") {
+			t.Fatalf("got response: %q", got)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package tritonhttp
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FileETag returns the entity tag of a file, derived from its
+// modification time and size. A weak tag is prefixed with "W/".
+func FileETag(fi os.FileInfo, weak bool) string {
+	etag := `"` + strconv.FormatInt(fi.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(fi.Size(), 16) + `"`
+	if weak {
+		etag = "W/" + etag
+	}
+	return etag
+}
+
+// checkPreconditions evaluates the conditional headers of req against
+// the file prepared in res, in the order given by RFC 9110 section 13.2.2.
+// It returns 304 or 412 when the request should not get the file,
+// or 0 when the request should be served normally.
+func checkPreconditions(req *Request, res *Response) int {
+	etag := res.Header.Get("Etag")
+	lastModified, _ := ParseTime(res.Header.Get("Last-Modified"))
+	isGetOrHead := req.Method == "GET" || req.Method == "HEAD"
+
+	// Step 1 and 2: If-Match, or else If-Unmodified-Since
+	if req.Header.Has("If-Match") {
+		if !matchETags(req.Header.Join("If-Match"), etag, strongMatch) {
+			return 412
+		}
+	} else if req.Header.Has("If-Unmodified-Since") {
+		if t, err := ParseTime(req.Header.Get("If-Unmodified-Since")); err == nil && lastModified.After(t) {
+			return 412
+		}
+	}
+
+	// Step 3 and 4: If-None-Match, or else If-Modified-Since
+	if req.Header.Has("If-None-Match") {
+		if matchETags(req.Header.Join("If-None-Match"), etag, weakMatch) {
+			if isGetOrHead {
+				return 304
+			}
+			return 412
+		}
+	} else if req.Header.Has("If-Modified-Since") && isGetOrHead {
+		if t, err := ParseTime(req.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(t) {
+			return 304
+		}
+	}
+	return 0
+}
+
+// matchETags reports whether the list of entity tags in a header such
+// as "If-Match" contains etag, using the comparison function match.
+// The list "*" matches any current representation.
+func matchETags(list, etag string, match func(a, b string) bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, tag := range splitETags(list) {
+		if match(tag, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitETags splits a comma-separated list of entity tags.
+// Commas inside the quotes of a tag do not separate tags.
+func splitETags(list string) []string {
+	var tags []string
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return tags
+		}
+		start := 0
+		if strings.HasPrefix(list, "W/") {
+			start = 2
+		}
+		if len(list) <= start || list[start] != '"' {
+			return tags // malformed, ignore the rest
+		}
+		end := strings.IndexByte(list[start+1:], '"')
+		if end == -1 {
+			return tags
+		}
+		end += start + 2
+		tags = append(tags, list[:end])
+		list = list[end:]
+	}
+}
+
+// strongMatch reports whether a and b are the same strong entity tag.
+func strongMatch(a, b string) bool {
+	return a == b && !strings.HasPrefix(a, "W/")
+}
+
+// weakMatch reports whether a and b are the same entity tag,
+// ignoring whether either one is weak.
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
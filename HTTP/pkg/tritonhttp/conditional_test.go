@@ -0,0 +1,95 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitETags(t *testing.T) {
+	var tests = []struct {
+		list string
+		want []string
+	}{
+		{`"abc"`, []string{`"abc"`}},
+		{`"a", W/"b",  "c,d"`, []string{`"a"`, `W/"b"`, `"c,d"`}},
+		{`"a", bogus`, []string{`"a"`}},
+		{``, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			got := splitETags(tt.list)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionalRequest(t *testing.T) {
+	f := FileServer("testdata")
+	res := f.Respond(&Request{Method: "GET", URL: "/index.html", Header: Header{}})
+	etag := res.Header.Get("Etag")
+	lastModified := res.Header.Get("Last-Modified")
+	if !strings.HasPrefix(etag, `"`) {
+		t.Fatalf("got ETag: %q, want a strong entity tag", etag)
+	}
+	const past = "Sat, 01 Jan 2000 00:00:00 GMT"
+	const future = "Fri, 01 Jan 2100 00:00:00 GMT"
+
+	var tests = []struct {
+		name       string
+		method     string
+		header     Header
+		statusWant int
+	}{
+		{"NoConditions", "GET", Header{}, 200},
+		{"IfNoneMatch", "GET", Header{"If-None-Match": {etag}}, 304},
+		{"IfNoneMatchWeak", "GET", Header{"If-None-Match": {`"x", W/` + etag}}, 304},
+		{"IfNoneMatchStar", "HEAD", Header{"If-None-Match": {"*"}}, 304},
+		{"IfNoneMatchOther", "GET", Header{"If-None-Match": {`"other"`}}, 200},
+		{"IfNoneMatchPost", "POST", Header{"If-None-Match": {etag}}, 405},
+		{"IfModifiedSince", "GET", Header{"If-Modified-Since": {lastModified}}, 304},
+		{"IfModifiedSinceFuture", "GET", Header{"If-Modified-Since": {future}}, 304},
+		{"IfModifiedSincePast", "GET", Header{"If-Modified-Since": {past}}, 200},
+		{"IfModifiedSinceInvalid", "GET", Header{"If-Modified-Since": {"yesterday"}}, 200},
+		{"IfNoneMatchOverridesIfModifiedSince", "GET", Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {future}}, 200},
+		{"IfMatch", "GET", Header{"If-Match": {etag}}, 200},
+		{"IfMatchOther", "GET", Header{"If-Match": {`"other"`}}, 412},
+		{"IfMatchWeak", "GET", Header{"If-Match": {"W/" + etag}}, 412},
+		{"IfUnmodifiedSince", "GET", Header{"If-Unmodified-Since": {future}}, 200},
+		{"IfUnmodifiedSincePast", "GET", Header{"If-Unmodified-Since": {past}}, 412},
+		{"IfMatchOverridesIfUnmodifiedSince", "GET", Header{"If-Match": {etag}, "If-Unmodified-Since": {past}}, 200},
+		{"IfMatchBeforeIfNoneMatch", "GET", Header{"If-Match": {`"other"`}, "If-None-Match": {etag}}, 412},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Method: tt.method, URL: "/index.html", Proto: "HTTP/1.1", Header: tt.header}
+			w := newResponseWriter(bufio.NewWriter(io.Discard), req)
+			f.ServeHTTP(w, req)
+			if w.status != tt.statusWant {
+				t.Fatalf("status code got: %v, want: %v", w.status, tt.statusWant)
+			}
+			if w.status == 304 {
+				if w.header.Get("Etag") != etag {
+					t.Fatalf("header %q value got: %q, want %q", "Etag", w.header.Get("Etag"), etag)
+				}
+				if _, ok := w.header["Content-Length"]; ok {
+					t.Fatalf("unexpected header %q in 304 response", "Content-Length")
+				}
+			}
+		})
+	}
+}
+
+func TestWeakETags(t *testing.T) {
+	f := &FileHandler{DocRoot: "testdata", WeakETags: true}
+	res := f.Respond(&Request{Method: "GET", URL: "/index.html", Header: Header{}})
+	if etag := res.Header.Get("Etag"); !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("got ETag: %q, want a weak entity tag", etag)
+	}
+}
@@ -0,0 +1,100 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ErrorPages customizes the body of the error responses (4xx and 5xx)
+// generated by the server and by FileHandler. Codes with neither a file
+// nor a template get a short plain text page.
+type ErrorPages struct {
+	// Files maps status codes to pages served from the document root,
+	// e.g. {404: "/errors/404.html"}. The file is resolved like a request
+	// path, and its "Content-Type" is derived from its name.
+	Files map[int]string
+
+	// Templates maps status codes to HTML templates, executed with an
+	// *ErrorPageData. Template, if set, is used for every other code.
+	Templates map[int]*template.Template
+	Template  *template.Template
+}
+
+// ErrorPageData describes the failed request to error page templates.
+// The request fields are empty when the request could not be parsed.
+type ErrorPageData struct {
+	StatusCode int
+	StatusText string
+	Time       time.Time
+
+	Method     string
+	URL        string
+	Proto      string
+	Host       string
+	RemoteAddr string
+}
+
+// page returns the body and the "Content-Type" of the error page for
+// status. Files are looked up below docRoot; failures go to log.
+func (p *ErrorPages) page(docRoot string, status int, req *Request, log *ErrorLogger) ([]byte, string) {
+	if p != nil {
+		if name, ok := p.Files[status]; ok && docRoot != "" {
+			filePath, _, err := ResolvePath(docRoot, name, true)
+			if err == nil {
+				var body []byte
+				if body, err = os.ReadFile(filePath); err == nil {
+					return body, ContentType(filePath, nil, "")
+				}
+			}
+			log.Warnf("error page %v for %v: %v", name, status, err)
+		}
+		tmpl := p.Templates[status]
+		if tmpl == nil {
+			tmpl = p.Template
+		}
+		if tmpl != nil {
+			data := &ErrorPageData{
+				StatusCode: status,
+				StatusText: StatusText(status),
+				Time:       time.Now(),
+			}
+			if req != nil {
+				data.Method = req.Method
+				data.URL = req.URL
+				data.Proto = req.Proto
+				data.Host = req.Host
+				data.RemoteAddr = req.RemoteAddr
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, data); err != nil {
+				log.Warnf("error page template for %v: %v", status, err)
+			} else {
+				return buf.Bytes(), "text/html; charset=utf-8"
+			}
+		}
+	}
+	return []byte(strconv.Itoa(status) + " " + StatusText(status) + "\n"), "text/plain; charset=utf-8"
+}
+
+// SetErrorPage sets the body of the error response res to the page pages
+// provides for its status code, along with its "Content-Type" and
+// "Content-Length". A nil pages gives the plain text page. Files are
+// looked up below docRoot.
+func (res *Response) SetErrorPage(pages *ErrorPages, docRoot string) {
+	res.setErrorPage(pages, docRoot, defaultErrorLog)
+}
+
+// setErrorPage is SetErrorPage logging to log.
+func (res *Response) setErrorPage(pages *ErrorPages, docRoot string, log *ErrorLogger) {
+	if res.Header == nil {
+		res.Header = make(Header)
+	}
+	body, contentType := pages.page(docRoot, res.StatusCode, res.Request, log)
+	res.FilePath = ""
+	res.Body = body
+	res.Header.Set("Content-Type", contentType)
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
@@ -0,0 +1,148 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStatusText(t *testing.T) {
+	var tests = []struct {
+		code int
+		want string
+	}{
+		{100, "Continue"},
+		{201, "Created"},
+		{308, "Permanent Redirect"},
+		{413, "Content Too Large"},
+		{418, ""},
+		{429, "Too Many Requests"},
+		{511, "Network Authentication Required"},
+		{599, ""},
+	}
+	for _, tt := range tests {
+		if got := StatusText(tt.code); got != tt.want {
+			t.Fatalf("StatusText(%v) got: %q, want: %q", tt.code, got, tt.want)
+		}
+	}
+
+	var buffer bytes.Buffer
+	res := &Response{StatusCode: 599}
+	if err := res.WriteStatusLine(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	if got := buffer.String(); got != "HTTP/1.1 599 \r\n" {
+		t.Fatalf("got: %q, want an empty reason phrase", got)
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "errors"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	notFound := "<h1>Nothing here</h1>\n"
+	if err := os.WriteFile(filepath.Join(root, "errors", "404.html"), []byte(notFound), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pages := &ErrorPages{
+		Files: map[int]string{
+			404: "/errors/404.html",
+			400: "/errors/missing.html",
+		},
+		Templates: map[int]*template.Template{
+			405: template.Must(template.New("405").Parse("{{.StatusCode}} {{.Method}} not allowed on {{.URL}}")),
+		},
+		Template: template.Must(template.New("all").Parse("<p>{{.StatusCode}} {{.StatusText}} for {{.URL}}</p>")),
+	}
+
+	var tests = []struct {
+		name        string
+		reqText     string
+		wantLine    string
+		contentType string
+		body        string
+	}{
+		{
+			"File",
+			"GET /nope.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 404 Not Found",
+			"text/html; charset=utf-8",
+			notFound,
+		},
+		{
+			"FileHead",
+			"HEAD /nope.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 404 Not Found",
+			"text/html; charset=utf-8",
+			"",
+		},
+		{
+			"StatusTemplate",
+			"DELETE /x HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 405 Method Not Allowed",
+			"text/html; charset=utf-8",
+			"405 DELETE not allowed on /x",
+		},
+		{
+			"MissingFileFallsBackToTemplate",
+			"GET /a%2f<b> HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 400 Bad Request",
+			"text/html; charset=utf-8",
+			"<p>400 Bad Request for /a%2f&lt;b&gt;</p>",
+		},
+		{
+			"ServerError",
+			"GET / HTTP/2.0\r\nHost: test\r\n\r\n",
+			"HTTP/1.1 505 HTTP Version Not Supported",
+			"text/html; charset=utf-8",
+			"<p>505 HTTP Version Not Supported for </p>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{DocRoot: root, ErrorPages: pages}
+			header, body := splitResponse(t, roundTrip(t, s, tt.reqText))
+			if !strings.HasPrefix(header, tt.wantLine+"\r\n") {
+				t.Fatalf("got response: %q, want status line %q", header, tt.wantLine)
+			}
+			if !strings.Contains(header, "\r\nContent-Type: "+tt.contentType+"\r\n") {
+				t.Fatalf("got header: %q, want Content-Type %q", header, tt.contentType)
+			}
+			if body != tt.body {
+				t.Fatalf("got body: %q, want: %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestDefaultErrorPage(t *testing.T) {
+	s := &Server{DocRoot: "testdata"}
+	got := roundTrip(t, s, "GET /nope.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	header, body := splitResponse(t, got)
+	want := "404 Not Found\n"
+	if body != want {
+		t.Fatalf("got body: %q, want: %q", body, want)
+	}
+	for _, h := range []string{"Content-Length: 14", "Content-Type: text/plain; charset=utf-8"} {
+		if !strings.Contains(header, "\r\n"+h+"\r\n") {
+			t.Fatalf("got header: %q, want %q", header, h)
+		}
+	}
+}
+
+func TestErrorPageLog(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Server{
+		DocRoot:    "testdata",
+		ErrorPages: &ErrorPages{Files: map[int]string{404: "/errors/missing.html"}},
+		ErrorLog:   NewErrorLogger(&buf, LevelWarn),
+	}
+	roundTrip(t, s, "GET /nope.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	if !strings.Contains(buf.String(), "error page /errors/missing.html for 404") {
+		t.Fatalf("got log: %q, want the missing error page", buf.String())
+	}
+}
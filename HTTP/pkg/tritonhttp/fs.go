@@ -0,0 +1,187 @@
+package tritonhttp
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// FileHandler is a Handler serving static files from DocRoot.
+// It only answers GET and HEAD requests; any other method gets
+// 405 Method Not Allowed.
+type FileHandler struct {
+	// DocRoot specifies the path to the directory to serve static files from.
+	DocRoot string
+
+	// WeakETags makes the handler send weak entity tags, e.g. W/"…",
+	// for content that may differ byte for byte between responses.
+	WeakETags bool
+
+	// MIMETypes overrides the "Content-Type" of files by extension,
+	// e.g. {".log": "text/plain"}. Keys are lower case and start with
+	// a dot. See ContentType for how other files are typed.
+	MIMETypes map[string]string
+
+	// Charset is declared for text types, e.g. "text/css; charset=utf-8".
+	// If empty, DefaultCharset is used; NoCharset leaves it out.
+	Charset string
+
+	// Compress enables content-coding negotiation with "Accept-Encoding".
+	// Files of compressible types, at least CompressMinSize bytes long,
+	// are compressed on the fly with gzip or deflate at CompressLevel.
+	// A precompressed sibling "file.gz" is sent in place of "file" to
+	// clients accepting gzip, whatever its type and size.
+	Compress bool
+
+	// CompressLevel is the gzip or deflate level, from 1 (fastest) to 9
+	// (smallest). If zero, gzip.DefaultCompression is used. With a level
+	// out of range, files are only sent precompressed or uncompressed.
+	CompressLevel int
+
+	// CompressMinSize is the smallest file compressed on the fly.
+	// If zero, DefaultCompressMinSize is used.
+	CompressMinSize int64
+
+	// ConfineSymlinks refuses, with 403 Forbidden, to serve files
+	// reached through symlinks that lead out of DocRoot.
+	ConfineSymlinks bool
+
+	// Autoindex selects the listing generated for a directory without
+	// an IndexFile. With AutoindexOff, the default, such requests get
+	// 404 Not Found.
+	Autoindex AutoindexFormat
+
+	// ErrorPages customizes the body of error responses, with files
+	// looked up below DocRoot. If nil, they get a plain text page.
+	ErrorPages *ErrorPages
+
+	// ErrorLog receives the failures met while serving files. If nil,
+	// they are logged to the default error log.
+	ErrorLog *ErrorLogger
+}
+
+// FileServer returns a handler serving static files from docRoot.
+// It is usually mounted with StripPrefix, e.g.
+//
+//	mux.Handle("/static/", StripPrefix("/static", FileServer(root)))
+func FileServer(docRoot string) *FileHandler {
+	return &FileHandler{DocRoot: docRoot}
+}
+
+// ServeHTTP serves the file req refers to. Conditional requests are
+// answered with 304 Not Modified or 412 Precondition Failed, and a
+// "Range" header with 206 Partial Content or 416 Range Not Satisfiable.
+// A directory is served through its IndexFile, or listed if Autoindex
+// is enabled. With Compress, the file may be sent gzip or deflate encoded.
+func (f *FileHandler) ServeHTTP(w ResponseWriter, req *Request) {
+	res := f.Respond(req)
+	if res.StatusCode == 404 && f.Autoindex != AutoindexOff {
+		if dir, ok := f.listableDir(req); ok {
+			serveAutoindex(w, req, dir, f.Autoindex, f.errorLog())
+			return
+		}
+	}
+	if res.StatusCode == 200 {
+		coding := ""
+		if f.Compress {
+			coding = f.encode(req, res)
+		}
+		switch checkPreconditions(req, res) {
+		case 304:
+			res.HandleNotModified()
+		case 412:
+			res.HandlePreconditionFailed(req)
+		default:
+			if coding != "" {
+				f.serveCompressed(w, res, coding)
+				return
+			}
+			if serveRange(w, req, res) {
+				return
+			}
+		}
+	}
+	if res.StatusCode >= 400 && f.ErrorPages != nil {
+		res.setErrorPage(f.ErrorPages, f.DocRoot, f.errorLog())
+	}
+	res.Serve(w)
+}
+
+// Respond prepares the response for req without writing it,
+// which is either 200 with the file to serve, 301 to add the missing
+// trailing slash of a directory, 400, 403, 404 or 405.
+// req itself is left unchanged.
+func (f *FileHandler) Respond(req *Request) (res *Response) {
+	res = &Response{}
+	res.Proto = "HTTP/1.1"
+	res.Request = req
+	if req.Method != "GET" && req.Method != "HEAD" {
+		res.HandleMethodNotAllowed(req)
+		return
+	}
+	filePath, err := f.resolve(req)
+	if err == nil {//200
+		if err := res.handleOK(req, filePath); err != nil {// 文件在解析路径之后被删除
+			f.errorLog().Warnf("stat %v: %v", filePath, err)
+			res.HandleNotFound(req)
+			return
+		}
+		res.Header.Set("Content-Type", ContentType(filePath, f.MIMETypes, f.Charset))
+		if f.WeakETags {
+			res.Header.Set("Etag", "W/"+res.Header.Get("Etag"))
+		}
+		return
+	}
+	switch pathErrorStatus(err) {
+	case 301://目录 重定向到以"/"结尾的url
+		res.HandleMovedPermanently(req, dirRedirect(req.URL))
+	case 400://返回400 关闭连接
+		res.HandleBadRequest()
+	case 403:
+		res.HandleForbidden(req)
+	default:
+		res.HandleNotFound(req)
+	}
+	return
+}
+
+// resolve returns the local path of the file to serve for req.
+// A directory resolves to its IndexFile.
+func (f *FileHandler) resolve(req *Request) (string, error) {
+	filePath, fileInfo, err := ResolvePath(f.DocRoot, req.URL, f.ConfineSymlinks)
+	if err != nil || !fileInfo.IsDir() {
+		return filePath, err
+	}
+	urlPath, _ := CleanPath(req.URL)
+	if !strings.HasSuffix(urlPath, "/") {// 目录缺少结尾的"/" 需要重定向 否则页面中的相对链接会出错
+		return "", &PathError{req.URL, errMissingSlash}
+	}
+	filePath, fileInfo, err = ResolvePath(f.DocRoot, urlPath+IndexFile, f.ConfineSymlinks)
+	if err != nil {
+		return "", &PathError{req.URL, errors.Unwrap(err)}
+	}
+	if fileInfo.IsDir() {
+		return "", &PathError{req.URL, ErrNotFound}
+	}
+	return filePath, nil
+}
+
+// dirRedirect returns the "Location" adding the trailing slash to the
+// directory target names. It is relative, so that it stays correct
+// below StripPrefix.
+func dirRedirect(target string) string {
+	urlPath, _ := CleanPath(target)
+	location := (&url.URL{Path: path.Base(urlPath) + "/"}).EscapedPath()
+	if i := strings.IndexByte(target, '?'); i != -1 {
+		location += target[i:]
+	}
+	return location
+}
+
+func (f *FileHandler) errorLog() *ErrorLogger {
+	if f.ErrorLog != nil {
+		return f.ErrorLog
+	}
+	return defaultErrorLog
+}
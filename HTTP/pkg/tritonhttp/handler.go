@@ -0,0 +1,231 @@
+package tritonhttp
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A ResponseWriter is used by a Handler to construct the response
+// to a request.
+type ResponseWriter interface {
+	// Header returns the header map that will be sent by WriteHeader.
+	// Header keys should be stored in the canonical format.
+	// Changing the map after WriteHeader has no effect, except for
+	// the fields declared in the "Trailer" header, which are sent
+	// after a chunked body.
+	Header() Header
+
+	// WriteHeader sends the status line and the headers with statusCode.
+	// Only the first call has an effect.
+	WriteHeader(statusCode int)
+
+	// Write writes p as part of the response body. If WriteHeader has
+	// not yet been called, Write calls WriteHeader(200) first.
+	Write(p []byte) (int, error)
+}
+
+// A Handler responds to a request.
+//
+// ServeHTTP should write the status line, headers and body to w and
+// then return. The request must not be used after ServeHTTP returns.
+type Handler interface {
+	ServeHTTP(w ResponseWriter, req *Request)
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(w ResponseWriter, req *Request)
+
+// ServeHTTP calls f(w, req).
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, req *Request) {
+	f(w, req)
+}
+
+// NotFoundHandler returns a handler that answers every request
+// with 404 Not Found.
+func NotFoundHandler() Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		res := &Response{}
+		res.HandleNotFound(req)
+		res.Serve(w)
+	})
+}
+
+// StripPrefix returns a handler that removes prefix from the request URL
+// before passing the request on to h. The prefix is matched against the
+// path cleaned by CleanPath, as whole segments. Requests whose path does
+// not start with prefix are answered with 404 Not Found.
+func StripPrefix(prefix string, h Handler) Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		p, err := CleanPath(req.URL)
+		if err != nil {
+			badRequestHandler().ServeHTTP(w, req)
+			return
+		}
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			NotFoundHandler().ServeHTTP(w, req)
+			return
+		}
+		r := *req
+		r.URL = (&url.URL{Path: "/" + strings.TrimPrefix(p[len(prefix):], "/")}).EscapedPath()
+		if i := strings.IndexByte(req.URL, '?'); i != -1 {
+			r.URL += req.URL[i:]
+		}
+		h.ServeHTTP(w, &r)
+	})
+}
+
+// badRequestHandler answers requests whose path CleanPath rejects.
+func badRequestHandler() Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		res := &Response{}
+		res.HandleBadRequest()
+		res.Serve(w)
+	})
+}
+
+// redirectHandler sends the client to location with 301 Moved Permanently.
+func redirectHandler(location string) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		res := &Response{}
+		res.HandleMovedPermanently(req, location)
+		res.Serve(w)
+	})
+}
+
+// ServeMux is a request router. It matches the URL of each incoming
+// request against a list of registered patterns and calls the handler
+// of the pattern that most closely matches.
+//
+// Patterns come in three forms:
+//   - "/api/status" matches that exact path only.
+//   - "/api/*/status" contains path.Match meta characters and
+//     matches any path the pattern matches.
+//   - "/static/" ends in a slash and matches every path below it.
+//
+// Exact patterns are preferred over wildcard patterns, which are
+// preferred over prefix patterns. Among prefix patterns the longest
+// wins, so "/" acts as the fallback handler.
+//
+// Patterns are matched against the path cleaned by CleanPath, so that
+// an encoded or dotted path cannot get around a pattern. Requests with
+// "." or ".." segments or repeated slashes are redirected to the clean
+// path, and those CleanPath rejects are answered with 400 Bad Request.
+type ServeMux struct {
+	mu       sync.RWMutex
+	exact    map[string]Handler
+	wildcard []muxEntry
+	prefix   []muxEntry // sorted from longest to shortest
+
+	// NotFound handles requests matching no pattern.
+	// If nil, NotFoundHandler() is used.
+	NotFound Handler
+}
+
+type muxEntry struct {
+	pattern string
+	h       Handler
+}
+
+// NewServeMux allocates and returns a new ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{exact: make(map[string]Handler)}
+}
+
+// Handle registers the handler for the given pattern.
+// It panics if the pattern is invalid or already registered.
+func (mux *ServeMux) Handle(pattern string, h Handler) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic("tritonhttp: invalid pattern " + pattern)
+	}
+	if h == nil {
+		panic("tritonhttp: nil handler for " + pattern)
+	}
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	if mux.exact == nil {
+		mux.exact = make(map[string]Handler)
+	}
+	switch {
+	case strings.ContainsAny(pattern, "*?["):
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic("tritonhttp: invalid pattern " + pattern)
+		}
+		for _, e := range mux.wildcard {
+			if e.pattern == pattern {
+				panic("tritonhttp: multiple registrations for " + pattern)
+			}
+		}
+		mux.wildcard = append(mux.wildcard, muxEntry{pattern, h})
+	case strings.HasSuffix(pattern, "/"):
+		for _, e := range mux.prefix {
+			if e.pattern == pattern {
+				panic("tritonhttp: multiple registrations for " + pattern)
+			}
+		}
+		mux.prefix = append(mux.prefix, muxEntry{pattern, h})
+		sort.SliceStable(mux.prefix, func(i, j int) bool {
+			return len(mux.prefix[i].pattern) > len(mux.prefix[j].pattern)
+		})
+	default:
+		if _, ok := mux.exact[pattern]; ok {
+			panic("tritonhttp: multiple registrations for " + pattern)
+		}
+		mux.exact[pattern] = h
+	}
+}
+
+// HandleFunc registers the handler function for the given pattern.
+func (mux *ServeMux) HandleFunc(pattern string, f func(w ResponseWriter, req *Request)) {
+	mux.Handle(pattern, HandlerFunc(f))
+}
+
+// Handler returns the handler to use for req, together with the
+// pattern it was registered with. The pattern is "" when no
+// registered pattern matches.
+func (mux *ServeMux) Handler(req *Request) (h Handler, pattern string) {
+	p, err := CleanPath(req.URL)
+	if err != nil {
+		return badRequestHandler(), ""
+	}
+	// 像net/http一样 重定向到规范的路径 而不是替客户端解析"."和".."
+	raw, query := req.URL, ""
+	if i := strings.IndexByte(raw, '?'); i != -1 {
+		raw, query = raw[:i], raw[i:]
+	}
+	if clean := path.Clean(raw); clean != raw && clean+"/" != raw {
+		if strings.HasSuffix(raw, "/") && clean != "/" {
+			clean += "/"
+		}
+		return redirectHandler(clean + query), ""
+	}
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	if h, ok := mux.exact[p]; ok {
+		return h, p
+	}
+	for _, e := range mux.wildcard {
+		if ok, _ := path.Match(e.pattern, p); ok {
+			return e.h, e.pattern
+		}
+	}
+	for _, e := range mux.prefix {
+		if strings.HasPrefix(p, e.pattern) {
+			return e.h, e.pattern
+		}
+	}
+	if mux.NotFound != nil {
+		return mux.NotFound, ""
+	}
+	return NotFoundHandler(), ""
+}
+
+// ServeHTTP dispatches req to the handler whose pattern most closely
+// matches the request URL.
+func (mux *ServeMux) ServeHTTP(w ResponseWriter, req *Request) {
+	h, _ := mux.Handler(req)
+	h.ServeHTTP(w, req)
+}
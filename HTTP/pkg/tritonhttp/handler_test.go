@@ -0,0 +1,171 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// roundTrip sends reqText over an in-memory connection served by s
+// and returns everything the server wrote back before closing.
+func roundTrip(t *testing.T, s *Server, reqText string) string {
+	t.Helper()
+	client, server := net.Pipe()
+	go s.HandleConnection(server)
+	go func() {
+		io.WriteString(client, reqText)
+	}()
+	got, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	return string(got)
+}
+
+func TestServeMuxHandler(t *testing.T) {
+	named := func(name string) Handler {
+		return HandlerFunc(func(w ResponseWriter, req *Request) {
+			io.WriteString(w, name)
+		})
+	}
+	mux := NewServeMux()
+	mux.Handle("/", named("fallback"))
+	mux.Handle("/static/", named("static"))
+	mux.Handle("/api/", named("api"))
+	mux.Handle("/api/version", named("version"))
+	mux.Handle("/api/*/status", named("status"))
+
+	var tests = []struct {
+		url         string
+		patternWant string
+	}{
+		{"/", "/"},
+		{"/about.html", "/"},
+		{"/static/kitten.jpg", "/static/"},
+		{"/static", "/"},
+		{"/api/users", "/api/"},
+		{"/api/version", "/api/version"},
+		{"/api/version?pretty=1", "/api/version"},
+		{"/api/db/status", "/api/*/status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			_, pattern := mux.Handler(&Request{Method: "GET", URL: tt.url})
+			if pattern != tt.patternWant {
+				t.Fatalf("pattern got: %q, want: %q", pattern, tt.patternWant)
+			}
+		})
+	}
+}
+
+func TestServeMuxCleanPath(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("/", func(w ResponseWriter, req *Request) { io.WriteString(w, "public") })
+	mux.HandleFunc("/admin/", func(w ResponseWriter, req *Request) { io.WriteString(w, "admin") })
+	mux.Handle("/static/", StripPrefix("/static", HandlerFunc(func(w ResponseWriter, req *Request) {
+		io.WriteString(w, req.URL)
+	})))
+	s := &Server{Handler: mux}
+
+	var tests = []struct {
+		name     string
+		url      string
+		wantLine string
+		want     string // the body, or the "Location" of a redirect
+	}{
+		{"Encoded", "/%61dmin/", "HTTP/1.1 200 OK", "admin"},
+		{"EncodedDots", "/x/%2e%2e/admin/", "HTTP/1.1 200 OK", "admin"},
+		{"Dots", "/x/../admin/?q=1", "HTTP/1.1 301 Moved Permanently", "/admin/?q=1"},
+		{"DoubleSlash", "//admin//", "HTTP/1.1 301 Moved Permanently", "/admin/"},
+		{"EncodedSlash", "/admin%2f", "HTTP/1.1 400 Bad Request", "400 Bad Request\n"},
+		{"StripPrefix", "/%73tatic/a%20b?q=1", "HTTP/1.1 200 OK", "/a%20b?q=1"},
+		{"StripPrefixDots", "/static/%2e%2e/admin/", "HTTP/1.1 200 OK", "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := roundTrip(t, s, "GET "+tt.url+" HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+			if !strings.HasPrefix(got, tt.wantLine+"\r\n") {
+				t.Fatalf("got response: %q, want status line %q", got, tt.wantLine)
+			}
+			if strings.Contains(tt.wantLine, "301") {
+				if !strings.Contains(got, "\r\nLocation: "+tt.want+"\r\n") {
+					t.Fatalf("got response: %q, want Location %q", got, tt.want)
+				}
+			} else if !strings.HasSuffix(got, "\r\n\r\n"+tt.want) {
+				t.Fatalf("got response: %q, want body %q", got, tt.want)
+			}
+		})
+	}
+
+	// 前缀按整段匹配
+	h := StripPrefix("/static", HandlerFunc(func(w ResponseWriter, req *Request) {}))
+	w := newResponseWriter(bufio.NewWriter(io.Discard), &Request{Method: "GET"})
+	h.ServeHTTP(w, &Request{Method: "GET", URL: "/staticx/y"})
+	if w.status != 404 {
+		t.Fatalf("status code got: %v, want: 404", w.status)
+	}
+}
+
+func TestServeMuxNotFound(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("/api/", func(w ResponseWriter, req *Request) {})
+	h, pattern := mux.Handler(&Request{Method: "GET", URL: "/index.html"})
+	if pattern != "" {
+		t.Fatalf("pattern got: %q, want none", pattern)
+	}
+	w := newResponseWriter(bufio.NewWriter(io.Discard), &Request{Method: "GET"})
+	h.ServeHTTP(w, &Request{Method: "GET", URL: "/index.html"})
+	if w.status != 404 {
+		t.Fatalf("status code got: %v, want: 404", w.status)
+	}
+}
+
+func TestServerHandler(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle("/static/", StripPrefix("/static", FileServer("testdata")))
+	mux.HandleFunc("/api/", func(w ResponseWriter, req *Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"url":"`+req.URL+`"}`)
+	})
+	s := &Server{Handler: mux}
+
+	var tests = []struct {
+		name     string
+		reqText  string
+		wantLine string
+		wantBody string
+	}{
+		{
+			"Static",
+			"GET /static/index.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 200 OK",
+			"Hello World\n",
+		},
+		{
+			"API",
+			"GET /api/ping HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 200 OK",
+			`{"url":"/api/ping"}`,
+		},
+		{
+			"NotFound",
+			"GET /nothing HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n",
+			"HTTP/1.1 404 Not Found",
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := roundTrip(t, s, tt.reqText)
+			if !strings.HasPrefix(got, tt.wantLine+"\r\n") {
+				t.Fatalf("got response: %q, want status line %q", got, tt.wantLine)
+			}
+			if tt.wantBody != "" && !strings.HasSuffix(got, "\r\n\r\n"+tt.wantBody) {
+				t.Fatalf("got response: %q, want body %q", got, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package tritonhttp
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// A Header holds the header fields of a request or a response.
+// Keys are canonical field names as returned by CanonicalHeaderKey.
+// A field sent on several lines keeps one value per line, in order.
+type Header map[string][]string
+
+// Add appends value to the values of key.
+func (h Header) Add(key, value string) {
+	key = CanonicalHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Set replaces the values of key with the single value.
+func (h Header) Set(key, value string) {
+	h[CanonicalHeaderKey(key)] = []string{value}
+}
+
+// Get returns the first value of key, or "" if there is none.
+// Use Join to read a list-based field that may span several lines.
+func (h Header) Get(key string) string {
+	if v := h[CanonicalHeaderKey(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Values returns all values of key. The returned slice is not a copy.
+func (h Header) Values(key string) []string {
+	return h[CanonicalHeaderKey(key)]
+}
+
+// Has reports whether key is present.
+func (h Header) Has(key string) bool {
+	_, ok := h[CanonicalHeaderKey(key)]
+	return ok
+}
+
+// Del deletes all values of key.
+func (h Header) Del(key string) {
+	delete(h, CanonicalHeaderKey(key))
+}
+
+// Join returns the values of key combined into one, separated by ", ".
+// RFC 9110 only allows this for list-based fields: it must not be used
+// for "Set-Cookie", whose values may themselves contain commas.
+func (h Header) Join(key string) string {
+	return strings.Join(h[CanonicalHeaderKey(key)], ", ")
+}
+
+// Clone returns a copy of h.
+func (h Header) Clone() Header {
+	if h == nil {
+		return nil
+	}
+	h2 := make(Header, len(h))
+	for k, v := range h {
+		h2[k] = append([]string(nil), v...)
+	}
+	return h2
+}
+
+// Write writes h to w in the wire format, one line per value.
+// Fields are sorted by key, so that the output is deterministic;
+// the values of a field keep their order.
+func (h Header) Write(w io.Writer) error {
+	var b strings.Builder
+	for _, k := range h.sortedKeys() {
+		for _, v := range h[k] {
+			// 防止值中的换行被用来伪造头部
+			v = strings.NewReplacer("\r", " ", "\n", " ").Replace(v)
+			b.WriteString(CanonicalHeaderKey(k) + ": " + v + CRLF)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// sortedKeys returns the keys of h in sorted order.
+func (h Header) sortedKeys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
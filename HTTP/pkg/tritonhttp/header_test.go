@@ -0,0 +1,85 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	h := make(Header)
+	h.Set("content-type", "text/plain")
+	h.Add("set-cookie", "a=1; Path=/")
+	h.Add("Set-Cookie", "b=2, c=3")
+	h.Add("VARY", "Accept-Encoding")
+	h.Add("vary", "Accept-Language")
+
+	if got := h.Get("Content-Type"); got != "text/plain" {
+		t.Fatalf("Get got: %q", got)
+	}
+	if _, ok := h["Content-Type"]; !ok {
+		t.Fatalf("key not canonical: %v", h)
+	}
+	if got := h.Get("Set-Cookie"); got != "a=1; Path=/" {
+		t.Fatalf("Get got: %q, want the first value", got)
+	}
+	if got, want := h.Values("set-cookie"), []string{"a=1; Path=/", "b=2, c=3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Values got: %q, want: %q", got, want)
+	}
+	if got := h.Join("Vary"); got != "Accept-Encoding, Accept-Language" {
+		t.Fatalf("Join got: %q", got)
+	}
+	h.Set("Vary", "*")
+	if got := h.Values("Vary"); len(got) != 1 || got[0] != "*" {
+		t.Fatalf("Values after Set got: %q", got)
+	}
+	h.Del("vary")
+	if h.Has("Vary") || h.Get("Vary") != "" || h.Join("Vary") != "" {
+		t.Fatalf("Vary still present after Del: %v", h)
+	}
+
+	c := h.Clone()
+	c.Add("Set-Cookie", "d=4")
+	if len(h.Values("Set-Cookie")) != 2 {
+		t.Fatalf("Clone shares values with the original: %v", h)
+	}
+}
+
+func TestHeaderWrite(t *testing.T) {
+	h := Header{
+		"Vary":         {"Accept-Encoding"},
+		"Set-Cookie":   {"b=2", "a=1"},
+		"Content-Type": {"text/plain"},
+		"X-Evil":       {"a\r\nInjected: yes"},
+	}
+	var buf bytes.Buffer
+	if err := h.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "Content-Type: text/plain\r\n" +
+		"Set-Cookie: b=2\r\n" +
+		"Set-Cookie: a=1\r\n" +
+		"Vary: Accept-Encoding\r\n" +
+		"X-Evil: a  Injected: yes\r\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestReadRequestMultiValuedHeaders(t *testing.T) {
+	reqText := "GET / HTTP/1.1\r\nHost: test\r\n" +
+		"Cookie: a=1\r\ncookie: b=2\r\n" +
+		"Accept-Encoding: gzip\r\nAccept-Encoding: deflate;q=0.5\r\n\r\n"
+	req, _, err := ReadRequest(bufio.NewReader(strings.NewReader(reqText)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.Header.Values("Cookie"), []string{"a=1", "b=2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got Cookie: %q, want: %q", got, want)
+	}
+	if got := req.Header.Join("Accept-Encoding"); got != "gzip, deflate;q=0.5" {
+		t.Fatalf("got Accept-Encoding: %q", got)
+	}
+}
@@ -0,0 +1,447 @@
+package tritonhttp
+
+import (
+	"errors"
+	"sync"
+)
+
+// errHPACK reports a header block which cannot be decoded. It is a
+// connection error of type COMPRESSION_ERROR, RFC 9113 section 4.3.
+var errHPACK = errors.New("tritonhttp: invalid HPACK header block")
+
+// A headerField is a field of an HTTP/2 header block, with a lower case
+// name. Pseudo-header fields have names starting with ':'.
+type headerField struct {
+	name, value string
+}
+
+// size is the size of f in the dynamic table and in the header list,
+// RFC 7541 section 4.1.
+func (f headerField) size() int {
+	return len(f.name) + len(f.value) + 32
+}
+
+// hpackDecoder decodes the header blocks of a connection, RFC 7541.
+// It keeps the dynamic table across blocks, so every block must be
+// decoded in the order the blocks were sent.
+type hpackDecoder struct {
+	dynamic []headerField // oldest first
+	size    int           // sum of the sizes of the entries of dynamic
+	maxSize int           // set by dynamic table size updates
+
+	// maxTableSize is the largest size an update may set, the
+	// SETTINGS_HEADER_TABLE_SIZE announced to the encoder.
+	maxTableSize int
+}
+
+func newHPACKDecoder(maxTableSize int) *hpackDecoder {
+	return &hpackDecoder{maxSize: maxTableSize, maxTableSize: maxTableSize}
+}
+
+// decode decodes the header block p, passing each field to emit.
+func (d *hpackDecoder) decode(p []byte, emit func(headerField)) error {
+	fieldSeen := false
+	for len(p) > 0 {
+		var err error
+		b := p[0]
+		if b&0xe0 != 0x20 {
+			fieldSeen = true
+		}
+		switch {
+		case b&0x80 != 0: // Indexed Header Field
+			var i uint64
+			if i, p, err = readVarInt(7, p); err != nil {
+				return err
+			}
+			f, ok := d.at(i)
+			if !ok {
+				return errHPACK
+			}
+			emit(f)
+		case b&0xe0 == 0x20: // Dynamic Table Size Update
+			// 只能出现在头部块的开头
+			if fieldSeen {
+				return errHPACK
+			}
+			var n uint64
+			if n, p, err = readVarInt(5, p); err != nil {
+				return err
+			}
+			if n > uint64(d.maxTableSize) {
+				return errHPACK
+			}
+			d.maxSize = int(n)
+			d.evict()
+			continue
+		default: // Literal Header Field, with or without indexing
+			n := uint8(4)
+			if b&0xc0 == 0x40 {
+				n = 6
+			}
+			var i uint64
+			if i, p, err = readVarInt(n, p); err != nil {
+				return err
+			}
+			var f headerField
+			if i == 0 {
+				if f.name, p, err = readString(p); err != nil {
+					return err
+				}
+			} else {
+				nf, ok := d.at(i)
+				if !ok {
+					return errHPACK
+				}
+				f.name = nf.name
+			}
+			if f.value, p, err = readString(p); err != nil {
+				return err
+			}
+			if n == 6 {
+				d.add(f)
+			}
+			emit(f)
+		}
+	}
+	return nil
+}
+
+// at returns the field at index i of the static and dynamic tables,
+// RFC 7541 section 2.3.3.
+func (d *hpackDecoder) at(i uint64) (headerField, bool) {
+	if i == 0 {
+		return headerField{}, false
+	}
+	if i <= uint64(len(hpackStaticTable)) {
+		return hpackStaticTable[i-1], true
+	}
+	i -= uint64(len(hpackStaticTable))
+	if i > uint64(len(d.dynamic)) {
+		return headerField{}, false
+	}
+	// 索引越小的条目越新
+	return d.dynamic[len(d.dynamic)-int(i)], true
+}
+
+// add inserts f into the dynamic table, evicting old entries to make
+// room. An entry larger than the table empties it.
+func (d *hpackDecoder) add(f headerField) {
+	d.dynamic = append(d.dynamic, f)
+	d.size += f.size()
+	d.evict()
+}
+
+// evict removes the oldest entries until the table fits its size.
+func (d *hpackDecoder) evict() {
+	n := 0
+	for d.size > d.maxSize && n < len(d.dynamic) {
+		d.size -= d.dynamic[n].size()
+		n++
+	}
+	if n > 0 {
+		d.dynamic = append(d.dynamic[:0], d.dynamic[n:]...)
+	}
+}
+
+// readVarInt reads an integer with an n-bit prefix from p,
+// RFC 7541 section 5.1.
+func readVarInt(n uint8, p []byte) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, p, errHPACK
+	}
+	max := uint64(1)<<n - 1
+	i := uint64(p[0]) & max
+	p = p[1:]
+	if i < max {
+		return i, p, nil
+	}
+	for shift := uint(0); len(p) > 0; shift += 7 {
+		// 限制在32位以内 更大的值没有意义
+		if shift > 28 {
+			return 0, p, errHPACK
+		}
+		b := p[0]
+		p = p[1:]
+		i += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return i, p, nil
+		}
+	}
+	return 0, p, errHPACK
+}
+
+// readString reads a string literal from p, RFC 7541 section 5.2.
+func readString(p []byte) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", p, errHPACK
+	}
+	huffman := p[0]&0x80 != 0
+	n, p, err := readVarInt(7, p)
+	if err != nil {
+		return "", p, err
+	}
+	if n > uint64(len(p)) {
+		return "", p, errHPACK
+	}
+	s, p := p[:n], p[n:]
+	if !huffman {
+		return string(s), p, nil
+	}
+	v, err := huffmanDecode(s)
+	return v, p, err
+}
+
+// appendHeaderField appends the representation of f to dst. The dynamic
+// table is never used, so the encoder keeps no state: fields found in
+// the static table are indexed, the others sent as literals without
+// indexing.
+func appendHeaderField(dst []byte, f headerField) []byte {
+	nameIndex := 0
+	for i, sf := range hpackStaticTable {
+		if sf.name != f.name {
+			continue
+		}
+		if sf.value == f.value {
+			return appendVarInt(dst, 7, 0x80, uint64(i+1))
+		}
+		if nameIndex == 0 {
+			nameIndex = i + 1
+		}
+	}
+	dst = appendVarInt(dst, 4, 0, uint64(nameIndex))
+	if nameIndex == 0 {
+		dst = appendString(dst, f.name)
+	}
+	return appendString(dst, f.value)
+}
+
+// appendVarInt appends i as an integer with an n-bit prefix, the other
+// bits of the first byte taken from flags.
+func appendVarInt(dst []byte, n uint8, flags byte, i uint64) []byte {
+	max := uint64(1)<<n - 1
+	if i < max {
+		return append(dst, flags|byte(i))
+	}
+	dst = append(dst, flags|byte(max))
+	for i -= max; i >= 0x80; i >>= 7 {
+		dst = append(dst, byte(i)|0x80)
+	}
+	return append(dst, byte(i))
+}
+
+// appendString appends s as a string literal, Huffman coded when that
+// makes it shorter.
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanEncodedLen(s); n < len(s) {
+		dst = appendVarInt(dst, 7, 0x80, uint64(n))
+		return huffmanEncode(dst, s)
+	}
+	dst = appendVarInt(dst, 7, 0, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// huffmanEncodedLen returns the length of s once Huffman coded.
+func huffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLen[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+// huffmanEncode appends s Huffman coded to dst, padded with the most
+// significant bits of EOS, RFC 7541 section 5.2.
+func huffmanEncode(dst []byte, s string) []byte {
+	var acc uint64 // 尚未输出的位
+	var n uint     // acc中的位数
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanCodeLen[s[i]] | uint64(huffmanCodes[s[i]])
+		n += uint(huffmanCodeLen[s[i]])
+		for n >= 8 {
+			n -= 8
+			dst = append(dst, byte(acc>>n))
+		}
+	}
+	if n > 0 {
+		dst = append(dst, byte(acc<<(8-n))|byte(0xff>>n))
+	}
+	return dst
+}
+
+// huffmanNode is a node of the tree decoding Huffman codes.
+// Leaves have no children.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	sym      byte
+}
+
+var (
+	huffmanTreeOnce sync.Once
+	huffmanTree     *huffmanNode
+)
+
+// huffmanDecode decodes the Huffman coded string p. EOS is not part of
+// the tree, so it is rejected like any unknown code.
+func huffmanDecode(p []byte) (string, error) {
+	huffmanTreeOnce.Do(buildHuffmanTree)
+	s := make([]byte, 0, len(p)*8/5)
+	node := huffmanTree
+	pad, ones := 0, true // 当前未完成的码的位数 以及是否全为1
+	for _, b := range p {
+		for i := 7; i >= 0; i-- {
+			bit := b >> uint(i) & 1
+			node = node.children[bit]
+			if node == nil {
+				return "", errHPACK
+			}
+			pad++
+			ones = ones && bit == 1
+			if node.children[0] == nil && node.children[1] == nil {
+				s = append(s, node.sym)
+				node = huffmanTree
+				pad, ones = 0, true
+			}
+		}
+	}
+	// 填充最多7位 并且必须是EOS的前缀 (全为1)
+	if pad > 7 || !ones {
+		return "", errHPACK
+	}
+	return string(s), nil
+}
+
+func buildHuffmanTree() {
+	huffmanTree = &huffmanNode{}
+	for sym, code := range huffmanCodes {
+		node := huffmanTree
+		for i := int(huffmanCodeLen[sym]) - 1; i >= 0; i-- {
+			bit := code >> uint(i) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &huffmanNode{}
+			}
+			node = node.children[bit]
+		}
+		node.sym = byte(sym)
+	}
+}
+
+// hpackStaticTable is the static table of RFC 7541 appendix A;
+// entry i has index i+1.
+var hpackStaticTable = [...]headerField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// huffmanCodes and huffmanCodeLen are the Huffman code of each byte,
+// RFC 7541 appendix B.
+var huffmanCodes = [256]uint32{
+	0x1ff8, 0x7fffd8, 0xfffffe2, 0xfffffe3, 0xfffffe4, 0xfffffe5, 0xfffffe6, 0xfffffe7,
+	0xfffffe8, 0xffffea, 0x3ffffffc, 0xfffffe9, 0xfffffea, 0x3ffffffd, 0xfffffeb, 0xfffffec,
+	0xfffffed, 0xfffffee, 0xfffffef, 0xffffff0, 0xffffff1, 0xffffff2, 0x3ffffffe, 0xffffff3,
+	0xffffff4, 0xffffff5, 0xffffff6, 0xffffff7, 0xffffff8, 0xffffff9, 0xffffffa, 0xffffffb,
+	0x14, 0x3f8, 0x3f9, 0xffa, 0x1ff9, 0x15, 0xf8, 0x7fa,
+	0x3fa, 0x3fb, 0xf9, 0x7fb, 0xfa, 0x16, 0x17, 0x18,
+	0x0, 0x1, 0x2, 0x19, 0x1a, 0x1b, 0x1c, 0x1d,
+	0x1e, 0x1f, 0x5c, 0xfb, 0x7ffc, 0x20, 0xffb, 0x3fc,
+	0x1ffa, 0x21, 0x5d, 0x5e, 0x5f, 0x60, 0x61, 0x62,
+	0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a,
+	0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72,
+	0xfc, 0x73, 0xfd, 0x1ffb, 0x7fff0, 0x1ffc, 0x3ffc, 0x22,
+	0x7ffd, 0x3, 0x23, 0x4, 0x24, 0x5, 0x25, 0x26,
+	0x27, 0x6, 0x74, 0x75, 0x28, 0x29, 0x2a, 0x7,
+	0x2b, 0x76, 0x2c, 0x8, 0x9, 0x2d, 0x77, 0x78,
+	0x79, 0x7a, 0x7b, 0x7ffe, 0x7fc, 0x3ffd, 0x1ffd, 0xffffffc,
+	0xfffe6, 0x3fffd2, 0xfffe7, 0xfffe8, 0x3fffd3, 0x3fffd4, 0x3fffd5, 0x7fffd9,
+	0x3fffd6, 0x7fffda, 0x7fffdb, 0x7fffdc, 0x7fffdd, 0x7fffde, 0xffffeb, 0x7fffdf,
+	0xffffec, 0xffffed, 0x3fffd7, 0x7fffe0, 0xffffee, 0x7fffe1, 0x7fffe2, 0x7fffe3,
+	0x7fffe4, 0x1fffdc, 0x3fffd8, 0x7fffe5, 0x3fffd9, 0x7fffe6, 0x7fffe7, 0xffffef,
+	0x3fffda, 0x1fffdd, 0xfffe9, 0x3fffdb, 0x3fffdc, 0x7fffe8, 0x7fffe9, 0x1fffde,
+	0x7fffea, 0x3fffdd, 0x3fffde, 0xfffff0, 0x1fffdf, 0x3fffdf, 0x7fffeb, 0x7fffec,
+	0x1fffe0, 0x1fffe1, 0x3fffe0, 0x1fffe2, 0x7fffed, 0x3fffe1, 0x7fffee, 0x7fffef,
+	0xfffea, 0x3fffe2, 0x3fffe3, 0x3fffe4, 0x7ffff0, 0x3fffe5, 0x3fffe6, 0x7ffff1,
+	0x3ffffe0, 0x3ffffe1, 0xfffeb, 0x7fff1, 0x3fffe7, 0x7ffff2, 0x3fffe8, 0x1ffffec,
+	0x3ffffe2, 0x3ffffe3, 0x3ffffe4, 0x7ffffde, 0x7ffffdf, 0x3ffffe5, 0xfffff1, 0x1ffffed,
+	0x7fff2, 0x1fffe3, 0x3ffffe6, 0x7ffffe0, 0x7ffffe1, 0x3ffffe7, 0x7ffffe2, 0xfffff2,
+	0x1fffe4, 0x1fffe5, 0x3ffffe8, 0x3ffffe9, 0xffffffd, 0x7ffffe3, 0x7ffffe4, 0x7ffffe5,
+	0xfffec, 0xfffff3, 0xfffed, 0x1fffe6, 0x3fffe9, 0x1fffe7, 0x1fffe8, 0x7ffff3,
+	0x3fffea, 0x3fffeb, 0x1ffffee, 0x1ffffef, 0xfffff4, 0xfffff5, 0x3ffffea, 0x7ffff4,
+	0x3ffffeb, 0x7ffffe6, 0x3ffffec, 0x3ffffed, 0x7ffffe7, 0x7ffffe8, 0x7ffffe9, 0x7ffffea,
+	0x7ffffeb, 0xffffffe, 0x7ffffec, 0x7ffffed, 0x7ffffee, 0x7ffffef, 0x7fffff0, 0x3ffffee,
+}
+var huffmanCodeLen = [256]uint8{
+	13, 23, 28, 28, 28, 28, 28, 28, 28, 24, 30, 28, 28, 30, 28, 28,
+	28, 28, 28, 28, 28, 28, 30, 28, 28, 28, 28, 28, 28, 28, 28, 28,
+	6, 10, 10, 12, 13, 6, 8, 11, 10, 10, 8, 11, 8, 6, 6, 6,
+	5, 5, 5, 6, 6, 6, 6, 6, 6, 6, 7, 8, 15, 6, 12, 10,
+	13, 6, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7,
+	7, 7, 7, 7, 7, 7, 7, 7, 8, 7, 8, 13, 19, 13, 14, 6,
+	15, 5, 6, 5, 6, 5, 6, 6, 6, 5, 7, 7, 6, 6, 6, 5,
+	6, 7, 6, 5, 5, 6, 7, 7, 7, 7, 7, 15, 11, 14, 13, 28,
+	20, 22, 20, 20, 22, 22, 22, 23, 22, 23, 23, 23, 23, 23, 24, 23,
+	24, 24, 22, 23, 24, 23, 23, 23, 23, 21, 22, 23, 22, 23, 23, 24,
+	22, 21, 20, 22, 22, 23, 23, 21, 23, 22, 22, 24, 21, 22, 23, 23,
+	21, 21, 22, 21, 23, 22, 23, 23, 20, 22, 22, 22, 23, 22, 22, 23,
+	26, 26, 20, 19, 22, 23, 22, 25, 26, 26, 26, 27, 27, 26, 24, 25,
+	19, 21, 26, 27, 27, 26, 27, 24, 21, 21, 26, 26, 28, 27, 27, 27,
+	20, 24, 20, 21, 22, 21, 21, 23, 22, 22, 25, 25, 24, 24, 26, 23,
+	26, 27, 26, 26, 27, 27, 27, 27, 27, 28, 27, 27, 27, 27, 27, 26,
+}
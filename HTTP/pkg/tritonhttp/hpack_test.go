@@ -0,0 +1,124 @@
+package tritonhttp
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The examples of RFC 7541 appendix C.3 and C.4, three requests in a row
+// sharing the dynamic table, without and with Huffman coding.
+func TestHPACKDecode(t *testing.T) {
+	want := [][]headerField{
+		{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}},
+		{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"},
+			{"cache-control", "no-cache"}},
+		{{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"},
+			{"custom-key", "custom-value"}},
+	}
+	var tests = []struct {
+		name   string
+		blocks []string
+	}{
+		{
+			"Plain",
+			[]string{
+				"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+				"8286 84be 5808 6e6f 2d63 6163 6865",
+				"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65",
+			},
+		},
+		{
+			"Huffman",
+			[]string{
+				"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+				"8286 84be 5886 a8eb 1064 9cbf",
+				"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newHPACKDecoder(hpackTableSize)
+			for i, block := range tt.blocks {
+				var got []headerField
+				if err := d.decode(mustHex(t, block), func(f headerField) { got = append(got, f) }); err != nil {
+					t.Fatalf("block %v: %v", i, err)
+				}
+				if !reflect.DeepEqual(got, want[i]) {
+					t.Fatalf("block %v: got %v, want %v", i, got, want[i])
+				}
+			}
+			if d.size != 164 || len(d.dynamic) != 3 {
+				t.Fatalf("got dynamic table of %v bytes, %v entries, want 164 and 3", d.size, len(d.dynamic))
+			}
+		})
+	}
+}
+
+func TestHPACKEncode(t *testing.T) {
+	if got := huffmanEncode(nil, "www.example.com"); hex.EncodeToString(got) != "f1e3c2e5f23a6ba0ab90f4ff" {
+		t.Fatalf("got Huffman code %x", got)
+	}
+
+	fields := []headerField{
+		{":status", "200"},                     // 完全匹配静态表
+		{":status", "418"},                     // 只匹配名字
+		{"content-type", "text/html"},          // 只匹配名字 Huffman
+		{"x-custom", strings.Repeat("z", 300)}, // 新名字 长度超过前缀
+		{"x-binary", "\x00\x01\xff tab\tvalue"},
+		{"set-cookie", ""},
+	}
+	var block []byte
+	for _, f := range fields {
+		block = appendHeaderField(block, f)
+	}
+	var got []headerField
+	if err := newHPACKDecoder(hpackTableSize).decode(block, func(f headerField) { got = append(got, f) }); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, fields) {
+		t.Fatalf("got %q, want %q", got, fields)
+	}
+	if block[0] != 0x88 {
+		t.Fatalf("got %#x for :status 200, want index 8", block[0])
+	}
+}
+
+func TestHPACKDecodeErrors(t *testing.T) {
+	var tests = []struct {
+		name  string
+		block string
+	}{
+		{"IndexZero", "80"},
+		{"IndexPastTables", "be"}, // 62 动态表为空
+		{"TruncatedInteger", "ff"},
+		{"IntegerOverflow", "ff ff ff ff ff ff 01"},
+		{"TruncatedString", "40 0a 6375 7374"},
+		{"SizeUpdateAfterField", "82 3f e1 1f"},
+		{"SizeUpdateTooLarge", "3f e2 1f"}, // 4097
+		{"HuffmanEOS", "40 84 ff ff ff ff 00"},
+		{"HuffmanBadPadding", "41 81 00"}, // 填充不全为1
+		{"HuffmanLongPadding", "41 82 1f ff"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newHPACKDecoder(hpackTableSize).decode(mustHex(t, tt.block), func(headerField) {})
+			if err != errHPACK {
+				t.Fatalf("got %v, want %v", err, errHPACK)
+			}
+		})
+	}
+}
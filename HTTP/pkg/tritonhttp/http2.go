@@ -0,0 +1,1354 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxConcurrentStreams is used when Server.MaxConcurrentStreams is zero.
+const DefaultMaxConcurrentStreams = 100
+
+// http2Preface is the first thing an HTTP/2 client sends, RFC 9113
+// section 3.4. It looks like an HTTP/1 request that no server answers.
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Frame types, RFC 9113 section 6.
+const (
+	frameData         = 0x0
+	frameHeaders      = 0x1
+	framePriority     = 0x2
+	frameRSTStream    = 0x3
+	frameSettings     = 0x4
+	framePushPromise  = 0x5
+	framePing         = 0x6
+	frameGoAway       = 0x7
+	frameWindowUpdate = 0x8
+	frameContinuation = 0x9
+)
+
+// Frame flags. The same bit means different things for different types.
+const (
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+// Settings, RFC 9113 section 6.5.2.
+const (
+	settingHeaderTableSize      = 0x1
+	settingEnablePush           = 0x2
+	settingMaxConcurrentStreams = 0x3
+	settingInitialWindowSize    = 0x4
+	settingMaxFrameSize         = 0x5
+	settingMaxHeaderListSize    = 0x6
+)
+
+// An http2ErrCode is the reason of a RST_STREAM or GOAWAY frame,
+// RFC 9113 section 7.
+type http2ErrCode uint32
+
+const (
+	errCodeNo              http2ErrCode = 0x0
+	errCodeProtocol        http2ErrCode = 0x1
+	errCodeInternal        http2ErrCode = 0x2
+	errCodeFlowControl     http2ErrCode = 0x3
+	errCodeStreamClosed    http2ErrCode = 0x5
+	errCodeFrameSize       http2ErrCode = 0x6
+	errCodeRefusedStream   http2ErrCode = 0x7
+	errCodeCompression     http2ErrCode = 0x9
+	errCodeEnhanceYourCalm http2ErrCode = 0xb
+)
+
+const (
+	// http2DefaultWindow is the initial size of every flow-control
+	// window, and the largest frame either side may send, until changed
+	// by SETTINGS.
+	http2DefaultWindow    = 65535
+	http2DefaultFrameSize = 16384
+	http2MaxWindow        = 1<<31 - 1
+	http2MaxFrameSize     = 1<<24 - 1
+
+	// http2StreamWindow and http2ConnWindow are the receive windows the
+	// server offers for each stream and for the whole connection.
+	http2StreamWindow = 256 << 10
+	http2ConnWindow   = 1 << 20
+
+	// hpackTableSize is the size of the dynamic table of the decoder.
+	hpackTableSize = 4096
+)
+
+// errStreamClosed is returned by the body of a request and by the
+// ResponseWriter once the stream has been reset or the connection lost.
+var errStreamClosed = errors.New("tritonhttp: HTTP/2 stream closed")
+
+// An http2Error is a violation of RFC 9113. A connection error, with a
+// zero streamID, is answered with GOAWAY and ends the connection; a
+// stream error is answered with RST_STREAM.
+type http2Error struct {
+	code     http2ErrCode
+	streamID uint32
+	reason   string
+}
+
+func (e *http2Error) Error() string {
+	if e.streamID != 0 {
+		return fmt.Sprintf("stream %v: error %v: %v", e.streamID, e.code, e.reason)
+	}
+	return fmt.Sprintf("connection error %v: %v", e.code, e.reason)
+}
+
+func connError(code http2ErrCode, format string, args ...interface{}) error {
+	return &http2Error{code: code, reason: fmt.Sprintf(format, args...)}
+}
+
+func streamError(id uint32, code http2ErrCode, format string, args ...interface{}) error {
+	return &http2Error{code: code, streamID: id, reason: fmt.Sprintf(format, args...)}
+}
+
+// An http2Frame is a frame of an HTTP/2 connection, RFC 9113 section 4.1.
+type http2Frame struct {
+	typ      byte
+	flags    byte
+	streamID uint32
+	payload  []byte
+}
+
+// readHTTP2Frame reads the next frame from r, whose payload may not
+// exceed maxSize bytes.
+func readHTTP2Frame(r io.Reader, maxSize uint32) (*http2Frame, error) {
+	var hdr [9]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	length := uint32(hdr[0])<<16 | uint32(hdr[1])<<8 | uint32(hdr[2])
+	f := &http2Frame{
+		typ:      hdr[3],
+		flags:    hdr[4],
+		streamID: binary.BigEndian.Uint32(hdr[5:]) & 0x7fffffff, // 忽略保留位
+	}
+	if length > maxSize {
+		return nil, connError(errCodeFrameSize, "frame of %v bytes", length)
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeHTTP2Frame writes a frame to w.
+func writeHTTP2Frame(w io.Writer, typ, flags byte, streamID uint32, payload []byte) error {
+	var hdr [9]byte
+	hdr[0], hdr[1], hdr[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	hdr[3], hdr[4] = typ, flags
+	binary.BigEndian.PutUint32(hdr[5:], streamID)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// unpad returns the payload of a DATA or HEADERS frame without its padding.
+func unpad(f *http2Frame) ([]byte, error) {
+	p := f.payload
+	if f.flags&flagPadded == 0 {
+		return p, nil
+	}
+	if len(p) == 0 || int(p[0]) >= len(p) {
+		return nil, connError(errCodeProtocol, "padding exceeds the payload")
+	}
+	return p[1 : len(p)-int(p[0])], nil
+}
+
+// http2Conn is a connection serving HTTP/2 requests. One goroutine reads
+// the frames and keeps the state of the streams; each stream runs its
+// handler in a goroutine of its own.
+type http2Conn struct {
+	srv        *Server
+	conn       net.Conn
+	br         *bufio.Reader
+	remoteAddr string
+	dec        *hpackDecoder // used by the reading goroutine only
+
+	wmu sync.Mutex // guards bw; never acquired while holding mu
+	bw  *bufio.Writer
+
+	mu            sync.Mutex
+	cond          *sync.Cond // signaled when windows grow, data arrives or streams end
+	streams       map[uint32]*http2Stream
+	lastStreamID  uint32 // highest stream opened by the client
+	sendWindow    int64  // what the server may send on the connection
+	recvWindow    int64  // what the client may send on the connection
+	recvUnacked   int64  // data consumed but not yet given back to the client
+	initialWindow int64  // SETTINGS_INITIAL_WINDOW_SIZE of the client
+	maxFrameSize  int    // SETTINGS_MAX_FRAME_SIZE of the client
+	goingAway     bool   // GOAWAY sent or received, no new streams
+	closed        bool
+	handlers      sync.WaitGroup
+}
+
+// http2Stream is a request and its response. Its fields are guarded
+// by the mutex of the connection.
+type http2Stream struct {
+	c  *http2Conn
+	id uint32
+
+	sendWindow  int64
+	recvWindow  int64
+	recvUnacked int64
+	body        bytes.Buffer  // data received, not yet read by the handler
+	received    int64         // body bytes received
+	declared    int64         // Content-Length of the request, or -1
+	endStream   bool          // the client has sent all of the request
+	reset       bool          // RST_STREAM was sent or received
+	gone        chan struct{} // closed once the stream is reset or closed
+	req         *Request
+}
+
+// serveHTTP2 runs an HTTP/2 connection on conn whose preface has not
+// been read yet, RFC 9113 section 3.4. br holds what was read from conn
+// so far. upgrade is the request which asked for h2c with settings, to
+// be answered on stream 1, or nil with prior knowledge.
+func (s *Server) serveHTTP2(conn net.Conn, br *bufio.Reader, bw *bufio.Writer, upgrade *Request, settings []byte) {
+	c := &http2Conn{
+		srv:           s,
+		conn:          conn,
+		br:            br,
+		bw:            bw,
+		remoteAddr:    conn.RemoteAddr().String(),
+		dec:           newHPACKDecoder(hpackTableSize),
+		streams:       make(map[uint32]*http2Stream),
+		sendWindow:    http2DefaultWindow,
+		recvWindow:    http2ConnWindow,
+		initialWindow: http2DefaultWindow,
+		maxFrameSize:  http2DefaultFrameSize,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	defer c.handlers.Wait()
+	defer c.close()
+
+	// 服务器的preface是一个SETTINGS帧 h2c升级时紧跟在101之后
+	conn.SetReadDeadline(time.Now().Add(s.readHeaderTimeout()))
+	var p []byte
+	for _, v := range [][2]uint32{
+		{settingMaxConcurrentStreams, s.maxConcurrentStreams()},
+		{settingInitialWindowSize, http2StreamWindow},
+		{settingMaxHeaderListSize, uint32(s.maxHeaderBytes())},
+	} {
+		var b [6]byte
+		binary.BigEndian.PutUint16(b[:], uint16(v[0]))
+		binary.BigEndian.PutUint32(b[2:], v[1])
+		p = append(p, b[:]...)
+	}
+	c.writeFrame(frameSettings, 0, 0, p)
+	c.writeWindowUpdate(0, http2ConnWindow-http2DefaultWindow)
+	if err := c.flush(); err != nil {
+		return
+	}
+
+	if upgrade != nil {
+		// HTTP2-Settings头等同于客户端的第一个SETTINGS帧
+		if err := c.applySettings(settings); err != nil {
+			c.goAway(err)
+			return
+		}
+		c.mu.Lock()
+		c.lastStreamID = 1
+		st := c.newStream(1, upgrade, true)
+		c.mu.Unlock()
+		c.startStream(st, nil)
+	}
+
+	preface := make([]byte, len(http2Preface))
+	if _, err := io.ReadFull(br, preface); err != nil || string(preface) != http2Preface {
+		s.errorLog().Infof("%v: invalid HTTP/2 preface", c.remoteAddr)
+		return
+	}
+	f, err := readHTTP2Frame(br, http2DefaultFrameSize)
+	if err == nil && (f.typ != frameSettings || f.flags&flagAck != 0) {
+		err = connError(errCodeProtocol, "preface not followed by SETTINGS")
+	}
+	if err == nil {
+		err = c.processFrame(f)
+	}
+	c.mu.Lock()
+	c.updateIdle()
+	c.mu.Unlock()
+
+	for err == nil {
+		if f, err = readHTTP2Frame(br, http2DefaultFrameSize); err == nil {
+			err = c.processFrame(f)
+		}
+		var he *http2Error
+		if errors.As(err, &he) && he.streamID != 0 {
+			c.resetStream(he.streamID, he.code)
+			err = nil
+		}
+	}
+
+	var he *http2Error
+	if errors.As(err, &he) {
+		s.errorLog().Infof("%v: HTTP/2 %v", c.remoteAddr, err)
+		c.goAway(err)
+		return
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		// 空闲超时或者连接正在关闭 通知客户端不要再发送新的请求
+		s.errorLog().Debugf("%v: HTTP/2 connection done", c.remoteAddr)
+		c.goAway(nil)
+		return
+	}
+	s.errorLog().Debugf("%v: HTTP/2 connection closed: %v", c.remoteAddr, err)
+}
+
+// processFrame handles a frame read from the client.
+func (c *http2Conn) processFrame(f *http2Frame) error {
+	switch f.typ {
+	case frameData:
+		return c.processData(f)
+	case frameHeaders:
+		return c.processHeaders(f)
+	case framePriority:
+		if f.streamID == 0 {
+			return connError(errCodeProtocol, "PRIORITY on stream 0")
+		}
+		if len(f.payload) != 5 {
+			return streamError(f.streamID, errCodeFrameSize, "PRIORITY of %v bytes", len(f.payload))
+		}
+		// 不支持优先级 忽略
+		return nil
+	case frameRSTStream:
+		return c.processRSTStream(f)
+	case frameSettings:
+		if f.streamID != 0 {
+			return connError(errCodeProtocol, "SETTINGS on stream %v", f.streamID)
+		}
+		if f.flags&flagAck != 0 {
+			if len(f.payload) != 0 {
+				return connError(errCodeFrameSize, "SETTINGS ack with a payload")
+			}
+			return nil
+		}
+		if err := c.applySettings(f.payload); err != nil {
+			return err
+		}
+		c.writeFrame(frameSettings, flagAck, 0, nil)
+		return c.flush()
+	case framePushPromise:
+		return connError(errCodeProtocol, "PUSH_PROMISE from a client")
+	case framePing:
+		if f.streamID != 0 {
+			return connError(errCodeProtocol, "PING on stream %v", f.streamID)
+		}
+		if len(f.payload) != 8 {
+			return connError(errCodeFrameSize, "PING of %v bytes", len(f.payload))
+		}
+		if f.flags&flagAck != 0 {
+			return nil
+		}
+		c.writeFrame(framePing, flagAck, 0, f.payload)
+		return c.flush()
+	case frameGoAway:
+		if f.streamID != 0 {
+			return connError(errCodeProtocol, "GOAWAY on stream %v", f.streamID)
+		}
+		// 客户端不会再打开新的流 处理完现有的流后关闭连接
+		c.mu.Lock()
+		c.goingAway = true
+		c.updateIdle()
+		c.mu.Unlock()
+		return nil
+	case frameWindowUpdate:
+		return c.processWindowUpdate(f)
+	case frameContinuation:
+		return connError(errCodeProtocol, "CONTINUATION without HEADERS")
+	}
+	// 未知类型的帧必须忽略
+	return nil
+}
+
+// processData handles a DATA frame, adding its payload to the body of
+// the request.
+func (c *http2Conn) processData(f *http2Frame) error {
+	id := f.streamID
+	if id == 0 {
+		return connError(errCodeProtocol, "DATA on stream 0")
+	}
+	data, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	n := int64(len(f.payload)) // 填充也计入流量控制
+
+	c.mu.Lock()
+	if n > c.recvWindow {
+		c.mu.Unlock()
+		return connError(errCodeFlowControl, "DATA exceeds the connection window")
+	}
+	c.recvWindow -= n
+	st := c.streams[id]
+	if st == nil || st.endStream {
+		// 流已经关闭 连接的窗口仍然要归还
+		connInc, _ := c.consumed(nil, n)
+		c.mu.Unlock()
+		c.sendWindowUpdates(id, connInc, 0)
+		if id > c.lastStreamID {
+			return connError(errCodeProtocol, "DATA on idle stream %v", id)
+		}
+		return streamError(id, errCodeStreamClosed, "DATA on closed stream")
+	}
+	if n > st.recvWindow {
+		c.mu.Unlock()
+		return streamError(id, errCodeFlowControl, "DATA exceeds the stream window")
+	}
+	st.recvWindow -= n
+	connInc, streamInc := c.consumed(st, n-int64(len(data)))
+	st.body.Write(data)
+	st.received += int64(len(data))
+	if st.declared != -1 && st.received > st.declared {
+		err = streamError(id, errCodeProtocol, "body longer than its Content-Length")
+	} else if f.flags&flagEndStream != 0 {
+		err = c.endStream(st)
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.sendWindowUpdates(id, connInc, streamInc)
+	return err
+}
+
+// endStream records that the client has sent the whole request of st.
+func (c *http2Conn) endStream(st *http2Stream) error {
+	if st.declared != -1 && st.received != st.declared {
+		return streamError(st.id, errCodeProtocol, "body shorter than its Content-Length")
+	}
+	st.endStream = true
+	c.cond.Broadcast()
+	return nil
+}
+
+// processHeaders handles a HEADERS frame and the CONTINUATION frames
+// completing it, which open a stream or carry the trailers of its request.
+func (c *http2Conn) processHeaders(f *http2Frame) error {
+	id := f.streamID
+	if id == 0 {
+		return connError(errCodeProtocol, "HEADERS on stream 0")
+	}
+	p, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	if f.flags&flagPriority != 0 {
+		if len(p) < 5 {
+			return connError(errCodeFrameSize, "HEADERS too short for its priority")
+		}
+		if binary.BigEndian.Uint32(p)&0x7fffffff == id {
+			return streamError(id, errCodeProtocol, "stream depends on itself")
+		}
+		p = p[5:]
+	}
+	block := append([]byte(nil), p...)
+	for flags := f.flags; flags&flagEndHeaders == 0; {
+		cf, err := readHTTP2Frame(c.br, http2DefaultFrameSize)
+		if err != nil {
+			return err
+		}
+		if cf.typ != frameContinuation || cf.streamID != id {
+			return connError(errCodeProtocol, "HEADERS not followed by CONTINUATION")
+		}
+		if int64(len(block)+len(cf.payload)) > c.srv.maxHeaderBytes() {
+			return connError(errCodeEnhanceYourCalm, "header block exceeds %v bytes", c.srv.maxHeaderBytes())
+		}
+		block = append(block, cf.payload...)
+		flags = cf.flags
+	}
+
+	// 即使要拒绝这个流 也必须解码头部块 以保持动态表同步
+	var fields []headerField
+	size := 0
+	err = c.dec.decode(block, func(hf headerField) {
+		size += hf.size()
+		if int64(size) <= c.srv.maxHeaderBytes() {
+			fields = append(fields, hf)
+		}
+	})
+	if err != nil {
+		return connError(errCodeCompression, "%v", err)
+	}
+	endStream := f.flags&flagEndStream != 0
+
+	c.mu.Lock()
+	if st := c.streams[id]; st != nil {
+		defer c.mu.Unlock()
+		// 流已打开 这是请求的trailer
+		if st.endStream {
+			return streamError(id, errCodeStreamClosed, "HEADERS on half-closed stream")
+		}
+		if !endStream {
+			return streamError(id, errCodeProtocol, "trailers without END_STREAM")
+		}
+		trailer := make(Header)
+		for _, hf := range fields {
+			if strings.HasPrefix(hf.name, ":") || !validFieldName(hf.name) || !validFieldValue(hf.value) {
+				return streamError(id, errCodeProtocol, "invalid trailer field %q", hf.name)
+			}
+			trailer.Add(CanonicalHeaderKey(hf.name), hf.value)
+		}
+		if st.req != nil { // 头部过大的请求已经被拒绝 丢弃trailer
+			st.req.Trailer = trailer
+		}
+		return c.endStream(st)
+	}
+	if id <= c.lastStreamID {
+		c.mu.Unlock()
+		return connError(errCodeStreamClosed, "HEADERS on closed stream %v", id)
+	}
+	if id%2 == 0 {
+		c.mu.Unlock()
+		return connError(errCodeProtocol, "stream %v opened by the client", id)
+	}
+	c.lastStreamID = id
+	if c.goingAway {
+		// GOAWAY之后的新流被忽略
+		c.mu.Unlock()
+		return nil
+	}
+	if uint32(len(c.streams)) >= c.srv.maxConcurrentStreams() {
+		c.mu.Unlock()
+		return streamError(id, errCodeRefusedStream, "%v streams open", len(c.streams))
+	}
+	c.mu.Unlock()
+
+	var res *Response
+	req, err := c.newRequest(id, fields, endStream)
+	if int64(size) > c.srv.maxHeaderBytes() {
+		c.srv.errorLog().Infof("%v: request header exceeds %v bytes", c.remoteAddr, c.srv.maxHeaderBytes())
+		res = &Response{}
+		res.HandleHeaderTooLarge()
+		req = nil
+	} else if err != nil {
+		c.srv.errorLog().Infof("%v: bad request: %v", c.remoteAddr, err)
+		return err
+	} else if !knownMethods[req.Method] {
+		res = &Response{}
+		res.HandleMethodNotAllowed(req)
+	}
+
+	c.mu.Lock()
+	st := c.newStream(id, req, endStream)
+	if req != nil && !endStream {
+		req.Body = &http2Body{st: st}
+	}
+	c.mu.Unlock()
+	if c.srv.shuttingDown() {
+		// 服务器正在关闭 处理这个请求 但不再接受新的流
+		c.goAway(nil)
+	}
+	c.startStream(st, res)
+	return nil
+}
+
+// newRequest builds the request of stream id from the fields of its
+// header block, RFC 9113 section 8.3.
+func (c *http2Conn) newRequest(id uint32, fields []headerField, endStream bool) (*Request, error) {
+	req := &Request{
+		Proto:         "HTTP/2.0",
+		Header:        make(Header),
+		RemoteAddr:    c.remoteAddr,
+		ContentLength: -1,
+	}
+	var scheme string
+	var pseudoDone bool
+	for _, hf := range fields {
+		if strings.HasPrefix(hf.name, ":") {
+			// 伪头部必须在普通头部之前 且每个只能出现一次
+			if pseudoDone {
+				return nil, streamError(id, errCodeProtocol, "pseudo-header %v after regular fields", hf.name)
+			}
+			var dst *string
+			switch hf.name {
+			case ":method":
+				dst = &req.Method
+			case ":scheme":
+				dst = &scheme
+			case ":path":
+				dst = &req.URL
+			case ":authority":
+				dst = &req.Host
+			default:
+				return nil, streamError(id, errCodeProtocol, "unknown pseudo-header %v", hf.name)
+			}
+			if *dst != "" || hf.value == "" {
+				return nil, streamError(id, errCodeProtocol, "invalid %v", hf.name)
+			}
+			*dst = hf.value
+			continue
+		}
+		pseudoDone = true
+		if !validFieldName(hf.name) || !validFieldValue(hf.value) {
+			return nil, streamError(id, errCodeProtocol, "invalid header field %q", hf.name)
+		}
+		switch hf.name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			// HTTP/2 没有逐跳的连接头
+			return nil, streamError(id, errCodeProtocol, "connection-specific field %v", hf.name)
+		case "te":
+			if hf.value != "trailers" {
+				return nil, streamError(id, errCodeProtocol, "TE: %v", hf.value)
+			}
+		}
+		k := CanonicalHeaderKey(hf.name)
+		if k == "Host" {
+			if req.Host == "" {
+				req.Host = hf.value
+			}
+			continue
+		}
+		if k == "Cookie" && req.Header.Has(k) {
+			// 拆开的cookie要重新合并 RFC 9113 8.2.3
+			req.Header.Set(k, req.Header.Get(k)+"; "+hf.value)
+			continue
+		}
+		req.Header.Add(k, hf.value)
+	}
+
+	if req.Method == "" || !isToken(req.Method) || scheme == "" || req.URL == "" {
+		return nil, streamError(id, errCodeProtocol, "missing or invalid pseudo-header")
+	}
+	if _, err := req.parseTarget(); err != nil || !strings.HasPrefix(req.URL, "/") && req.URL != "*" {
+		return nil, streamError(id, errCodeProtocol, "invalid :path %q", req.URL)
+	}
+	if req.Host != "" && !ValidHost(req.Host) {
+		return nil, streamError(id, errCodeProtocol, "invalid :authority %q", req.Host)
+	}
+	if cl := req.Header.Get("Content-Length"); cl != "" {
+		n, err := parseContentLength(cl)
+		if err != nil {
+			return nil, streamError(id, errCodeProtocol, "invalid Content-Length %q", cl)
+		}
+		req.ContentLength = n
+	}
+	if endStream {
+		if req.ContentLength > 0 {
+			return nil, streamError(id, errCodeProtocol, "no body for its Content-Length")
+		}
+		req.ContentLength = 0
+	}
+	return req, nil
+}
+
+// newStream registers the stream id for req. c.mu must be held.
+func (c *http2Conn) newStream(id uint32, req *Request, endStream bool) *http2Stream {
+	st := &http2Stream{
+		c:          c,
+		id:         id,
+		sendWindow: c.initialWindow,
+		recvWindow: http2StreamWindow,
+		declared:   -1,
+		endStream:  endStream,
+		gone:       make(chan struct{}),
+		req:        req,
+	}
+	if req != nil && !endStream && req.ContentLength >= 0 {
+		st.declared = req.ContentLength
+	}
+	c.streams[id] = st
+	c.updateIdle()
+	return st
+}
+
+// startStream runs the handler of st in a goroutine of its own. When res
+// is not nil, it is sent instead, such as an error prepared by the server.
+func (c *http2Conn) startStream(st *http2Stream, res *Response) {
+	c.handlers.Add(1)
+	go func() {
+		defer c.handlers.Done()
+		c.serveStream(st, res)
+	}()
+}
+
+// serveStream answers the request of st, like Server.serve does for
+// HTTP/1.
+func (c *http2Conn) serveStream(st *http2Stream, res *Response) {
+	s := c.srv
+	req := st.req
+	start := time.Now()
+	w := &http2ResponseWriter{st: st, req: req, header: make(Header), contentLength: -1}
+	defer func() {
+		s.logAccess(c.remoteAddr, req, w.status, w.written, start)
+	}()
+
+	var body *maxBytesReader
+	if res == nil && req.Body != nil {
+		if req.ContentLength > s.maxBodyBytes() { // Content-Length已经超过上限 直接413
+			res = &Response{}
+			res.HandlePayloadTooLarge(req)
+		} else {
+			body = &maxBytesReader{r: req.Body, n: s.maxBodyBytes()}
+			req.Body = body
+		}
+	}
+	if res != nil {
+		s.errorPage(res)
+		res.Serve(w)
+	} else {
+		s.handlerFor(req).ServeHTTP(w, req)
+	}
+	if body != nil && body.tooLarge && !w.headerSent {
+		w.reset()
+		res := &Response{}
+		res.HandlePayloadTooLarge(req)
+		s.errorPage(res)
+		res.Serve(w)
+	}
+	if err := w.finish(); err != nil {
+		s.errorLog().Debugf("%v: stream %v: writing response: %v", c.remoteAddr, st.id, err)
+		c.closeStream(st, errCodeInternal)
+		return
+	}
+	// 请求还没有发送完 响应已经完整 告诉客户端不必再发送
+	c.closeStream(st, errCodeNo)
+}
+
+// closeStream forgets st once its response is complete or has failed.
+// RST_STREAM is sent with code unless both sides have ended the stream.
+func (c *http2Conn) closeStream(st *http2Stream, code http2ErrCode) {
+	c.mu.Lock()
+	open := c.streams[st.id] == st
+	rst := open && (code != errCodeNo || !st.endStream)
+	c.removeStream(st)
+	c.mu.Unlock()
+	if rst {
+		c.writeFrame(frameRSTStream, 0, st.id, rstPayload(code))
+		c.flush()
+	}
+}
+
+// resetStream ends the stream id with RST_STREAM, after a stream error.
+func (c *http2Conn) resetStream(id uint32, code http2ErrCode) {
+	c.mu.Lock()
+	if st := c.streams[id]; st != nil {
+		c.removeStream(st)
+	}
+	c.mu.Unlock()
+	c.writeFrame(frameRSTStream, 0, id, rstPayload(code))
+	c.flush()
+}
+
+// removeStream marks st as reset and forgets it. c.mu must be held.
+func (c *http2Conn) removeStream(st *http2Stream) {
+	if !st.reset {
+		st.reset = true
+		close(st.gone)
+	}
+	if c.streams[st.id] == st {
+		delete(c.streams, st.id)
+		c.updateIdle()
+	}
+	c.cond.Broadcast()
+}
+
+// updateIdle tracks whether c has open streams: an idle connection
+// times out, and can be closed by Shutdown. c.mu must be held.
+func (c *http2Conn) updateIdle() {
+	if c.closed {
+		return
+	}
+	if len(c.streams) > 0 {
+		c.srv.setConnState(c.conn, stateActive)
+		c.conn.SetReadDeadline(time.Time{})
+		return
+	}
+	c.srv.setConnState(c.conn, stateIdle)
+	if c.goingAway || c.srv.shuttingDown() {
+		// 让读取帧的goroutine立即醒来并结束连接
+		c.conn.SetReadDeadline(time.Now())
+		return
+	}
+	c.conn.SetReadDeadline(time.Now().Add(c.srv.idleTimeout()))
+}
+
+func rstPayload(code http2ErrCode) []byte {
+	var p [4]byte
+	binary.BigEndian.PutUint32(p[:], uint32(code))
+	return p[:]
+}
+
+// processRSTStream handles the client cancelling a stream.
+func (c *http2Conn) processRSTStream(f *http2Frame) error {
+	if f.streamID == 0 {
+		return connError(errCodeProtocol, "RST_STREAM on stream 0")
+	}
+	if len(f.payload) != 4 {
+		return connError(errCodeFrameSize, "RST_STREAM of %v bytes", len(f.payload))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.streamID > c.lastStreamID {
+		return connError(errCodeProtocol, "RST_STREAM on idle stream %v", f.streamID)
+	}
+	if st := c.streams[f.streamID]; st != nil {
+		c.removeStream(st)
+	}
+	return nil
+}
+
+// processWindowUpdate handles the client allowing more data to be sent.
+func (c *http2Conn) processWindowUpdate(f *http2Frame) error {
+	if len(f.payload) != 4 {
+		return connError(errCodeFrameSize, "WINDOW_UPDATE of %v bytes", len(f.payload))
+	}
+	inc := int64(binary.BigEndian.Uint32(f.payload) & 0x7fffffff)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.streamID == 0 {
+		if inc == 0 {
+			return connError(errCodeProtocol, "WINDOW_UPDATE of 0")
+		}
+		if c.sendWindow+inc > http2MaxWindow {
+			return connError(errCodeFlowControl, "connection window overflow")
+		}
+		c.sendWindow += inc
+		c.cond.Broadcast()
+		return nil
+	}
+	if f.streamID > c.lastStreamID {
+		return connError(errCodeProtocol, "WINDOW_UPDATE on idle stream %v", f.streamID)
+	}
+	st := c.streams[f.streamID]
+	if st == nil {
+		return nil // 已关闭的流可能还会收到
+	}
+	if inc == 0 {
+		return streamError(f.streamID, errCodeProtocol, "WINDOW_UPDATE of 0")
+	}
+	if st.sendWindow+inc > http2MaxWindow {
+		return streamError(f.streamID, errCodeFlowControl, "stream window overflow")
+	}
+	st.sendWindow += inc
+	c.cond.Broadcast()
+	return nil
+}
+
+// applySettings applies the settings sent by the client.
+func (c *http2Conn) applySettings(p []byte) error {
+	if len(p)%6 != 0 {
+		return connError(errCodeFrameSize, "SETTINGS of %v bytes", len(p))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ; len(p) > 0; p = p[6:] {
+		id, v := binary.BigEndian.Uint16(p), binary.BigEndian.Uint32(p[2:])
+		switch id {
+		case settingEnablePush:
+			if v > 1 {
+				return connError(errCodeProtocol, "SETTINGS_ENABLE_PUSH %v", v)
+			}
+		case settingInitialWindowSize:
+			if v > http2MaxWindow {
+				return connError(errCodeFlowControl, "SETTINGS_INITIAL_WINDOW_SIZE %v", v)
+			}
+			// 所有流的窗口按差值调整 可能变成负数
+			delta := int64(v) - c.initialWindow
+			for _, st := range c.streams {
+				if st.sendWindow+delta > http2MaxWindow {
+					return connError(errCodeFlowControl, "stream window overflow")
+				}
+				st.sendWindow += delta
+			}
+			c.initialWindow = int64(v)
+			c.cond.Broadcast()
+		case settingMaxFrameSize:
+			if v < http2DefaultFrameSize || v > http2MaxFrameSize {
+				return connError(errCodeProtocol, "SETTINGS_MAX_FRAME_SIZE %v", v)
+			}
+			c.maxFrameSize = int(v)
+		}
+		// 编码器不使用动态表 SETTINGS_HEADER_TABLE_SIZE无需处理
+		// 其他设置以及未知的设置都忽略
+	}
+	return nil
+}
+
+// consumed gives back n bytes of the receive windows, of st if not nil
+// and of the connection. It returns the increments to send in
+// WINDOW_UPDATE frames, which wait until enough has accumulated.
+// c.mu must be held.
+func (c *http2Conn) consumed(st *http2Stream, n int64) (connInc, streamInc int64) {
+	if n == 0 {
+		return 0, 0
+	}
+	c.recvUnacked += n
+	if c.recvUnacked >= http2ConnWindow/4 {
+		connInc, c.recvUnacked = c.recvUnacked, 0
+		c.recvWindow += connInc
+	}
+	// 客户端已经发完的流不需要再更新窗口
+	if st != nil && !st.endStream && !st.reset {
+		st.recvUnacked += n
+		if st.recvUnacked >= http2StreamWindow/4 {
+			streamInc, st.recvUnacked = st.recvUnacked, 0
+			st.recvWindow += streamInc
+		}
+	}
+	return connInc, streamInc
+}
+
+// sendWindowUpdates sends the increments returned by consumed for the
+// connection and the stream id.
+func (c *http2Conn) sendWindowUpdates(id uint32, connInc, streamInc int64) {
+	if connInc > 0 {
+		c.writeWindowUpdate(0, connInc)
+	}
+	if streamInc > 0 {
+		c.writeWindowUpdate(id, streamInc)
+	}
+	if connInc > 0 || streamInc > 0 {
+		c.flush()
+	}
+}
+
+func (c *http2Conn) writeWindowUpdate(id uint32, n int64) {
+	var p [4]byte
+	binary.BigEndian.PutUint32(p[:], uint32(n))
+	c.writeFrame(frameWindowUpdate, 0, id, p[:])
+}
+
+// goAway sends GOAWAY, with the code of err if it is an *http2Error, and
+// stops accepting streams.
+func (c *http2Conn) goAway(err error) {
+	code, debug := errCodeNo, ""
+	var he *http2Error
+	if errors.As(err, &he) {
+		code, debug = he.code, he.reason
+	}
+	c.mu.Lock()
+	if c.goingAway && err == nil {
+		c.mu.Unlock()
+		return
+	}
+	c.goingAway = true
+	last := c.lastStreamID
+	c.updateIdle()
+	c.mu.Unlock()
+	p := make([]byte, 8, 8+len(debug))
+	binary.BigEndian.PutUint32(p, last)
+	binary.BigEndian.PutUint32(p[4:], uint32(code))
+	c.writeFrame(frameGoAway, 0, 0, append(p, debug...))
+	c.flush()
+}
+
+// close ends every stream once the connection is done, waking the
+// handlers waiting for data or flow control.
+func (c *http2Conn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, st := range c.streams {
+		c.removeStream(st)
+	}
+	c.cond.Broadcast()
+	// 所有流都已结束 不再等待阻塞在写操作上的handler
+	c.conn.SetWriteDeadline(time.Now())
+}
+
+// writeFrame writes a frame to the buffer of the connection. Failing
+// to write closes the connection, which ends the reading goroutine.
+func (c *http2Conn) writeFrame(typ, flags byte, streamID uint32, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.writeFrameLocked(typ, flags, streamID, payload)
+}
+
+func (c *http2Conn) writeFrameLocked(typ, flags byte, streamID uint32, payload []byte) error {
+	if c.srv.WriteTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.srv.WriteTimeout))
+	}
+	err := writeHTTP2Frame(c.bw, typ, flags, streamID, payload)
+	if err != nil {
+		c.conn.Close()
+	}
+	return err
+}
+
+// flush sends the buffered frames to the client.
+func (c *http2Conn) flush() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.srv.WriteTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.srv.WriteTimeout))
+	}
+	err := c.bw.Flush()
+	if err != nil {
+		c.conn.Close()
+	}
+	return err
+}
+
+// writeHeaders sends the header block of a response or of its trailers
+// on st, split in a HEADERS frame and as many CONTINUATION frames as
+// needed, which no other frame may come between.
+func (st *http2Stream) writeHeaders(fields []headerField, endStream bool) error {
+	c := st.c
+	var block []byte
+	for _, hf := range fields {
+		block = appendHeaderField(block, hf)
+	}
+	c.mu.Lock()
+	if st.reset || c.closed {
+		c.mu.Unlock()
+		return errStreamClosed
+	}
+	max := c.maxFrameSize
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	typ, flags := byte(frameHeaders), byte(0)
+	if endStream {
+		flags = flagEndStream
+	}
+	for {
+		chunk := block
+		if len(chunk) > max {
+			chunk = chunk[:max]
+		}
+		block = block[len(chunk):]
+		if len(block) == 0 {
+			flags |= flagEndHeaders
+		}
+		if err := c.writeFrameLocked(typ, flags, st.id, chunk); err != nil {
+			return err
+		}
+		if len(block) == 0 {
+			return nil
+		}
+		typ, flags = frameContinuation, 0
+	}
+}
+
+// writeData sends p as DATA frames on st, as the flow-control windows
+// of the stream and of the connection allow.
+func (st *http2Stream) writeData(p []byte, endStream bool) error {
+	c := st.c
+	for len(p) > 0 || endStream {
+		c.mu.Lock()
+		for len(p) > 0 && !st.reset && !c.closed && (st.sendWindow <= 0 || c.sendWindow <= 0) {
+			// 等待窗口之前先把已经写入的帧发出去 否则客户端不会更新窗口
+			c.mu.Unlock()
+			if err := c.flush(); err != nil {
+				return err
+			}
+			c.mu.Lock()
+			if !st.reset && !c.closed && (st.sendWindow <= 0 || c.sendWindow <= 0) {
+				c.cond.Wait()
+			}
+		}
+		if st.reset || c.closed {
+			c.mu.Unlock()
+			return errStreamClosed
+		}
+		n := int64(len(p))
+		for _, max := range []int64{st.sendWindow, c.sendWindow, int64(c.maxFrameSize)} {
+			if n > max {
+				n = max
+			}
+		}
+		st.sendWindow -= n
+		c.sendWindow -= n
+		c.mu.Unlock()
+
+		chunk := p[:n]
+		p = p[n:]
+		var flags byte
+		if endStream && len(p) == 0 {
+			flags = flagEndStream
+		}
+		if err := c.writeFrame(frameData, flags, st.id, chunk); err != nil {
+			return err
+		}
+		if flags != 0 {
+			return nil
+		}
+	}
+	return nil
+}
+
+// http2Body is the body of a request, fed by the DATA frames of its stream.
+type http2Body struct {
+	st *http2Stream
+}
+
+func (b *http2Body) Read(p []byte) (int, error) {
+	st := b.st
+	c := st.c
+	c.mu.Lock()
+	for st.body.Len() == 0 && !st.endStream && !st.reset && !c.closed {
+		c.cond.Wait()
+	}
+	if st.body.Len() > 0 {
+		n, _ := st.body.Read(p)
+		connInc, streamInc := c.consumed(st, int64(n))
+		c.mu.Unlock()
+		c.sendWindowUpdates(st.id, connInc, streamInc)
+		return n, nil
+	}
+	defer c.mu.Unlock()
+	if st.endStream {
+		return 0, io.EOF
+	}
+	return 0, errStreamClosed
+}
+
+// http2ResponseWriter is the ResponseWriter of a stream. Like
+// responseWriter, it buffers the beginning of a body of unknown length,
+// so that short responses get "Content-Length". Trailers declared in
+// the "Trailer" header are sent in a HEADERS frame after the body.
+type http2ResponseWriter struct {
+	st  *http2Stream
+	req *Request
+
+	header      Header
+	status      int
+	wroteHeader bool
+	headerSent  bool
+
+	contentLength int64 // declared by the handler, or -1 if unknown
+	written       int64 // body bytes written by the handler
+	body          bytes.Buffer
+}
+
+func (w *http2ResponseWriter) Header() Header {
+	return w.header
+}
+
+func (w *http2ResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = statusCode
+	if !w.header.Has("Date") {
+		w.header.Set("Date", FormatTime(time.Now()))
+	}
+	if !bodyAllowedForStatus(statusCode) {
+		w.header.Del("Content-Length")
+		w.header.Del("Trailer")
+		return
+	}
+	if w.header.Has("Content-Length") && !w.header.Has("Trailer") {
+		if n, err := strconv.ParseInt(w.header.Get("Content-Length"), 10, 64); err == nil && n >= 0 {
+			w.header.Set("Content-Length", strconv.FormatInt(n, 10))
+			w.contentLength = n
+		} else {
+			w.header.Del("Content-Length")
+		}
+	}
+}
+
+func (w *http2ResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if !bodyAllowedForStatus(w.status) {
+		return 0, ErrBodyNotAllowed
+	}
+	if w.contentLength != -1 && w.written+int64(len(p)) > w.contentLength {
+		return 0, ErrContentLength
+	}
+	w.written += int64(len(p))
+	if w.req != nil && w.req.Method == "HEAD" {
+		return len(p), nil
+	}
+	if w.headerSent {
+		if err := w.st.writeData(p, false); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	w.body.Write(p)
+	if w.body.Len() > bufferBeforeChunking || w.contentLength != -1 {
+		if err := w.sendBuffered(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends the headers and any buffered body to the client.
+func (w *http2ResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if !w.headerSent {
+		w.sendBuffered()
+	}
+	w.st.c.flush()
+}
+
+// stream lets an event stream know when the client goes away. Unlike
+// HTTP/1, the stream is not bound by the timeouts of the connection.
+func (w *http2ResponseWriter) stream() <-chan struct{} {
+	return w.st.gone
+}
+
+// sendBuffered sends the headers followed by the buffered body.
+func (w *http2ResponseWriter) sendBuffered() error {
+	if err := w.sendHeader(false); err != nil {
+		return err
+	}
+	err := w.st.writeData(w.body.Bytes(), false)
+	w.body.Reset()
+	return err
+}
+
+// finish completes the response after the handler has returned.
+func (w *http2ResponseWriter) finish() error {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	trailer := w.trailerFields()
+	if !w.headerSent {
+		if bodyAllowedForStatus(w.status) && !w.header.Has("Trailer") {
+			w.header.Set("Content-Length", strconv.FormatInt(w.written, 10))
+		}
+		if w.body.Len() == 0 && len(trailer) == 0 {
+			if err := w.sendHeader(true); err != nil {
+				return err
+			}
+			return w.st.c.flush()
+		}
+		if err := w.sendHeader(false); err != nil {
+			return err
+		}
+	}
+	if err := w.st.writeData(w.body.Bytes(), len(trailer) == 0); err != nil {
+		return err
+	}
+	w.body.Reset()
+	if len(trailer) > 0 {
+		if err := w.st.writeHeaders(trailer, true); err != nil {
+			return err
+		}
+	}
+	return w.st.c.flush()
+}
+
+// sendHeader sends the status and the headers, leaving out the fields
+// specific to HTTP/1 connections and those declared as trailers.
+func (w *http2ResponseWriter) sendHeader(endStream bool) error {
+	w.headerSent = true
+	skip := map[string]bool{
+		"Connection":        true,
+		"Keep-Alive":        true,
+		"Proxy-Connection":  true,
+		"Transfer-Encoding": true,
+		"Upgrade":           true,
+	}
+	for _, k := range w.trailerKeys() {
+		skip[k] = true
+	}
+	fields := []headerField{{":status", strconv.Itoa(w.status)}}
+	for _, k := range w.header.sortedKeys() {
+		if skip[k] {
+			continue
+		}
+		for _, v := range w.header[k] {
+			fields = append(fields, headerField{strings.ToLower(k), v})
+		}
+	}
+	return w.st.writeHeaders(fields, endStream)
+}
+
+// trailerKeys returns the canonical field names listed in the "Trailer" header.
+func (w *http2ResponseWriter) trailerKeys() []string {
+	var keys []string
+	for _, k := range strings.Split(w.header.Join("Trailer"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, CanonicalHeaderKey(k))
+		}
+	}
+	return keys
+}
+
+// trailerFields returns the declared trailers the handler has set.
+func (w *http2ResponseWriter) trailerFields() []headerField {
+	if w.req != nil && w.req.Method == "HEAD" {
+		return nil
+	}
+	var fields []headerField
+	for _, k := range w.trailerKeys() {
+		for _, v := range w.header[k] {
+			fields = append(fields, headerField{strings.ToLower(k), v})
+		}
+	}
+	return fields
+}
+
+// reset discards the response prepared so far.
+// It must not be called once the headers have been sent.
+func (w *http2ResponseWriter) reset() {
+	w.header = make(Header)
+	w.status = 0
+	w.wroteHeader = false
+	w.contentLength = -1
+	w.written = 0
+	w.body.Reset()
+}
+
+// validFieldName reports whether name is a valid HTTP/2 field name:
+// a token without upper case letters, RFC 9113 section 8.2.1.
+func validFieldName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; 'A' <= c && c <= 'Z' {
+			return false
+		}
+	}
+	return isToken(name)
+}
+
+// isH2CUpgrade reports whether req asks to switch to HTTP/2 over
+// cleartext, RFC 7540 section 3.2. Requests with a body keep HTTP/1.1,
+// as the server is free to ignore the upgrade.
+func isH2CUpgrade(req *Request) bool {
+	return req.Upgrade && req.Body == nil && hasToken(req.Header.Join("Upgrade"), "h2c") &&
+		len(req.Header.Values("Http2-Settings")) == 1
+}
+
+// upgradeH2C answers req, which asked for "Upgrade: h2c", with 101
+// Switching Protocols and serves HTTP/2 on its connection, the response
+// to req being sent on stream 1. It reports false, having written
+// nothing, if the settings of the request are invalid.
+func (s *Server) upgradeH2C(sc *serverConn, bw *bufio.Writer, req *Request) bool {
+	settings, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(req.Header.Get("Http2-Settings"), "="))
+	if err != nil || len(settings)%6 != 0 {
+		return false
+	}
+	req.Header.Del("Upgrade")
+	req.Header.Del("Http2-Settings")
+	req.Proto = "HTTP/2.0"
+	req.Upgrade, req.Close, req.KeepAlive = false, false, false
+	req.conn = nil
+	bw.WriteString("HTTP/1.1 101 Switching Protocols" + CRLF + "Connection: Upgrade" + CRLF + "Upgrade: h2c" + CRLF + CRLF)
+	s.serveHTTP2(sc.conn, sc.br, bw, req, settings)
+	return true
+}
+
+func (s *Server) maxConcurrentStreams() uint32 {
+	if s.MaxConcurrentStreams > 0 {
+		return s.MaxConcurrentStreams
+	}
+	return DefaultMaxConcurrentStreams
+}
@@ -0,0 +1,407 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// h2TestConn is the client side of an HTTP/2 connection, speaking raw frames.
+type h2TestConn struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	dec  *hpackDecoder
+}
+
+// h2TestResponse is a response read from an h2TestConn.
+type h2TestResponse struct {
+	header  map[string]string
+	body    string
+	trailer map[string]string
+}
+
+// dialHTTP2 opens an HTTP/2 connection with prior knowledge to addr.
+func dialHTTP2(t *testing.T, addr string) *h2TestConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	c := &h2TestConn{t: t, conn: conn, br: bufio.NewReader(conn), dec: newHPACKDecoder(hpackTableSize)}
+	io.WriteString(conn, http2Preface)
+	c.start()
+	return c
+}
+
+// start sends the SETTINGS of the client, and acknowledges those of the
+// server, which must be the first frame the server sends.
+func (c *h2TestConn) start() {
+	c.t.Helper()
+	c.writeFrame(frameSettings, 0, 0, nil)
+	if f := c.readFrame(); f.typ != frameSettings || f.flags&flagAck != 0 {
+		c.t.Fatalf("got frame type %v, want the SETTINGS of the server", f.typ)
+	}
+	c.writeFrame(frameSettings, flagAck, 0, nil)
+}
+
+func (c *h2TestConn) writeFrame(typ, flags byte, id uint32, payload []byte) {
+	c.t.Helper()
+	if err := writeHTTP2Frame(c.conn, typ, flags, id, payload); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *h2TestConn) readFrame() *http2Frame {
+	c.t.Helper()
+	f, err := readHTTP2Frame(c.br, http2DefaultFrameSize)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return f
+}
+
+// writeHeaders sends a HEADERS frame with the fields given as name,
+// value pairs.
+func (c *h2TestConn) writeHeaders(id uint32, endStream bool, kv ...string) {
+	c.t.Helper()
+	var block []byte
+	for i := 0; i < len(kv); i += 2 {
+		block = appendHeaderField(block, headerField{kv[i], kv[i+1]})
+	}
+	flags := byte(flagEndHeaders)
+	if endStream {
+		flags |= flagEndStream
+	}
+	c.writeFrame(frameHeaders, flags, id, block)
+}
+
+// readResponses reads frames until the responses of the streams ids
+// are complete, giving back the flow-control window of every DATA frame.
+func (c *h2TestConn) readResponses(ids ...uint32) map[uint32]*h2TestResponse {
+	c.t.Helper()
+	res := make(map[uint32]*h2TestResponse)
+	for _, id := range ids {
+		res[id] = &h2TestResponse{}
+	}
+	for pending := len(ids); pending > 0; {
+		f := c.readFrame()
+		r := res[f.streamID]
+		switch f.typ {
+		case frameHeaders:
+			fields := make(map[string]string)
+			if err := c.dec.decode(f.payload, func(hf headerField) { fields[hf.name] = hf.value }); err != nil {
+				c.t.Fatal(err)
+			}
+			if r.header == nil {
+				r.header = fields
+			} else {
+				r.trailer = fields
+			}
+		case frameData:
+			r.body += string(f.payload)
+			if len(f.payload) > 0 {
+				var p [4]byte
+				binary.BigEndian.PutUint32(p[:], uint32(len(f.payload)))
+				c.writeFrame(frameWindowUpdate, 0, 0, p[:])
+				c.writeFrame(frameWindowUpdate, 0, f.streamID, p[:])
+			}
+		case frameRSTStream, frameGoAway:
+			c.t.Fatalf("got frame type %v with code %x", f.typ, f.payload)
+		default:
+			continue
+		}
+		if f.flags&flagEndStream != 0 {
+			pending--
+		}
+	}
+	return res
+}
+
+// startHTTP2Server starts a server with H2C enabled and a few handlers.
+func startHTTP2Server(t *testing.T) (string, *Server) {
+	t.Helper()
+	mux := NewServeMux()
+	mux.HandleFunc("/hello", func(w ResponseWriter, req *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Connection", "keep-alive") // 不能出现在HTTP/2中
+		fmt.Fprintf(w, "hello %v %v %v", req.Proto, req.Host, req.Header.Get("Cookie"))
+	})
+	mux.HandleFunc("/echo", func(w ResponseWriter, req *Request) {
+		w.Header().Set("Trailer", "X-Length")
+		n, err := io.Copy(w, req.Body)
+		w.Header().Set("X-Length", fmt.Sprint(n, " ", err, " ", req.Trailer.Get("X-Sum")))
+	})
+	mux.HandleFunc("/big", func(w ResponseWriter, req *Request) {
+		io.WriteString(w, strings.Repeat("0123456789", 20000))
+	})
+	s := &Server{Handler: mux, H2C: true}
+	addr, _ := startServer(t, s)
+	return addr, s
+}
+
+func TestHTTP2PriorKnowledge(t *testing.T) {
+	addr, s := startHTTP2Server(t)
+	defer s.Close()
+	c := dialHTTP2(t, addr)
+	defer c.conn.Close()
+
+	c.writeHeaders(1, true, ":method", "GET", ":scheme", "http", ":path", "/hello", ":authority", "test",
+		"cookie", "a=1", "cookie", "b=2")
+	c.writeHeaders(3, false, ":method", "POST", ":scheme", "http", ":path", "/echo", ":authority", "test",
+		"content-length", "11")
+	// 200000字节的响应超过默认的窗口 需要客户端更新窗口
+	c.writeHeaders(5, true, ":method", "GET", ":scheme", "http", ":path", "/big", ":authority", "test")
+	c.writeHeaders(7, true, ":method", "HEAD", ":scheme", "http", ":path", "/hello", ":authority", "test")
+	c.writeFrame(frameData, 0, 3, []byte("hello "))
+	c.writeFrame(frameData, flagPadded, 3, []byte("\x03world\x00\x00\x00"))
+	c.writeHeaders(3, true, "x-sum", "42")
+
+	res := c.readResponses(1, 3, 5, 7)
+	if r := res[1]; r.header[":status"] != "200" || r.body != "hello HTTP/2.0 test a=1; b=2" ||
+		r.header["content-length"] != "28" || r.header["connection"] != "" {
+		t.Fatalf("stream 1 got %v %q", r.header, r.body)
+	}
+	if r := res[3]; r.header[":status"] != "200" || r.body != "hello world" || r.trailer["x-length"] != "11 <nil> 42" {
+		t.Fatalf("stream 3 got %v %q %v", r.header, r.body, r.trailer)
+	}
+	if r := res[5]; r.header[":status"] != "200" || len(r.body) != 200000 || r.header["content-length"] != "" {
+		t.Fatalf("stream 5 got %v and %v bytes", r.header, len(r.body))
+	}
+	if r := res[7]; r.header[":status"] != "200" || r.body != "" || r.header["content-length"] != "20" {
+		t.Fatalf("stream 7 got %v %q", r.header, r.body)
+	}
+
+	// PING is echoed
+	c.writeFrame(framePing, 0, 0, []byte("12345678"))
+	for {
+		if f := c.readFrame(); f.typ == framePing {
+			if f.flags&flagAck == 0 || string(f.payload) != "12345678" {
+				t.Fatalf("got PING %v %q", f.flags, f.payload)
+			}
+			break
+		}
+	}
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	addr, s := startHTTP2Server(t)
+	defer s.Close()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	io.WriteString(conn, "GET /hello HTTP/1.1\r\nHost: test\r\nConnection: Upgrade, HTTP2-Settings\r\n"+
+		"Upgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQCAAAAAAIAAAAA\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err := readResponseHeader(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 101 || res.Header.Get("Upgrade") != "h2c" || res.Header.Get("Connection") != "Upgrade" {
+		t.Fatalf("got %v %v", res.StatusCode, res.Header)
+	}
+
+	c := &h2TestConn{t: t, conn: conn, br: br, dec: newHPACKDecoder(hpackTableSize)}
+	io.WriteString(conn, http2Preface)
+	c.start()
+	if r := c.readResponses(1)[1]; r.header[":status"] != "200" || r.body != "hello HTTP/2.0 test " {
+		t.Fatalf("stream 1 got %v %q", r.header, r.body)
+	}
+	// 升级之后可以继续打开新的流
+	c.writeHeaders(3, true, ":method", "GET", ":scheme", "http", ":path", "/hello", ":authority", "test")
+	if r := c.readResponses(3)[3]; r.header[":status"] != "200" {
+		t.Fatalf("stream 3 got %v %q", r.header, r.body)
+	}
+}
+
+func TestHTTP2Errors(t *testing.T) {
+	get := []string{":method", "GET", ":scheme", "http", ":path", "/hello", ":authority", "test"}
+	var tests = []struct {
+		name     string
+		send     func(c *h2TestConn)
+		wantType byte
+		wantCode http2ErrCode
+	}{
+		{
+			"PingLength",
+			func(c *h2TestConn) { c.writeFrame(framePing, 0, 0, []byte("1234")) },
+			frameGoAway, errCodeFrameSize,
+		},
+		{
+			"EvenStream",
+			func(c *h2TestConn) { c.writeHeaders(2, true, get...) },
+			frameGoAway, errCodeProtocol,
+		},
+		{
+			"DataOnIdleStream",
+			func(c *h2TestConn) { c.writeFrame(frameData, flagEndStream, 9, []byte("x")) },
+			frameGoAway, errCodeProtocol,
+		},
+		{
+			"InvalidHPACK",
+			func(c *h2TestConn) { c.writeFrame(frameHeaders, flagEndHeaders|flagEndStream, 1, []byte{0x80}) },
+			frameGoAway, errCodeCompression,
+		},
+		{
+			"ContinuationWithoutHeaders",
+			func(c *h2TestConn) { c.writeFrame(frameContinuation, flagEndHeaders, 1, nil) },
+			frameGoAway, errCodeProtocol,
+		},
+		{
+			"WindowOverflow",
+			func(c *h2TestConn) { c.writeFrame(frameWindowUpdate, 0, 0, []byte{0x7f, 0xff, 0xff, 0xff}) },
+			frameGoAway, errCodeFlowControl,
+		},
+		{
+			"ConnectionHeader",
+			func(c *h2TestConn) { c.writeHeaders(1, true, append(get, "connection", "keep-alive")...) },
+			frameRSTStream, errCodeProtocol,
+		},
+		{
+			"UpperCaseName",
+			func(c *h2TestConn) { c.writeHeaders(1, true, append(get, "X-Upper", "1")...) },
+			frameRSTStream, errCodeProtocol,
+		},
+		{
+			"MissingPath",
+			func(c *h2TestConn) { c.writeHeaders(1, true, ":method", "GET", ":scheme", "http") },
+			frameRSTStream, errCodeProtocol,
+		},
+		{
+			"PseudoAfterRegular",
+			func(c *h2TestConn) { c.writeHeaders(1, true, append([]string{"accept", "*/*"}, get...)...) },
+			frameRSTStream, errCodeProtocol,
+		},
+		{
+			"ShortBody",
+			func(c *h2TestConn) {
+				c.writeHeaders(1, false, ":method", "POST", ":scheme", "http", ":path", "/echo", "content-length", "5")
+				c.writeFrame(frameData, flagEndStream, 1, []byte("abc"))
+			},
+			frameRSTStream, errCodeProtocol,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, s := startHTTP2Server(t)
+			defer s.Close()
+			c := dialHTTP2(t, addr)
+			defer c.conn.Close()
+			tt.send(c)
+			for {
+				f := c.readFrame()
+				if f.typ != frameGoAway && f.typ != frameRSTStream {
+					continue
+				}
+				code := binary.BigEndian.Uint32(f.payload[len(f.payload)-4:])
+				if f.typ == frameGoAway {
+					code = binary.BigEndian.Uint32(f.payload[4:])
+				}
+				if f.typ != tt.wantType || http2ErrCode(code) != tt.wantCode {
+					t.Fatalf("got frame type %v with code %v, want %v with %v", f.typ, code, tt.wantType, tt.wantCode)
+				}
+				return
+			}
+		})
+	}
+}
+
+func TestHTTP2Streams(t *testing.T) {
+	release := make(chan struct{})
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+			<-release
+			io.WriteString(w, "done")
+		}),
+		H2C:                  true,
+		MaxConcurrentStreams: 1,
+		IdleTimeout:          100 * time.Millisecond,
+	}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+	c := dialHTTP2(t, addr)
+	defer c.conn.Close()
+
+	get := []string{":method", "GET", ":scheme", "http", ":path", "/", ":authority", "test"}
+	c.writeHeaders(1, true, get...)
+	c.writeHeaders(3, true, get...)
+	for {
+		f := c.readFrame()
+		if f.typ == frameRSTStream {
+			if f.streamID != 3 || binary.BigEndian.Uint32(f.payload) != uint32(errCodeRefusedStream) {
+				t.Fatalf("got RST_STREAM %v %x, want stream 3 refused", f.streamID, f.payload)
+			}
+			break
+		}
+	}
+	close(release)
+	if r := c.readResponses(1)[1]; r.body != "done" {
+		t.Fatalf("stream 1 got %v %q", r.header, r.body)
+	}
+
+	// 没有打开的流 空闲超时后收到GOAWAY 然后连接关闭
+	// 被拒绝的流3也算作已经处理过的流
+	for {
+		f := c.readFrame()
+		if f.typ == frameGoAway {
+			if binary.BigEndian.Uint32(f.payload) != 3 || binary.BigEndian.Uint32(f.payload[4:]) != uint32(errCodeNo) {
+				t.Fatalf("got GOAWAY %x", f.payload)
+			}
+			break
+		}
+	}
+	if _, err := c.br.ReadByte(); err != io.EOF {
+		t.Fatalf("got %v, want the connection closed", err)
+	}
+}
+
+func TestHTTP2HeaderTooLarge(t *testing.T) {
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, req *Request) {}), H2C: true, MaxHeaderBytes: 300}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+	c := dialHTTP2(t, addr)
+	defer c.conn.Close()
+
+	// 头部过大的请求得到431 同一个流上随后的trailer不能让服务器崩溃
+	var block []byte
+	for _, hf := range []headerField{{":method", "POST"}, {":scheme", "http"}, {":path", "/"},
+		{"x-big", strings.Repeat("x", 400)}} {
+		block = appendHeaderField(block, hf)
+	}
+	var frames bytes.Buffer
+	writeHTTP2Frame(&frames, frameHeaders, flagEndHeaders, 1, block)
+	writeHTTP2Frame(&frames, frameHeaders, flagEndHeaders|flagEndStream, 1, appendHeaderField(nil, headerField{"x-sum", "1"}))
+	if _, err := c.conn.Write(frames.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		f := c.readFrame()
+		if f.typ == frameHeaders && f.streamID == 1 {
+			fields := make(map[string]string)
+			c.dec.decode(f.payload, func(hf headerField) { fields[hf.name] = hf.value })
+			if fields[":status"] != "431" {
+				t.Fatalf("got %v, want 431", fields)
+			}
+			break
+		}
+	}
+
+	// 服务器仍在运行
+	c = dialHTTP2(t, addr)
+	defer c.conn.Close()
+	c.writeHeaders(1, true, ":method", "GET", ":scheme", "http", ":path", "/", ":authority", "test")
+	if r := c.readResponses(1)[1]; r.header[":status"] != "200" {
+		t.Fatalf("got %v", r.header)
+	}
+}
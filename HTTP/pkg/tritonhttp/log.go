@@ -0,0 +1,305 @@
+package tritonhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogFormat selects the line format of an AccessLogger.
+type LogFormat int
+
+const (
+	// LogCommon is the Common Log Format, e.g.
+	// 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326
+	LogCommon LogFormat = iota
+
+	// LogCombined is the Common Log Format followed by
+	// the quoted "Referer" and "User-Agent" headers.
+	LogCombined
+
+	// LogJSON writes one JSON object per line, including the
+	// duration of the request in milliseconds.
+	LogJSON
+)
+
+// ParseLogFormat returns the LogFormat named "common", "combined" or "json".
+func ParseLogFormat(name string) (LogFormat, error) {
+	switch strings.ToLower(name) {
+	case "common", "clf":
+		return LogCommon, nil
+	case "combined":
+		return LogCombined, nil
+	case "json":
+		return LogJSON, nil
+	}
+	return 0, fmt.Errorf("unknown log format %q", name)
+}
+
+// LogEntry describes one request for the access log.
+// Method, URL and Proto are empty when the request could not be parsed.
+type LogEntry struct {
+	Time       time.Time
+	RemoteAddr string
+	Method     string
+	URL        string
+	Proto      string
+	Status     int
+	Bytes      int64 // body bytes written
+	Duration   time.Duration
+	UserAgent  string
+	Referer    string
+}
+
+// AccessLogger writes one line per request to Out.
+// It is safe for concurrent use.
+type AccessLogger struct {
+	Format LogFormat
+	Out    io.Writer
+
+	mu sync.Mutex
+}
+
+// NewAccessLogger returns an AccessLogger writing lines in format to out.
+func NewAccessLogger(out io.Writer, format LogFormat) *AccessLogger {
+	return &AccessLogger{Format: format, Out: out}
+}
+
+// Log writes the line for e.
+func (l *AccessLogger) Log(e *LogEntry) {
+	var line string
+	switch l.Format {
+	case LogJSON:
+		b, err := json.Marshal(struct {
+			Time       string  `json:"time"`
+			RemoteAddr string  `json:"remote_addr"`
+			Method     string  `json:"method"`
+			URL        string  `json:"url"`
+			Proto      string  `json:"proto"`
+			Status     int     `json:"status"`
+			Bytes      int64   `json:"bytes"`
+			DurationMs float64 `json:"duration_ms"`
+			UserAgent  string  `json:"user_agent,omitempty"`
+			Referer    string  `json:"referer,omitempty"`
+		}{
+			e.Time.Format(time.RFC3339Nano), e.RemoteAddr, e.Method, e.URL, e.Proto,
+			e.Status, e.Bytes, float64(e.Duration) / float64(time.Millisecond),
+			e.UserAgent, e.Referer,
+		})
+		if err != nil {
+			return
+		}
+		line = string(b) + "\n"
+	default:
+		host := e.RemoteAddr
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		requestLine := "-"
+		if e.Method != "" {
+			requestLine = e.Method + " " + e.URL + " " + e.Proto
+		}
+		bytes := "-"
+		if e.Bytes > 0 {
+			bytes = strconv.FormatInt(e.Bytes, 10)
+		}
+		line = fmt.Sprintf("%s - - [%s] \"%s\" %d %s",
+			orDash(host), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			escapeLogField(requestLine), e.Status, bytes)
+		if l.Format == LogCombined {
+			line += fmt.Sprintf(" \"%s\" \"%s\"", escapeLogField(orDash(e.Referer)), escapeLogField(orDash(e.UserAgent)))
+		}
+		line += "\n"
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.Out, line)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escapeLogField escapes quotes, backslashes and control characters
+// so that a client cannot forge log lines.
+func escapeLogField(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// RotatingFile is an io.WriteCloser appending to the file at Path.
+// When a write would grow the file past MaxBytes, the file is renamed
+// to Path.1 (shifting older backups up to Path.MaxBackups) and a new
+// file is started. It is safe for concurrent use.
+type RotatingFile struct {
+	Path       string
+	MaxBytes   int64 // 0 means never rotate
+	MaxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens, or creates, the log file at path.
+func OpenRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{Path: path, MaxBytes: maxBytes, MaxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = fi.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if needed.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if rf.MaxBytes > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxBytes {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	rf.f = nil
+	if rf.MaxBackups <= 0 {
+		os.Remove(rf.Path)
+	} else {
+		os.Remove(rf.backup(rf.MaxBackups))
+		for i := rf.MaxBackups - 1; i >= 1; i-- {
+			os.Rename(rf.backup(i), rf.backup(i+1))
+		}
+		if err := os.Rename(rf.Path, rf.backup(1)); err != nil {
+			return err
+		}
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) backup(i int) string {
+	return rf.Path + "." + strconv.Itoa(i)
+}
+
+// Close closes the current file.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
+
+// LogLevel is the severity of an error log message.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (lv LogLevel) String() string {
+	if lv < LevelDebug || lv > LevelError {
+		return "LEVEL(" + strconv.Itoa(int(lv)) + ")"
+	}
+	return levelNames[lv]
+}
+
+// ParseLogLevel returns the LogLevel named "debug", "info", "warn" or "error".
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+// ErrorLogger is a leveled logger for server errors and diagnostics.
+// Messages below Level are dropped. A nil Logger means the standard
+// logger of the log package.
+type ErrorLogger struct {
+	Level  LogLevel
+	Logger *log.Logger
+}
+
+// NewErrorLogger returns an ErrorLogger writing messages of at least
+// level to out.
+func NewErrorLogger(out io.Writer, level LogLevel) *ErrorLogger {
+	return &ErrorLogger{Level: level, Logger: log.New(out, "", log.LstdFlags)}
+}
+
+// defaultErrorLog is used when Server.ErrorLog is nil.
+var defaultErrorLog = &ErrorLogger{Level: LevelWarn}
+
+func (l *ErrorLogger) logf(level LogLevel, format string, args ...interface{}) {
+	if level < l.Level {
+		return
+	}
+	msg := "[" + level.String() + "] " + fmt.Sprintf(format, args...)
+	if l.Logger != nil {
+		l.Logger.Output(3, msg)
+	} else {
+		log.Output(3, msg)
+	}
+}
+
+func (l *ErrorLogger) Debugf(format string, args ...interface{}) { l.logf(LevelDebug, format, args...) }
+func (l *ErrorLogger) Infof(format string, args ...interface{})  { l.logf(LevelInfo, format, args...) }
+func (l *ErrorLogger) Warnf(format string, args ...interface{})  { l.logf(LevelWarn, format, args...) }
+func (l *ErrorLogger) Errorf(format string, args ...interface{}) { l.logf(LevelError, format, args...) }
@@ -0,0 +1,149 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLoggerFormats(t *testing.T) {
+	e := &LogEntry{
+		Time:       time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		RemoteAddr: "127.0.0.1:52000",
+		Method:     "GET",
+		URL:        "/index.html",
+		Proto:      "HTTP/1.1",
+		Status:     200,
+		Bytes:      2326,
+		Duration:   1500 * time.Microsecond,
+		UserAgent:  `curl/7.79 "test"`,
+	}
+	var tests = []struct {
+		name   string
+		format LogFormat
+		entry  *LogEntry
+		want   string
+	}{
+		{
+			"Common",
+			LogCommon,
+			e,
+			`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326` + "\n",
+		},
+		{
+			"Combined",
+			LogCombined,
+			e,
+			`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326 "-" "curl/7.79 \"test\""` + "\n",
+		},
+		{
+			"BadRequest",
+			LogCommon,
+			&LogEntry{Time: e.Time, RemoteAddr: "127.0.0.1:52000", Status: 400},
+			`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "-" 400 -` + "\n",
+		},
+		{
+			"EscapedURL",
+			LogCommon,
+			&LogEntry{Time: e.Time, RemoteAddr: "127.0.0.1:52000", Method: "GET", URL: "/a\"\nb", Proto: "HTTP/1.1", Status: 404, Bytes: 10},
+			`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a\"\x0ab HTTP/1.1" 404 10` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			NewAccessLogger(&buf, tt.format).Log(tt.entry)
+			if buf.String() != tt.want {
+				t.Fatalf("got: %q, want: %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	NewAccessLogger(&buf, LogJSON).Log(&LogEntry{
+		Time:       time.Now(),
+		RemoteAddr: "127.0.0.1:52000",
+		Method:     "HEAD",
+		URL:        "/",
+		Proto:      "HTTP/1.1",
+		Status:     200,
+		Duration:   2 * time.Millisecond,
+	})
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("got invalid JSON %q: %v", buf.String(), err)
+	}
+	if got["method"] != "HEAD" || got["status"] != 200.0 || got["duration_ms"] != 2.0 {
+		t.Fatalf("got: %v", got)
+	}
+	if _, ok := got["user_agent"]; ok {
+		t.Fatalf("got empty user_agent: %v", got)
+	}
+}
+
+func TestParseLogFormat(t *testing.T) {
+	for name, want := range map[string]LogFormat{"common": LogCommon, "Combined": LogCombined, "json": LogJSON} {
+		if got, err := ParseLogFormat(name); err != nil || got != want {
+			t.Errorf("ParseLogFormat(%q) got: %v, %v, want: %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseLogFormat("xml"); err == nil {
+		t.Error("ParseLogFormat(\"xml\") got no error")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for p, content := range want {
+		got, err := os.ReadFile(p)
+		if err != nil || string(got) != content {
+			t.Errorf("%v got: %q, %v, want: %q", filepath.Base(p), got, err, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("got more than 2 backups: %v", err)
+	}
+}
+
+func TestErrorLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewErrorLogger(&buf, LevelInfo)
+	l.Debugf("dropped %v", 1)
+	l.Infof("kept %v", 2)
+	l.Errorf("kept %v", 3)
+	got := buf.String()
+	if strings.Contains(got, "dropped") {
+		t.Errorf("got debug message at info level: %q", got)
+	}
+	if !strings.Contains(got, "[INFO] kept 2") || !strings.Contains(got, "[ERROR] kept 3") {
+		t.Errorf("got: %q", got)
+	}
+	if lv, err := ParseLogLevel("warn"); err != nil || lv != LevelWarn {
+		t.Errorf("ParseLogLevel(\"warn\") got: %v, %v", lv, err)
+	}
+}
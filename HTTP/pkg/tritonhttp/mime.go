@@ -0,0 +1,251 @@
+package tritonhttp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultCharset is the charset declared for text types
+// when FileHandler.Charset is empty.
+const DefaultCharset = "utf-8"
+
+// NoCharset, as FileHandler.Charset, sends text types without
+// a "charset" parameter.
+const NoCharset = "none"
+
+// sniffLen is how much of a file DetectContentType looks at.
+const sniffLen = 512
+
+// builtinMIMETypes maps the extensions most often served to their
+// types, so that the result does not depend on the MIME database of
+// the system, which is missing on some platforms and wrong on others.
+var builtinMIMETypes = map[string]string{
+	".avif":  "image/avif",
+	".css":   "text/css",
+	".csv":   "text/csv",
+	".gif":   "image/gif",
+	".gz":    "application/gzip",
+	".htm":   "text/html",
+	".html":  "text/html",
+	".ico":   "image/vnd.microsoft.icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "text/javascript",
+	".json":  "application/json",
+	".md":    "text/markdown",
+	".mjs":   "text/javascript",
+	".mp3":   "audio/mpeg",
+	".mp4":   "video/mp4",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".txt":   "text/plain",
+	".wasm":  "application/wasm",
+	".webm":  "video/webm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "text/xml",
+	".zip":   "application/zip",
+}
+
+// ContentType returns the "Content-Type" of the file at filePath.
+// The type is looked up by extension in overrides, whose keys are
+// lower case and start with a dot, then in the built-in table, then
+// with MIMETypeByExtension. If all fail, the first 512 bytes of the
+// file are sniffed with DetectContentType.
+//
+// Text types get a "charset" parameter unless they already have one:
+// charset, DefaultCharset if it is empty, or none if it is NoCharset.
+func ContentType(filePath string, overrides map[string]string, charset string) string {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	ctype := overrides[ext]
+	if ctype == "" {
+		ctype = builtinMIMETypes[ext]
+	}
+	if ctype == "" && ext != "" {
+		ctype = MIMETypeByExtension(ext)
+	}
+	if ctype == "" {
+		ctype = "application/octet-stream"
+		if file, err := os.Open(filePath); err == nil {
+			buf := make([]byte, sniffLen)
+			n, _ := io.ReadFull(file, buf)
+			file.Close()
+			ctype = DetectContentType(buf[:n])
+		}
+	}
+	return withCharset(ctype, charset)
+}
+
+// withCharset adds the charset parameter to ctype if it is a text type.
+func withCharset(ctype, charset string) string {
+	if charset == "" {
+		charset = DefaultCharset
+	}
+	if charset == NoCharset || strings.Contains(strings.ToLower(ctype), "charset=") {
+		return ctype
+	}
+	if !strings.HasPrefix(ctype, "text/") {
+		return ctype
+	}
+	return ctype + "; charset=" + charset
+}
+
+// A sniffSignature matches data starting with pattern, once the bits
+// cleared in mask are ignored. An empty mask keeps every bit.
+type sniffSignature struct {
+	pattern string
+	mask    string
+	ctype   string
+}
+
+func (sig *sniffSignature) match(data []byte) bool {
+	if len(data) < len(sig.pattern) {
+		return false
+	}
+	for i := 0; i < len(sig.pattern); i++ {
+		b := data[i]
+		if sig.mask != "" {
+			b &= sig.mask[i]
+		}
+		if b != sig.pattern[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sniffSignatures are the magic numbers DetectContentType recognizes,
+// in the order of the WHATWG MIME Sniffing Standard: scriptable types,
+// byte order marks, images, audio and video, fonts, then archives.
+var sniffSignatures = []sniffSignature{
+	{pattern: "%PDF-", ctype: "application/pdf"},
+	{pattern: "%!PS-Adobe-", ctype: "application/postscript"},
+
+	{pattern: "\xfe\xff", ctype: "text/plain; charset=utf-16be"},
+	{pattern: "\xff\xfe", ctype: "text/plain; charset=utf-16le"},
+	{pattern: "\xef\xbb\xbf", ctype: "text/plain"},
+
+	{pattern: "\x00\x00\x01\x00", ctype: "image/x-icon"},
+	{pattern: "\x00\x00\x02\x00", ctype: "image/x-icon"},
+	{pattern: "BM", ctype: "image/bmp"},
+	{pattern: "GIF87a", ctype: "image/gif"},
+	{pattern: "GIF89a", ctype: "image/gif"},
+	{pattern: "RIFF\x00\x00\x00\x00WEBPVP", mask: "\xff\xff\xff\xff\x00\x00\x00\x00\xff\xff\xff\xff\xff\xff", ctype: "image/webp"},
+	{pattern: "\x89PNG\r\n\x1a\n", ctype: "image/png"},
+	{pattern: "\xff\xd8\xff", ctype: "image/jpeg"},
+
+	{pattern: "\x1a\x45\xdf\xa3", ctype: "video/webm"},
+	{pattern: "OggS\x00", ctype: "application/ogg"},
+	{pattern: "MThd\x00\x00\x00\x06", ctype: "audio/midi"},
+	{pattern: "ID3", ctype: "audio/mpeg"},
+	{pattern: "RIFF\x00\x00\x00\x00AVI ", mask: "\xff\xff\xff\xff\x00\x00\x00\x00\xff\xff\xff\xff", ctype: "video/avi"},
+	{pattern: "RIFF\x00\x00\x00\x00WAVE", mask: "\xff\xff\xff\xff\x00\x00\x00\x00\xff\xff\xff\xff", ctype: "audio/wave"},
+
+	{pattern: "\x00\x01\x00\x00", ctype: "font/ttf"},
+	{pattern: "OTTO", ctype: "font/otf"},
+	{pattern: "ttcf", ctype: "font/collection"},
+	{pattern: "wOFF", ctype: "font/woff"},
+	{pattern: "wOF2", ctype: "font/woff2"},
+
+	{pattern: "\x1f\x8b\x08", ctype: "application/gzip"},
+	{pattern: "PK\x03\x04", ctype: "application/zip"},
+	{pattern: "Rar!\x1a\x07\x00", ctype: "application/x-rar-compressed"},
+	{pattern: "Rar!\x1a\x07\x01\x00", ctype: "application/x-rar-compressed"},
+	{pattern: "\x00asm", ctype: "application/wasm"},
+}
+
+// htmlSignatures start an HTML document, once leading whitespace is
+// skipped, compared without regard to ASCII case.
+var htmlSignatures = []string{
+	"<!doctype html", "<html", "<head", "<script", "<iframe", "<h1", "<div",
+	"<font", "<table", "<a", "<style", "<title", "<b", "<body", "<br", "<p", "<!--",
+}
+
+// DetectContentType guesses the type of data from at most its first
+// 512 bytes with the WHATWG MIME Sniffing Standard: HTML and XML
+// markers, then magic numbers, then text versus binary. It always
+// returns a valid type, "application/octet-stream" if nothing else
+// matches. Text types only carry a charset when data starts with a
+// UTF-16 byte order mark, so that the charset configured for the files
+// served applies to the others.
+func DetectContentType(data []byte) string {
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+
+	text := bytes.TrimLeft(data, "\t\n\x0c\r ")
+	for _, sig := range htmlSignatures {
+		// The tag must be followed by a space or ">"
+		if len(text) > len(sig) && hasPrefixFold(text, sig) &&
+			(text[len(sig)] == ' ' || text[len(sig)] == '>') {
+			return "text/html"
+		}
+	}
+	if bytes.HasPrefix(text, []byte("<?xml")) {
+		return "text/xml"
+	}
+	for i := range sniffSignatures {
+		if sniffSignatures[i].match(data) {
+			return sniffSignatures[i].ctype
+		}
+	}
+	if isMP4(data) {
+		return "video/mp4"
+	}
+	for _, b := range data {
+		// Control bytes never found in text
+		if b <= 0x08 || b == 0x0b || (b >= 0x0e && b <= 0x1a) || (b >= 0x1c && b <= 0x1f) {
+			return "application/octet-stream"
+		}
+	}
+	return "text/plain"
+}
+
+// hasPrefixFold reports whether data starts with the lower case ASCII
+// prefix, ignoring the case of data.
+func hasPrefixFold(data []byte, prefix string) bool {
+	if len(data) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		b := data[i]
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		if b != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isMP4 reports whether data starts with an "ftyp" box whose major or
+// compatible brands include "mp4".
+func isMP4(data []byte) bool {
+	if len(data) < 12 {
+		return false
+	}
+	boxSize := int(binary.BigEndian.Uint32(data[:4]))
+	if boxSize < 12 || len(data) < boxSize || boxSize%4 != 0 {
+		return false
+	}
+	if string(data[4:8]) != "ftyp" {
+		return false
+	}
+	// 主品牌在 8:12，兼容品牌从 16 开始，12:16 是版本号
+	for i := 8; i+3 <= boxSize; i += 4 {
+		if i == 12 {
+			continue
+		}
+		if string(data[i:i+3]) == "mp4" {
+			return true
+		}
+	}
+	return false
+}
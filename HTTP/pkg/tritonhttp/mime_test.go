@@ -0,0 +1,91 @@
+package tritonhttp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectContentType(t *testing.T) {
+	var tests = []struct {
+		name string
+		data string
+		want string
+	}{
+		{"Empty", "", "text/plain"},
+		{"Text", "just some text\n", "text/plain"},
+		{"HTML", "\n  <!DOCTYPE html><html></html>", "text/html"},
+		{"HTMLTag", "<HTML>", "text/html"},
+		{"NotATag", "<address>", "text/plain"},
+		{"XML", "<?xml version=\"1.0\"?>", "text/xml"},
+		{"PNG", "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR", "image/png"},
+		{"JPEG", "\xff\xd8\xff\xe0\x00\x10JFIF", "image/jpeg"},
+		{"GIF", "GIF89a\x01\x00", "image/gif"},
+		{"WebP", "RIFF\x00\x00\x00\x00WEBPVP8 ", "image/webp"},
+		{"PDF", "%PDF-1.7\n", "application/pdf"},
+		{"UTF16", "\xff\xfeh\x00i\x00", "text/plain; charset=utf-16le"},
+		{"UTF8BOM", "\xef\xbb\xbfhi", "text/plain"},
+		{"HTMLComment", "\t<!-- x -->", "text/html"},
+		{"ICO", "\x00\x00\x01\x00\x01\x00", "image/x-icon"},
+		{"BMP", "BM\x36\x00\x00\x00", "image/bmp"},
+		{"WAVE", "RIFF\x24\x00\x00\x00WAVEfmt ", "audio/wave"},
+		{"Ogg", "OggS\x00\x02", "application/ogg"},
+		{"TTF", "\x00\x01\x00\x00\x00\x0e", "font/ttf"},
+		{"MP4", "\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isommp41", "video/mp4"},
+		{"MP4TruncatedBox", "\x00\x00\x00\x20ftypmp42\x00\x00\x00\x00", "application/octet-stream"},
+		{"Binary", "\x00\x01\x02\x03", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectContentType([]byte(tt.data)); got != tt.want {
+				t.Fatalf("got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentType(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"photo.JPG":     "not really a jpeg",
+		"style.css":     "body {}",
+		"notes.log":     "log line\n",
+		"noext":         "<html><body></body></html>",
+		"image.unknown": "\x89PNG\r\n\x1a\n",
+		"blob.unknown":  "\x00\x01\x02",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	overrides := map[string]string{".log": "text/x-log", ".css": "text/css; charset=iso-8859-1"}
+
+	var tests = []struct {
+		file      string
+		overrides map[string]string
+		charset   string
+		want      string
+	}{
+		{"testdata/index.html", nil, "", "text/html; charset=utf-8"},
+		{"testdata/fake.jpg", nil, "", "image/jpeg"},
+		{"testdata/fake.png", nil, "", "image/png"},
+		{"photo.JPG", nil, "", "image/jpeg"},
+		{"style.css", nil, "", "text/css; charset=utf-8"},
+		{"style.css", nil, NoCharset, "text/css"},
+		{"style.css", nil, "iso-8859-1", "text/css; charset=iso-8859-1"},
+		{"style.css", overrides, "", "text/css; charset=iso-8859-1"},
+		{"notes.log", overrides, "", "text/x-log; charset=utf-8"},
+		{"noext", nil, "", "text/html; charset=utf-8"},
+		{"image.unknown", nil, "", "image/png"},
+		{"blob.unknown", nil, "", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		p := tt.file
+		if filepath.Dir(p) == "." {
+			p = filepath.Join(dir, p)
+		}
+		if got := ContentType(p, tt.overrides, tt.charset); got != tt.want {
+			t.Errorf("ContentType(%q, %v, %q) got: %q, want: %q", tt.file, tt.overrides, tt.charset, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package tritonhttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxLeadingEmptyLines is how many empty lines ReadRequest skips before
+// a request line, as RFC 9112 section 2.2 allows.
+const maxLeadingEmptyLines = 4
+
+// A ProtocolError reports a request that breaks the HTTP/1.1 syntax of
+// RFC 9112. StatusCode is the status the server answers it with: 400 Bad
+// Request, 501 Not Implemented or 505 HTTP Version Not Supported.
+type ProtocolError struct {
+	StatusCode int
+	Msg        string
+}
+
+func (e *ProtocolError) Error() string {
+	return "tritonhttp: " + e.Msg
+}
+
+// badRequest returns a ProtocolError answered with 400 Bad Request.
+func badRequest(format string, args ...interface{}) error {
+	return &ProtocolError{400, fmt.Sprintf(format, args...)}
+}
+
+// errorStatus returns the status code answering a request that failed
+// to parse with err.
+func errorStatus(err error) int {
+	var pe *ProtocolError
+	if errors.As(err, &pe) {
+		return pe.StatusCode
+	}
+	return 400
+}
+
+// readError converts an error met while reading the request line or the
+// headers. Time-outs are reported as "i/o timeout" and the end of the
+// input in the middle of a request as io.ErrUnexpectedEOF.
+func readError(err error, bytesReceived bool) error {
+	if strings.Contains(err.Error(), "i/o timeout") {
+		return errors.New("i/o timeout")
+	}
+	if err == io.EOF && bytesReceived {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// isTokenChar reports whether c may appear in a token, RFC 9110 section 5.6.2.
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1
+}
+
+// isToken reports whether s is a non-empty token, such as a method
+// or a header field name.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// validFieldValue reports whether v is a valid header field value:
+// no control characters other than horizontal tab.
+func validFieldValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// checkVersion validates an HTTP-version such as "HTTP/1.1". Versions
+// other than 1.x are well-formed but not supported.
+func checkVersion(proto string) error {
+	if len(proto) != len("HTTP/1.1") || !strings.HasPrefix(proto, "HTTP/") || proto[6] != '.' ||
+		proto[5] < '0' || proto[5] > '9' || proto[7] < '0' || proto[7] > '9' {
+		return badRequest("malformed HTTP version %q", proto)
+	}
+	if proto[5] != '1' {
+		return &ProtocolError{505, "unsupported HTTP version " + proto}
+	}
+	return nil
+}
+
+// parseTarget validates the request-target in req.URL, RFC 9112
+// section 3.2. An absolute-form target such as "http://host/path" is
+// reduced to its origin form, and its authority returned to be used in
+// place of the "Host" header. The authority-form is only allowed for
+// CONNECT, and the asterisk-form for OPTIONS.
+func (req *Request) parseTarget() (host string, err error) {
+	target := req.URL
+	if target == "" {
+		return "", badRequest("empty request target")
+	}
+	for i := 0; i < len(target); i++ {
+		if c := target[i]; c <= ' ' || c >= 0x7f || c == '#' {
+			return "", badRequest("invalid character in request target %q", target)
+		}
+	}
+	switch {
+	case target[0] == '/': // origin-form
+		return "", nil
+	case target == "*": // asterisk-form
+		if req.Method != "OPTIONS" {
+			return "", badRequest("asterisk-form target for %v", req.Method)
+		}
+		return "", nil
+	case req.Method == "CONNECT": // authority-form
+		if name, port := splitHostPort(target); name == "" || port == "" || !ValidHost(target) {
+			return "", badRequest("invalid authority-form target %q", target)
+		}
+		return target, nil
+	}
+	// absolute-form
+	i := strings.Index(target, "://")
+	if i == -1 {
+		return "", badRequest("invalid request target %q", target)
+	}
+	scheme := strings.ToLower(target[:i])
+	if scheme != "http" && scheme != "https" {
+		return "", badRequest("unsupported scheme in request target %q", target)
+	}
+	rest := target[i+3:]
+	path := ""
+	if j := strings.IndexAny(rest, "/?"); j != -1 {
+		rest, path = rest[:j], rest[j:]
+	}
+	if !ValidHost(rest) {
+		return "", badRequest("invalid authority in request target %q", target)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req.URL = path
+	return rest, nil
+}
+
+// connectionTokens returns the names listed in v, the value of a
+// "Connection" header, which are hop-by-hop fields, RFC 9110 section 7.6.1.
+func connectionTokens(v string) []string {
+	var tokens []string
+	for _, t := range strings.Split(v, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// hasToken reports whether the comma-separated list v, such as the value
+// of a "Connection" header, contains token, compared case-insensitively.
+func hasToken(v, token string) bool {
+	for _, t := range strings.Split(v, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHeaderLine splits a field line into its canonical name and its
+// value, checking both against the grammar of RFC 9110.
+func parseHeaderLine(line string) (key, value string, err error) {
+	if line[0] == ' ' || line[0] == '\t' { // obs-fold 已经废弃
+		return "", "", badRequest("obsolete line folding in header")
+	}
+	n := strings.Index(line, ":") //分离键和值
+	if n == -1 {
+		return "", "", badRequest("malformed header line %q", line)
+	}
+	if !isToken(line[:n]) { // 键不能为空 冒号前也不能有空格
+		return "", "", badRequest("invalid header field name %q", line[:n])
+	}
+	key = CanonicalHeaderKey(line[:n])
+	value = strings.Trim(line[n+1:], " \t") //去除值的前后多余空格
+	if !validFieldValue(value) {
+		return "", "", badRequest("invalid value of header %v", key)
+	}
+	return key, value, nil
+}
@@ -0,0 +1,133 @@
+package tritonhttp
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// IndexFile is the file served for a request naming a directory.
+const IndexFile = "index.html"
+
+var (
+	// ErrBadPath is returned for a request-target that is not a valid
+	// path, e.g. one containing NUL or an encoded "/". It maps to 400.
+	ErrBadPath = errors.New("tritonhttp: malformed request path")
+
+	// ErrForbidden is returned for a path that exists but must not be
+	// served, e.g. a symlink leading out of the doc root. It maps to 403.
+	ErrForbidden = errors.New("tritonhttp: forbidden path")
+
+	// ErrNotFound is returned for a path naming no file. It maps to 404.
+	ErrNotFound = errors.New("tritonhttp: file not found")
+)
+
+// errMissingSlash is returned for a directory requested without the
+// trailing slash, which gets redirected.
+var errMissingSlash = errors.New("tritonhttp: directory path without trailing slash")
+
+// A PathError records a request-target that could not be resolved.
+// Err is ErrBadPath, ErrForbidden or ErrNotFound.
+type PathError struct {
+	Target string
+	Err    error
+}
+
+func (e *PathError) Error() string {
+	return e.Err.Error() + ": " + strconv.Quote(e.Target)
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// pathErrorStatus returns the status code answering a request whose
+// path failed to resolve with err.
+func pathErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errMissingSlash):
+		return 301
+	case errors.Is(err, ErrBadPath):
+		return 400
+	case errors.Is(err, ErrForbidden):
+		return 403
+	}
+	return 404
+}
+
+// CleanPath returns the path of the request-target, without the query,
+// percent-decoded and cleaned of "." and ".." segments. A trailing slash
+// is kept. Since the result is rooted, ".." can never climb above "/".
+//
+// Targets that do not start with "/", that contain NUL or a backslash,
+// or that encode a separator as "%2F" or "%5C", fail with ErrBadPath.
+func CleanPath(target string) (string, error) {
+	p := target
+	if i := strings.IndexByte(p, '?'); i != -1 {
+		p = p[:i]
+	}
+	if !strings.HasPrefix(p, "/") {
+		return "", &PathError{target, ErrBadPath}
+	}
+	lower := strings.ToLower(p)
+	if strings.Contains(lower, "%2f") || strings.Contains(lower, "%5c") {
+		return "", &PathError{target, ErrBadPath}
+	}
+	p, err := url.PathUnescape(p)
+	if err != nil || strings.ContainsAny(p, "\x00\\") {
+		return "", &PathError{target, ErrBadPath}
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned, nil
+}
+
+// ResolvePath maps the request-target to a file or directory below
+// docRoot and returns its local path along with its FileInfo.
+// If confineSymlinks is set, paths whose symlinks lead out of docRoot
+// fail with ErrForbidden. A path ending in "/" must name a directory.
+func ResolvePath(docRoot, target string, confineSymlinks bool) (string, os.FileInfo, error) {
+	urlPath, err := CleanPath(target)
+	if err != nil {
+		return "", nil, err
+	}
+	filePath := filepath.Join(docRoot, filepath.FromSlash(urlPath))
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		if os.IsPermission(err) {
+			return "", nil, &PathError{target, ErrForbidden}
+		}
+		return "", nil, &PathError{target, ErrNotFound}
+	}
+	if strings.HasSuffix(urlPath, "/") && !fileInfo.IsDir() {
+		return "", nil, &PathError{target, ErrNotFound}
+	}
+	if confineSymlinks && !withinRoot(docRoot, filePath) {
+		return "", nil, &PathError{target, ErrForbidden}
+	}
+	return filePath, fileInfo, nil
+}
+
+// withinRoot reports whether filePath still lies below docRoot
+// once all symlinks are followed.
+func withinRoot(docRoot, filePath string) bool {
+	root, err := filepath.EvalSymlinks(docRoot)
+	if err != nil {
+		return false
+	}
+	real, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, real)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
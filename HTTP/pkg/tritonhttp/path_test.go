@@ -0,0 +1,113 @@
+package tritonhttp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCleanPath(t *testing.T) {
+	var tests = []struct {
+		target  string
+		want    string
+		wantErr error
+	}{
+		{"/", "/", nil},
+		{"/index.html?v=1", "/index.html", nil},
+		{"/a..b.txt", "/a..b.txt", nil},
+		{"/subdir/", "/subdir/", nil},
+		{"/subdir/../index.html", "/index.html", nil},
+		{"/../../etc/passwd", "/etc/passwd", nil},
+		{"/%2e%2e/%2e%2e/etc/passwd", "/etc/passwd", nil},
+		{"/hello%20world.txt", "/hello world.txt", nil},
+		{"/a/./b//c/", "/a/b/c/", nil},
+		{"index.html", "", ErrBadPath},
+		{"E:/files/index.html", "", ErrBadPath},
+		{"/..%2fetc/passwd", "", ErrBadPath},
+		{"/..%5Cetc", "", ErrBadPath},
+		{"/a\\b", "", ErrBadPath},
+		{"/a%00.html", "", ErrBadPath},
+		{"/a%zz", "", ErrBadPath},
+	}
+	for _, tt := range tests {
+		got, err := CleanPath(tt.target)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("CleanPath(%q) got: %q, %v, want: %q, %v", tt.target, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	for name, content := range map[string]string{
+		"root/a..b.txt":     "dots",
+		"root/dir/file.txt": "file",
+		"secret.txt":        "secret",
+	} {
+		p := filepath.Join(base, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(base, "secret.txt"), filepath.Join(root, "escape.txt")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	os.Symlink(filepath.Join(root, "dir"), filepath.Join(root, "inside"))
+
+	var tests = []struct {
+		target          string
+		confineSymlinks bool
+		want            string // relative to root
+		wantErr         error
+	}{
+		{"/a..b.txt", true, "a..b.txt", nil},
+		{"/dir/file.txt", true, "dir/file.txt", nil},
+		{"/dir/", true, "dir", nil},
+		{"/dir/file.txt/", true, "", ErrNotFound},
+		{"/missing.txt", true, "", ErrNotFound},
+		{"/../secret.txt", true, "", ErrNotFound},
+		{"/%2e%2e/secret.txt", true, "", ErrNotFound},
+		{"/escape.txt", false, "escape.txt", nil},
+		{"/escape.txt", true, "", ErrForbidden},
+		{"/inside/file.txt", true, "inside/file.txt", nil},
+	}
+	for _, tt := range tests {
+		got, _, err := ResolvePath(root, tt.target, tt.confineSymlinks)
+		want := ""
+		if tt.want != "" {
+			want = filepath.Join(root, tt.want)
+		}
+		if got != want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("ResolvePath(%q, %v) got: %q, %v, want: %q, %v", tt.target, tt.confineSymlinks, got, err, want, tt.wantErr)
+		}
+	}
+}
+
+func TestFileHandlerPathErrors(t *testing.T) {
+	s := &Server{DocRoot: "testdata", ConfineSymlinks: true}
+	var tests = []struct {
+		url      string
+		wantLine string
+	}{
+		{"/subdir/../index.html", "HTTP/1.1 200 OK"},
+		{"/%69ndex.html", "HTTP/1.1 200 OK"},
+		{"/index.html?v=2", "HTTP/1.1 200 OK"},
+		{"/..%2findex.html", "HTTP/1.1 400 Bad Request"},
+		{"/index.html%00.txt", "HTTP/1.1 400 Bad Request"},
+		{"/index.html/", "HTTP/1.1 404 Not Found"},
+		{"/subdir?x=1", "HTTP/1.1 301 Moved Permanently"},
+	}
+	for _, tt := range tests {
+		got := roundTrip(t, s, "GET "+tt.url+" HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+		if !strings.HasPrefix(got, tt.wantLine+"\r\n") {
+			t.Errorf("GET %v got response: %q, want status line %q", tt.url, got, tt.wantLine)
+		}
+	}
+	if got := roundTrip(t, s, "GET /subdir?x=1 HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"); !strings.Contains(got, "\r\nLocation: subdir/?x=1\r\n") {
+		t.Errorf("got response: %q, want the query kept in Location", got)
+	}
+}
@@ -0,0 +1,462 @@
+package tritonhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxFails is used when ReverseProxy.MaxFails is zero.
+	DefaultMaxFails = 3
+
+	// DefaultFailTimeout is used when ReverseProxy.FailTimeout is zero.
+	DefaultFailTimeout = 10 * time.Second
+
+	// DefaultProxyTimeout is used when ReverseProxy.Timeout is zero.
+	DefaultProxyTimeout = 30 * time.Second
+)
+
+// Balancing selects how a ReverseProxy spreads requests across its
+// upstreams.
+type Balancing int
+
+const (
+	// RoundRobin sends requests to each upstream in turn.
+	RoundRobin Balancing = iota
+
+	// LeastConn sends a request to the upstream with the fewest
+	// requests in flight.
+	LeastConn
+)
+
+// ParseBalancing returns the Balancing named "round_robin" or "least_conn".
+func ParseBalancing(name string) (Balancing, error) {
+	switch strings.ToLower(name) {
+	case "round_robin", "":
+		return RoundRobin, nil
+	case "least_conn":
+		return LeastConn, nil
+	}
+	return 0, fmt.Errorf("unknown load balancing %q", name)
+}
+
+// hopHeaders are the hop-by-hop headers, RFC 9110 section 7.6.1, which
+// only concern one connection and are not forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// A ReverseProxy is a Handler forwarding requests to upstream servers
+// and streaming their responses back to the client. Requests are
+// spread across the upstreams according to Balancing.
+//
+// Upstreams are health-checked passively: one failing MaxFails times in
+// a row, by refusing connections, timing out or breaking the connection
+// in the middle of a response, is left out for FailTimeout. A request
+// without a body that could not reach an upstream is tried on the next
+// one. While every upstream is left out, requests are sent to all of
+// them again.
+//
+// Use NewReverseProxy to create one.
+type ReverseProxy struct {
+	// Balancing selects the upstream of each request.
+	Balancing Balancing
+
+	// MaxFails is how many consecutive failures leave an upstream out.
+	// If zero, DefaultMaxFails is used.
+	MaxFails int
+
+	// FailTimeout is how long an upstream is left out.
+	// If zero, DefaultFailTimeout is used.
+	FailTimeout time.Duration
+
+	// Timeout limits the time to connect to an upstream, send it the
+	// request and receive the headers of its response. The body of the
+	// response is streamed without a time limit. If zero,
+	// DefaultProxyTimeout is used.
+	Timeout time.Duration
+
+	// PreserveHost forwards the "Host" header of the client instead of
+	// the address of the upstream.
+	PreserveHost bool
+
+	// ErrorLog receives the failures of upstreams. If nil, they are
+	// logged to the default error log.
+	ErrorLog *ErrorLogger
+
+	client   Client // pool of upstream connections
+	backends []*backend
+	next     uint64 // round-robin counter
+
+	mu sync.Mutex // protects the health of backends
+}
+
+// backend is an upstream of a ReverseProxy.
+type backend struct {
+	url       *url.URL
+	key, addr string
+	active    int64 // requests in flight, updated atomically
+
+	fails     int       // consecutive failures
+	downUntil time.Time // end of the ejection
+}
+
+// NewReverseProxy returns a ReverseProxy forwarding requests to the
+// upstreams, given as "host:port" or as "http://" or "https://" URLs.
+// The path of an upstream URL is prepended to the request paths.
+func NewReverseProxy(upstreams ...string) (*ReverseProxy, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.New("tritonhttp: reverse proxy without upstreams")
+	}
+	p := &ReverseProxy{}
+	for _, upstream := range upstreams {
+		raw := strings.TrimSpace(upstream)
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("tritonhttp: upstream %q: %v", upstream, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || !ValidHost(u.Host) || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("tritonhttp: invalid upstream %q", upstream)
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		u.RawPath = ""
+		key, addr := connKey(u)
+		p.backends = append(p.backends, &backend{url: u, key: key, addr: addr})
+	}
+	return p, nil
+}
+
+// ServeHTTP forwards req to an upstream and copies its response to w.
+// When no upstream answers, the client gets 502 Bad Gateway, or 504
+// Gateway Timeout if the last one timed out.
+func (p *ReverseProxy) ServeHTTP(w ResponseWriter, req *Request) {
+	tried := make(map[*backend]bool)
+	var err error
+	for {
+		b := p.pick(tried)
+		if b == nil {
+			break
+		}
+		tried[b] = true
+		var sent bool
+		sent, err = p.forward(w, req, b)
+		if err == nil {
+			return
+		}
+		p.errorLog().Warnf("proxy %v %v to %v: %v", req.Method, req.URL, b.url.Host, err)
+		if sent {
+			return
+		}
+		if req.Body != nil || !isIdempotent(req.Method) {
+			if _, ok := err.(dialError); !ok {
+				break
+			}
+		}
+	}
+	res := &Response{}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		res.HandleGatewayTimeout(req)
+	} else {
+		res.HandleBadGateway(req)
+	}
+	res.Serve(w)
+}
+
+// dialError wraps a failure to connect to an upstream, after which the
+// request can be sent to another one whatever its method.
+type dialError struct{ err error }
+
+func (e dialError) Error() string { return e.err.Error() }
+func (e dialError) Unwrap() error { return e.err }
+
+// forward sends req to b and streams the response to w. sent reports
+// whether the response headers have been passed to w, after which an
+// error can only be handled by breaking the connection.
+func (p *ReverseProxy) forward(w ResponseWriter, req *Request, b *backend) (sent bool, err error) {
+	atomic.AddInt64(&b.active, 1)
+	defer atomic.AddInt64(&b.active, -1)
+
+	out := p.outgoingRequest(req, b)
+	var cc *clientConn
+	var res *Response
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+		cc, err = p.client.getConn(ctx, b.key, b.url.Scheme, b.addr)
+		cancel()
+		if err != nil {
+			p.fail(b)
+			return false, dialError{err}
+		}
+		cc.conn.SetDeadline(time.Now().Add(p.timeout()))
+		if err = out.Write(cc.bw); err == nil {
+			if err = cc.bw.Flush(); err == nil {
+				res, err = readResponseHeader(cc.br)
+			}
+		}
+		if err == nil {
+			break
+		}
+		cc.conn.Close()
+		// 复用的连接可能已经被上游关闭 没有body的请求换一个新连接重试
+		if !cc.reused || out.Body != nil || !isIdempotent(out.Method) {
+			p.fail(b)
+			return false, err
+		}
+	}
+	cc.conn.SetDeadline(time.Time{})
+	res.Request = out
+	body, keepAlive, err := responseBody(cc.br, res)
+	if err != nil || res.StatusCode == 101 {
+		cc.conn.Close()
+		p.fail(b)
+		if err == nil {
+			err = errors.New("unexpected 101 Switching Protocols")
+		}
+		return false, err
+	}
+	p.succeed(b)
+
+	// 去掉逐跳头部 以及上游在Connection中列出的头部
+	header := w.Header()
+	for _, token := range connectionTokens(res.Header.Join("Connection")) {
+		res.Header.Del(token)
+	}
+	for _, k := range hopHeaders {
+		res.Header.Del(k)
+	}
+	for k, v := range res.Header {
+		header[k] = v
+	}
+	if _, ok := body.(*contentLengthReader); body != nil && !ok {
+		header.Del("Content-Length")
+	}
+	w.WriteHeader(res.StatusCode)
+	if body == nil {
+		if keepAlive {
+			p.client.putConn(cc)
+		} else {
+			cc.conn.Close()
+		}
+		return true, nil
+	}
+
+	flusher, _ := w.(Flusher)
+	buf := make([]byte, 32<<10)
+	for {
+		n, rerr := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				// 客户端断开 上游连接上还有未读的body
+				cc.conn.Close()
+				return true, nil
+			}
+			// 上游暂时没有更多数据时立即发给客户端
+			if flusher != nil && cc.br.Buffered() == 0 {
+				flusher.Flush()
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			cc.conn.Close()
+			p.fail(b)
+			return true, rerr
+		}
+	}
+	if keepAlive {
+		p.client.putConn(cc)
+	} else {
+		cc.conn.Close()
+	}
+	return true, nil
+}
+
+// outgoingRequest returns the request sent to b in place of req.
+func (p *ReverseProxy) outgoingRequest(req *Request, b *backend) *Request {
+	header := req.Header.Clone()
+	if header == nil {
+		header = make(Header)
+	}
+	// 客户端在Connection中列出的头部同样是逐跳的
+	for _, token := range req.ConnectionTokens {
+		header.Del(token)
+	}
+	for _, k := range hopHeaders {
+		header.Del(k)
+	}
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	clientIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	if clientIP != "" {
+		if prior := header.Join("X-Forwarded-For"); prior != "" {
+			header.Set("X-Forwarded-For", prior+", "+clientIP)
+		} else {
+			header.Set("X-Forwarded-For", clientIP)
+		}
+		// RFC 7239: IPv6地址要加方括号和引号
+		node := clientIP
+		if strings.Contains(node, ":") {
+			node = "[" + node + "]"
+		}
+		forwarded := "for=" + forwardedValue(node)
+		if req.Host != "" {
+			forwarded += ";host=" + forwardedValue(req.Host)
+		}
+		forwarded += ";proto=" + proto
+		if prior := header.Join("Forwarded"); prior != "" {
+			forwarded = prior + ", " + forwarded
+		}
+		header.Set("Forwarded", forwarded)
+	}
+	if req.Host != "" {
+		header.Set("X-Forwarded-Host", req.Host)
+	}
+	header.Set("X-Forwarded-Proto", proto)
+
+	host := b.url.Host
+	if p.PreserveHost && req.Host != "" {
+		host = req.Host
+	}
+	target := req.URL
+	if target != "*" {
+		target = b.url.Path + target
+	}
+	return &Request{
+		Method:        req.Method,
+		URL:           target,
+		Proto:         "HTTP/1.1",
+		Header:        header,
+		Host:          host,
+		ContentLength: req.ContentLength,
+		Body:          req.Body,
+	}
+}
+
+// forwardedValue returns v as a value of the "Forwarded" header,
+// quoted unless it is a token.
+func forwardedValue(v string) string {
+	if isToken(v) {
+		return v
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
+
+// pick returns the next upstream not yet tried for a request, or nil
+// if all of them have been tried.
+func (p *ReverseProxy) pick(tried map[*backend]bool) *backend {
+	now := time.Now()
+	var healthy, untried []*backend
+	p.mu.Lock()
+	for _, b := range p.backends {
+		if tried[b] {
+			continue
+		}
+		untried = append(untried, b)
+		if !now.Before(b.downUntil) {
+			healthy = append(healthy, b)
+		}
+	}
+	p.mu.Unlock()
+	candidates := healthy
+	if len(candidates) == 0 {
+		candidates = untried
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	start := int(atomic.AddUint64(&p.next, 1) % uint64(len(candidates)))
+	if p.Balancing != LeastConn {
+		return candidates[start]
+	}
+	// 连接数相同时轮流选择
+	var best *backend
+	for i := range candidates {
+		b := candidates[(start+i)%len(candidates)]
+		if best == nil || atomic.LoadInt64(&b.active) < atomic.LoadInt64(&best.active) {
+			best = b
+		}
+	}
+	return best
+}
+
+// fail records a failure of b, leaving it out for FailTimeout after
+// MaxFails consecutive ones.
+func (p *ReverseProxy) fail(b *backend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b.fails++
+	if b.fails >= p.maxFails() {
+		b.fails = 0
+		b.downUntil = time.Now().Add(p.failTimeout())
+		p.errorLog().Warnf("proxy: upstream %v is down for %v", b.url.Host, p.failTimeout())
+	}
+}
+
+// succeed records a response from b.
+func (p *ReverseProxy) succeed(b *backend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b.fails = 0
+	b.downUntil = time.Time{}
+}
+
+// CloseIdleConnections closes the idle connections to the upstreams.
+func (p *ReverseProxy) CloseIdleConnections() {
+	p.client.CloseIdleConnections()
+}
+
+func (p *ReverseProxy) maxFails() int {
+	if p.MaxFails > 0 {
+		return p.MaxFails
+	}
+	return DefaultMaxFails
+}
+
+func (p *ReverseProxy) failTimeout() time.Duration {
+	if p.FailTimeout > 0 {
+		return p.FailTimeout
+	}
+	return DefaultFailTimeout
+}
+
+func (p *ReverseProxy) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return DefaultProxyTimeout
+}
+
+func (p *ReverseProxy) errorLog() *ErrorLogger {
+	if p.ErrorLog != nil {
+		return p.ErrorLog
+	}
+	return defaultErrorLog
+}
@@ -0,0 +1,195 @@
+package tritonhttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startUpstream starts a server answering with its name followed by
+// the request line and the headers it received.
+func startUpstream(t *testing.T, name string) (string, *Server) {
+	t.Helper()
+	mux := NewServeMux()
+	mux.HandleFunc("/", func(w ResponseWriter, req *Request) {
+		fmt.Fprintf(w, "%v %v %v\nHost: %v\n", name, req.Method, req.URL, req.Host)
+		for _, k := range []string{"X-Forwarded-For", "Forwarded", "X-Forwarded-Proto", "Te", "Upgrade", "Proxy-Authorization", "Accept", "X-Secret"} {
+			if req.Header.Has(k) {
+				fmt.Fprintf(w, "%v: %v\n", k, req.Header.Join(k))
+			}
+		}
+	})
+	mux.HandleFunc("/echo", func(w ResponseWriter, req *Request) {
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Upstream", name)
+		io.Copy(w, req.Body)
+	})
+	mux.HandleFunc("/private", func(w ResponseWriter, req *Request) {
+		w.Header().Set("Connection", "X-Private")
+		w.Header().Set("X-Private", "1")
+	})
+	mux.HandleFunc("/large", func(w ResponseWriter, req *Request) {
+		io.WriteString(w, strings.Repeat(name, bufferBeforeChunking+1))
+	})
+	s := &Server{Handler: mux}
+	addr, _ := startServer(t, s)
+	return addr, s
+}
+
+func TestReverseProxy(t *testing.T) {
+	addrA, a := startUpstream(t, "a")
+	defer a.Close()
+	addrB, b := startUpstream(t, "b")
+	defer b.Close()
+
+	p, err := NewReverseProxy(addrA, "http://"+addrB+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.CloseIdleConnections()
+	front := &Server{Handler: p}
+	frontAddr, _ := startServer(t, front)
+	defer front.Close()
+	c := &Client{}
+	defer c.CloseIdleConnections()
+
+	// Round-robin
+	var names []string
+	for i := 0; i < 4; i++ {
+		req, _ := NewRequest("GET", "http://"+frontAddr+"/path?q=1", nil)
+		req.Header.Set("Accept", "text/plain")
+		req.Header.Set("Te", "trailers")
+		req.Header.Set("Proxy-Authorization", "secret")
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		res, err := c.Do(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := string(res.Body)
+		names = append(names, body[:1])
+		upstream := addrA
+		if body[:1] == "b" {
+			upstream = addrB
+		}
+		want := "GET /path?q=1\nHost: " + upstream + "\n" +
+			"X-Forwarded-For: 10.0.0.1, 127.0.0.1\n" +
+			"Forwarded: for=127.0.0.1;host=\"" + frontAddr + "\";proto=http\n" +
+			"X-Forwarded-Proto: http\n" +
+			"Accept: text/plain\n"
+		if body[2:] != want {
+			t.Fatalf("got: %q, want: %q", body[2:], want)
+		}
+	}
+	if got := strings.Join(names, ""); got != "abab" && got != "baba" {
+		t.Fatalf("got upstreams: %v, want them in turn", got)
+	}
+
+	// Fields named in "Connection" are not forwarded, either way
+	got := roundTrip(t, front, "GET / HTTP/1.1\r\nHost: test\r\nConnection: close, X-Secret\r\nX-Secret: 1\r\n\r\n")
+	if !strings.HasPrefix(got, "HTTP/1.1 200 OK\r\n") || strings.Contains(got, "X-Secret") {
+		t.Fatalf("got: %q", got)
+	}
+	got = roundTrip(t, front, "GET /private HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	if !strings.HasPrefix(got, "HTTP/1.1 200 OK\r\n") || strings.Contains(got, "X-Private") {
+		t.Fatalf("got: %q", got)
+	}
+
+	// Bodies are streamed both ways
+	payload := strings.Repeat("0123456789", 1000)
+	req, _ := NewRequest("POST", "http://"+frontAddr+"/echo", io.MultiReader(strings.NewReader(payload)))
+	res, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Body) != payload || res.Header.Has("Keep-Alive") || !res.Header.Has("X-Upstream") {
+		t.Fatalf("got echo: %v %v bytes", res.Header, len(res.Body))
+	}
+	res, err = c.Get(context.Background(), "http://"+frontAddr+"/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Body) != bufferBeforeChunking+1 || res.Header.Get("Transfer-Encoding") != "chunked" {
+		t.Fatalf("got large response: %v %v bytes", res.Header, len(res.Body))
+	}
+	req, _ = NewRequest("HEAD", "http://"+frontAddr+"/large", nil)
+	res, err = c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 || len(res.Body) != 0 {
+		t.Fatalf("got HEAD response: %v %q", res.StatusCode, res.Body)
+	}
+}
+
+func TestReverseProxyHealth(t *testing.T) {
+	addr, up := startUpstream(t, "a")
+	defer up.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := l.Addr().String()
+	l.Close()
+
+	p, err := NewReverseProxy(down, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.MaxFails = 2
+	defer p.CloseIdleConnections()
+
+	// Requests failing on the dead upstream are retried on the other
+	for i := 0; i < 4; i++ {
+		got := roundTrip(t, &Server{Handler: p}, "POST /echo HTTP/1.1\r\nHost: test\r\nConnection: close\r\nContent-Length: 2\r\n\r\nok")
+		if !strings.HasPrefix(got, "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(got, "\r\n\r\nok") {
+			t.Fatalf("got: %q", got)
+		}
+	}
+	if dead := p.backends[0]; time.Until(dead.downUntil) <= 0 {
+		t.Fatalf("dead upstream not left out")
+	}
+	if live := p.backends[1]; live.fails != 0 || !live.downUntil.IsZero() {
+		t.Fatalf("live upstream got %v failures", live.fails)
+	}
+
+	// Without any upstream left, the client gets 502
+	up.Close()
+	p.CloseIdleConnections()
+	got := roundTrip(t, &Server{Handler: p}, "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	if !strings.HasPrefix(got, "HTTP/1.1 502 Bad Gateway\r\n") || !strings.HasSuffix(got, "\r\n\r\n502 Bad Gateway\n") {
+		t.Fatalf("got: %q", got)
+	}
+}
+
+func TestReverseProxyLeastConn(t *testing.T) {
+	p, err := NewReverseProxy("a:80", "b:80", "c:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Balancing = LeastConn
+	p.backends[0].active = 2
+	p.backends[1].active = 1
+	p.backends[2].active = 3
+	for i := 0; i < 3; i++ {
+		if b := p.pick(nil); b != p.backends[1] {
+			t.Fatalf("picked %v, want b", b.url.Host)
+		}
+	}
+	p.backends[1].downUntil = time.Now().Add(time.Minute)
+	if b := p.pick(nil); b != p.backends[0] {
+		t.Fatalf("picked %v, want a", b.url.Host)
+	}
+	if b := p.pick(map[*backend]bool{p.backends[0]: true, p.backends[2]: true}); b != p.backends[1] {
+		t.Fatalf("picked %v, want the ejected b as the last resort", b.url.Host)
+	}
+
+	for _, upstream := range []string{"", "ftp://a", "http://a b", "http://a/?q"} {
+		if _, err := NewReverseProxy(upstream); err == nil {
+			t.Errorf("NewReverseProxy(%q) got no error", upstream)
+		}
+	}
+}
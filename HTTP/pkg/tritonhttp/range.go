@@ -0,0 +1,205 @@
+package tritonhttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errNoOverlap is returned by parseRange when none of the
+// requested ranges overlaps the content.
+var errNoOverlap = errors.New("invalid range: failed to overlap")
+
+// byteRange is a satisfiable range of the content, in bytes.
+type byteRange struct {
+	start, length int64
+}
+
+// contentRange formats r for the "Content-Range" header.
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a "Range" header such as "bytes=0-499, -500"
+// against content of the given size. Ranges which start past the end
+// of the content are dropped; if none is left, errNoOverlap is returned.
+// Any other error means the header is invalid and should be ignored.
+func parseRange(s string, size int64) ([]byteRange, error) {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errors.New("invalid range unit")
+	}
+	var ranges []byteRange
+	noOverlap := false
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		i := strings.Index(ra, "-")
+		if i < 0 {
+			return nil, errors.New("invalid range")
+		}
+		start, end := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
+		var r byteRange
+		if start == "" {
+			// Suffix range "-N" selects the last N bytes
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if n == 0 {
+				noOverlap = true
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r.start = size - n
+			r.length = n
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if i >= size {
+				noOverlap = true
+				continue
+			}
+			r.start = i
+			if end == "" {
+				r.length = size - r.start
+			} else {
+				j, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || j < r.start {
+					return nil, errors.New("invalid range")
+				}
+				if j >= size {
+					j = size - 1
+				}
+				r.length = j - r.start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if noOverlap && len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+	return ranges, nil
+}
+
+// checkIfRange reports whether the "If-Range" header of req, if any,
+// still matches the file described by res, in which case the "Range"
+// header can be honored. A validator is either an entity tag, compared
+// with the strong comparison, or a date compared with "Last-Modified".
+func checkIfRange(req *Request, res *Response) bool {
+	if !req.Header.Has("If-Range") {
+		return true
+	}
+	ir := strings.TrimSpace(req.Header.Get("If-Range"))
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		etag := res.Header.Get("Etag")
+		return etag != "" && strongMatch(ir, etag)
+	}
+	t, err := ParseTime(ir)
+	if err != nil {
+		return false
+	}
+	return FormatTime(t) == res.Header.Get("Last-Modified")
+}
+
+// serveRange answers a GET or HEAD with a "Range" header for the file
+// prepared in res, with 206 Partial Content or 416 Range Not Satisfiable.
+// It reports false if the request should get the whole file instead.
+func serveRange(w ResponseWriter, req *Request, res *Response) bool {
+	rangeHeader := req.Header.Join("Range")
+	if !req.Header.Has("Range") || !checkIfRange(req, res) {
+		return false
+	}
+	size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return false
+	}
+	ranges, err := parseRange(rangeHeader, size)
+	if err == errNoOverlap {
+		h := w.Header()
+		for _, k := range []string{"Date", "Last-Modified", "Accept-Ranges", "Connection"} {
+			if v, ok := res.Header[k]; ok {
+				h[k] = v
+			}
+		}
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(416)
+		return true
+	}
+	if err != nil || len(ranges) == 0 {
+		return false
+	}
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	if total > size {
+		// Overlapping ranges would cost more than the file itself
+		return false
+	}
+
+	file, err := os.Open(res.FilePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	h := w.Header()
+	for k, v := range res.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	if len(ranges) == 1 {
+		r := ranges[0]
+		h.Set("Content-Range", r.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(r.length, 10))
+		w.WriteHeader(206)
+		io.Copy(w, io.NewSectionReader(file, r.start, r.length))
+		return true
+	}
+
+	// Multiple ranges are sent as multipart/byteranges
+	boundary := randomBoundary()
+	contentType := res.Header.Get("Content-Type")
+	partHeaders := make([]string, len(ranges))
+	length := int64(0)
+	for i, r := range ranges {
+		partHeaders[i] = "--" + boundary + CRLF +
+			"Content-Type: " + contentType + CRLF +
+			"Content-Range: " + r.contentRange(size) + CRLF + CRLF
+		length += int64(len(partHeaders[i])) + r.length + int64(len(CRLF))
+	}
+	closing := "--" + boundary + "--" + CRLF
+	length += int64(len(closing))
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(206)
+	for i, r := range ranges {
+		io.WriteString(w, partHeaders[i])
+		if _, err := io.Copy(w, io.NewSectionReader(file, r.start, r.length)); err != nil {
+			return true
+		}
+		io.WriteString(w, CRLF)
+	}
+	io.WriteString(w, closing)
+	return true
+}
+
+// randomBoundary returns a random multipart boundary.
+func randomBoundary() string {
+	var buf [15]byte
+	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf[:])
+}
@@ -0,0 +1,146 @@
+package tritonhttp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	var tests = []struct {
+		header  string
+		want    []byteRange
+		wantErr error
+		invalid bool
+	}{
+		{"bytes=0-4", []byteRange{{0, 5}}, nil, false},
+		{"bytes=6-", []byteRange{{6, 6}}, nil, false},
+		{"bytes=-3", []byteRange{{9, 3}}, nil, false},
+		{"bytes=-100", []byteRange{{0, 12}}, nil, false},
+		{"bytes=5-100", []byteRange{{5, 7}}, nil, false},
+		{"bytes=0-0, 2-3", []byteRange{{0, 1}, {2, 2}}, nil, false},
+		{"bytes=0-1, 50-60", []byteRange{{0, 2}}, nil, false},
+		{"bytes=12-", nil, errNoOverlap, false},
+		{"bytes=-0", nil, errNoOverlap, false},
+		{"bytes=5-4", nil, nil, true},
+		{"bytes=a-b", nil, nil, true},
+		{"lines=0-4", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := parseRange(tt.header, 12)
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("got ranges: %v, want: error", got)
+				}
+				return
+			}
+			if err != tt.wantErr {
+				t.Fatalf("got error: %v, want: %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got ranges: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeRange(t *testing.T) {
+	f := FileServer("testdata")
+	lastModified := f.Respond(&Request{Method: "GET", URL: "/index.html", Header: Header{}}).Header.Get("Last-Modified")
+
+	var tests = []struct {
+		name     string
+		header   Header
+		wantLine string
+		contains []string
+		bodyWant string
+	}{
+		{
+			"NoRange",
+			Header{},
+			"HTTP/1.1 200 OK",
+			[]string{"Accept-Ranges: bytes\r\n", "Content-Length: 12\r\n"},
+			"Hello World\n",
+		},
+		{
+			"Single",
+			Header{"Range": {"bytes=0-4"}},
+			"HTTP/1.1 206 Partial Content",
+			[]string{"Content-Range: bytes 0-4/12\r\n", "Content-Length: 5\r\n"},
+			"Hello",
+		},
+		{
+			"Suffix",
+			Header{"Range": {"bytes=-6"}},
+			"HTTP/1.1 206 Partial Content",
+			[]string{"Content-Range: bytes 6-11/12\r\n"},
+			"World\n",
+		},
+		{
+			"Multi",
+			Header{"Range": {"bytes=0-4,6-10"}},
+			"HTTP/1.1 206 Partial Content",
+			[]string{
+				"Content-Type: multipart/byteranges; boundary=",
+				"Content-Type: text/html; charset=utf-8\r\nContent-Range: bytes 0-4/12\r\n\r\nHello\r\n",
+				"Content-Range: bytes 6-10/12\r\n\r\nWorld\r\n",
+			},
+			"",
+		},
+		{
+			"NotSatisfiable",
+			Header{"Range": {"bytes=20-"}},
+			"HTTP/1.1 416 Range Not Satisfiable",
+			[]string{"Content-Range: bytes */12\r\n", "Content-Length: 0\r\n"},
+			"",
+		},
+		{
+			"InvalidIgnored",
+			Header{"Range": {"bytes=x-y"}},
+			"HTTP/1.1 200 OK",
+			nil,
+			"Hello World\n",
+		},
+		{
+			"IfRangeMatch",
+			Header{"Range": {"bytes=0-4"}, "If-Range": {lastModified}},
+			"HTTP/1.1 206 Partial Content",
+			nil,
+			"Hello",
+		},
+		{
+			"IfRangeStale",
+			Header{"Range": {"bytes=0-4"}, "If-Range": {"Mon, 02 Jan 2006 15:04:05 GMT"}},
+			"HTTP/1.1 200 OK",
+			nil,
+			"Hello World\n",
+		},
+		{
+			"IfRangeWeakETag",
+			Header{"Range": {"bytes=0-4"}, "If-Range": {`W/"abc"`}},
+			"HTTP/1.1 200 OK",
+			nil,
+			"Hello World\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Method: "GET", URL: "/index.html", Proto: "HTTP/1.1", Header: tt.header}
+			got := writeResponse(t, req, f.ServeHTTP)
+			if !strings.HasPrefix(got, tt.wantLine+"\r\n") {
+				t.Fatalf("got response: %q, want status line %q", got, tt.wantLine)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(got, s) {
+					t.Fatalf("got response: %q, want it to contain %q", got, s)
+				}
+			}
+			if tt.bodyWant != "" && !strings.HasSuffix(got, "\r\n\r\n"+tt.bodyWant) {
+				t.Fatalf("got response: %q, want body %q", got, tt.bodyWant)
+			}
+		})
+	}
+}
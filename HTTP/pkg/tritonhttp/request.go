@@ -2,14 +2,33 @@ package tritonhttp
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
-	"fmt"
-	"os"
+	"io"
 	"strings"
 )
 
+// ErrMethodNotAllowed is returned by ReadRequest when the request is
+// well-formed but uses a method the server does not implement.
+var ErrMethodNotAllowed = errors.New("method not allowed")
+
+// AllowedMethods lists the methods FileHandler is able to handle,
+// in the format used by the "Allow" header.
+const AllowedMethods = "GET, HEAD"
+
+// knownMethods are the methods ReadRequest passes on to handlers.
+var knownMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"PATCH":   true,
+	"OPTIONS": true,
+}
+
 type Request struct {
-	Method string // e.g. "GET"
+	Method string // e.g. "GET" or "HEAD"
 	URL    string // e.g. "/path/to/a/file"
 	Proto  string // e.g. "HTTP/1.1"
 
@@ -17,10 +36,51 @@ type Request struct {
 	// which are stored in special fields below.
 	// Header keys are case-incensitive, and should be stored
 	// in the canonical format in this map.
-	Header map[string]string
+	Header Header
 
 	Host  string // determine from the "Host" header
-	Close bool   // determine from the "Connection" header
+	Close bool   // determine from the "Connection" header and Proto
+
+	// KeepAlive reports whether the client asked for a persistent
+	// connection with "Connection: keep-alive", as HTTP/1.0 clients must.
+	// The response then carries a "Keep-Alive" header.
+	KeepAlive bool
+
+	// Upgrade reports whether the "Connection" header holds the "upgrade"
+	// token, asking to switch to a protocol named in the "Upgrade" header,
+	// such as "websocket".
+	Upgrade bool
+
+	// ConnectionTokens are the options listed in the "Connection"
+	// header, such as "close" or the names of hop-by-hop fields that
+	// a proxy must not forward.
+	ConnectionTokens []string
+
+	// RemoteAddr is the network address of the client, set by the
+	// server. ReadRequest leaves it empty.
+	RemoteAddr string
+
+	// TLS is the state of the TLS connection the request was read
+	// from, or nil for a plaintext connection. It is set by the server.
+	TLS *tls.ConnectionState
+
+	// ContentLength is the length of Body in bytes,
+	// or -1 when the body is sent with chunked encoding.
+	ContentLength int64
+
+	// Body is the request body, bounded by "Content-Length" or decoded
+	// from "Transfer-Encoding: chunked". It is nil when there is no body.
+	// The server discards whatever the handler leaves unread, so that
+	// the next request on the connection can be read.
+	Body io.Reader
+
+	// Trailer holds the trailer fields of a chunked body.
+	// It is only filled in once Body has been read to the end.
+	Trailer Header
+
+	// conn is the connection the request was read from, which a handler
+	// can take over with Hijack. It is set by the server.
+	conn *serverConn
 }
 
 // ReadRequest tries to read the next valid request from br.
@@ -32,127 +92,116 @@ type Request struct {
 // and a nil request. In this case, bytesReceived indicates whether or not
 // some bytes are received before the error occurs. This is useful to determine
 // the timeout with partial request received condition.
+//
+// A request using an unknown method is fully parsed and returned
+// together with ErrMethodNotAllowed, so that the caller can answer
+// it with 405 and keep serving the connection.
+//
+// A request breaking the syntax of RFC 9112 is reported with a
+// *ProtocolError, which tells the status code to answer it with.
+//
+// ReadRequest does not consume the request body; it is read through
+// req.Body, which must be drained before reading the next request.
 func ReadRequest(br *bufio.Reader) (req *Request, bytesReceived bool, err error) {
 	req = &Request{}
-	headers := make(map[string]string, 3)
-	fmt.Println("开始读取请求头")
-	// Read start line
-	startLine,err := ReadLine(br)
-	if err != nil {
-		if err.Error() == "EOF" {//EOF 读完直接跳出循环
+	// Read start line 忽略请求之前的空行
+	var startLine string
+	for empty := 0; startLine == ""; empty++ {
+		if empty > maxLeadingEmptyLines {
+			return nil, true, badRequest("too many empty lines before the request line")
+		}
+		startLine, err = ReadLine(br)
+		if startLine != "" {
 			bytesReceived = true
-		} else if n := strings.Index(err.Error(),"i/o timeout"); n != -1 {//超时错误
-			fmt.Println("i/o timeout:",err.Error())
-			return nil, false, errors.New("i/o timeout")
-		} else {//其他错误
-			fmt.Println("ReadRequest error:",err.Error())
-			return nil, false, err
 		}
+		if err != nil {
+			return nil, bytesReceived, readError(err, bytesReceived)
+		}
+	}
+
+	// Parse start line: method SP request-target SP HTTP-version
+	data := strings.Split(startLine, " ")
+	if len(data) != 3 {
+		return nil, true, badRequest("malformed request line %q", startLine)
+	}
+	req.Method, req.URL, req.Proto = data[0], data[1], data[2]
+	if !isToken(req.Method) {
+		return nil, true, badRequest("invalid method %q", req.Method)
 	}
-	fmt.Println(startLine)
+	if err := checkVersion(req.Proto); err != nil {
+		return nil, true, err
+	}
+	targetHost, err := req.parseTarget()
+	if err != nil {
+		return nil, true, err
+	}
+
 	// Read headers
-	var res string
+	headers := make(Header)
 	for {
-		res,err = ReadLine(br) //当读到请求末尾会返回""
-		if res == "" {//如果读到当前请求的末尾则直接返回
+		line, err := ReadLine(br) //当读到请求末尾会返回""
+		if err != nil {
+			return nil, true, readError(err, true)
+		}
+		if line == "" {//读到请求头的末尾
 			break
 		}
-		fmt.Println("res:"+res)
+		k, v, err := parseHeaderLine(line)
 		if err != nil {
-			if err.Error() == "EOF" {//EOF 表示请求全部读完 直接跳出循环
-				bytesReceived = true
-				break
-			} else if n := strings.Index(err.Error(),"i/o timeout"); n != -1 {//超时错误
-				fmt.Println("i/o timeout:",err.Error())
-				return nil, bytesReceived, errors.New("i/o timeout")
-			} else {//其他错误
-				fmt.Println("ReadRequest error:",err.Error())
-				return nil, bytesReceived, err
-			}
+			return nil, true, err
 		}
-		bytesReceived = true
-		n := strings.Index(res,":")//分离键和值
-		if n==-1 || n==0 {
-			fmt.Println("headers format error:"+res)
-			return nil,bytesReceived,errors.New("headers format error")
+		if old, ok := headers[k]; ok {// 重复的头
+			switch k {
+			case "Host":
+				return nil, true, badRequest("duplicate Host header")
+			case "Content-Length":
+				if old[0] != v {
+					return nil, true, badRequest("conflicting Content-Length headers")
+				}
+				continue
+			}
 		}
-		k1 := res[:n]
-		v1 := res[n+1:]
-		k := strings.TrimSpace(k1)//去除键的前后多余空格
-		v := strings.TrimSpace(v1)//去除值的前后多余空格
-		headers[k] = v
+		headers.Add(k, v)
 	}
 
 	// Check required headers
-	host,ok := headers[CanonicalHeaderKey("host")]
-	if !ok {//if host not exist
-		fmt.Println("key 'host' is not exist")
-		return nil,bytesReceived, errors.New("key 'host' is not exist")
+	host := headers.Get("Host")
+	if host == "" {//if host not exist HTTP/1.0 可以没有Host
+		if req.Proto != "HTTP/1.0" {
+			return nil, true, badRequest("missing Host header")
+		}
+	} else if !ValidHost(host) {
+		return nil, true, badRequest("malformed Host header %q", host)
 	} else {
 		req.Host = host
-		delete(headers,CanonicalHeaderKey("host"))
 	}
-	// Handle special headers
-	data := strings.Split(startLine," ")
-	req.Method = strings.TrimSpace(data[0])
-	if req.Method != "GET" {
-		return nil,true,errors.New("request method erro")
+	if targetHost != "" {// absolute-form 的请求以其中的主机为准
+		req.Host = targetHost
 	}
-	req.URL = strings.TrimSpace(data[1])
-	req.Proto = strings.TrimSpace(data[2])
+	delete(headers, "Host")
+	// Handle special headers
 	req.Header = headers
-	c, ok := headers[CanonicalHeaderKey("connection")]
-	if ok {
-		if c == "close" {req.Close = true} else {req.Close = false}
-		delete(headers,CanonicalHeaderKey("connection"))
+	// HTTP/1.1 默认保持连接 HTTP/1.0 默认关闭 除非带有keep-alive
+	conn := headers.Join("Connection")
+	if req.Proto == "HTTP/1.0" {
+		req.Close = !hasToken(conn, "keep-alive") || hasToken(conn, "close")
+		if headers.Has("Transfer-Encoding") {
+			// RFC 9112 6.1: HTTP/1.0 中的Transfer-Encoding不可信 响应后关闭连接
+			req.Close = true
+		}
 	} else {
-		req.Close = false
+		req.Close = hasToken(conn, "close")
+	}
+	req.KeepAlive = !req.Close && hasToken(conn, "keep-alive")
+	req.Upgrade = hasToken(conn, "upgrade")
+	req.ConnectionTokens = connectionTokens(conn)
+	delete(headers,CanonicalHeaderKey("connection"))
+	if err := req.setBody(br, headers); err != nil {
+		return nil, true, err
+	}
+	if !knownMethods[req.Method] {
+		return req, true, ErrMethodNotAllowed
 	}
 
-	return
+	return req, true, nil
 }
-
-//HandleUrl 对请求req的url进行处理，不符合格式和路径不存在的会返回错误 正确的最终都会被转换为相对路径保存至req.URL中
-func (req *Request) HandleUrl(rootPath string) error {
-	fmt.Println("正在处理请求url:"+req.URL)
-	if req.URL == "/" {// 如果url为"/"则重新设置为"/index.html"
-		fmt.Println(1)
-		filePath := "/index.html"
-		req.URL = filePath
-		return nil
-	} else if n := strings.Index(req.URL,".."); n != -1 {// 如果url包含有 ".." 则返回404
-		//404
-		return errors.New("404")
-	} else if n := strings.Index(req.URL,"/"); n != 0 {// 如果url第一个字符不为 "/"
-		if m := strings.Index(req.URL, rootPath); m != 0 {//如果url不是绝对路径 返回404
-			//400
-			return errors.New("400")
-		} else {//如果是绝对路径
-			relativePath := strings.Split(req.URL,rootPath)[1] //先去除url前面的根路径得到相对路径
-			if n := strings.Index(relativePath,"/"); n != 0 {//如果相对路径不以 ”/“ 开头则返回400
-				return errors.New("400")
-			} else {//相对路径以 "/" 开头
-				if fileInfo,err := os.Stat(rootPath+relativePath); err != nil {
-					if os.IsNotExist(err) || fileInfo.IsDir() {//如果路径不存在 或者 是文件夹 返回404
-						return errors.New("404")
-					} else {//其他错误返回400
-						return errors.New("400")
-					}
-				} else {//将正确的相对路径赋给req.URL
-					req.URL = relativePath
-					return nil
-				}
-			}
-		}
-	} else {//url第一个字符为 "/" 则只需判断文件存不存在就行了 [/sad/as.x]
-		filePath := rootPath + req.URL //拼接绝对路径
-		if fileInfo,err := os.Stat(filePath); err != nil {
-			if os.IsNotExist(err) || fileInfo == nil || fileInfo.IsDir() {//如果路径不存在 或者 文件不存在 或者 是文件夹
-				return errors.New("404")
-			} else {//其他错误返回400
-				return errors.New("400")
-			}
-		}
-		return nil
-	}
-}
\ No newline at end of file
@@ -2,6 +2,8 @@ package tritonhttp
 
 import (
 	"bufio"
+	"errors"
+	"io"
 	"reflect"
 	"strings"
 	"testing"
@@ -37,7 +39,7 @@ func TestReadGoodRequest(t *testing.T) {
 				Method: "GET",
 				URL:    "/index.html",
 				Proto:  "HTTP/1.1",
-				Header: map[string]string{},
+				Header: Header{},
 				Host:   "test",
 				Close:  false,
 			},
@@ -52,9 +54,25 @@ func TestReadGoodRequest(t *testing.T) {
 				Method: "GET",
 				URL:    "/index.html",
 				Proto:  "HTTP/1.1",
-				Header: map[string]string{},
+				Header: Header{},
 				Host:   "test",
 				Close:  true,
+
+				ConnectionTokens: []string{"close"},
+			},
+		},
+		{
+			"Head",
+			"HEAD /index.html HTTP/1.1\r\n" +
+				"Host: test\r\n" +
+				"\r\n",
+			&Request{
+				Method: "HEAD",
+				URL:    "/index.html",
+				Proto:  "HTTP/1.1",
+				Header: Header{},
+				Host:   "test",
+				Close:  false,
 			},
 		},
 		{
@@ -69,12 +87,14 @@ func TestReadGoodRequest(t *testing.T) {
 				Method: "GET",
 				URL:    "/index.html",
 				Proto:  "HTTP/1.1",
-				Header: map[string]string{
-					"Key1": "val1",
-					"Key2": "val2",
+				Header: Header{
+					"Key1": {"val1"},
+					"Key2": {"val2"},
 				},
 				Host:  "test",
 				Close: true,
+
+				ConnectionTokens: []string{"close"},
 			},
 		},
 	}
@@ -110,6 +130,20 @@ func TestReadBadRequest(t *testing.T) {
 	}
 }
 
+func TestReadMethodNotAllowed(t *testing.T) {
+	reqText := "BREW /index.html HTTP/1.1\r\n" +
+		"Host: test\r\n" +
+		"Connection: close\r\n" +
+		"\r\n"
+	reqGot, _, err := ReadRequest(bufio.NewReader(strings.NewReader(reqText)))
+	if !errors.Is(err, ErrMethodNotAllowed) {
+		t.Fatalf("got error: %v, want: %v", err, ErrMethodNotAllowed)
+	}
+	if reqGot == nil || reqGot.Method != "BREW" || !reqGot.Close {
+		t.Fatalf("got request: %v, want the parsed BREW request", reqGot)
+	}
+}
+
 func TestReadMultipleRequests(t *testing.T) {
 	var tests = []struct {
 		name     string
@@ -125,7 +159,7 @@ func TestReadMultipleRequests(t *testing.T) {
 					Method: "GET",
 					URL:    "/index.html",
 					Proto:  "HTTP/1.1",
-					Header: map[string]string{},
+					Header: Header{},
 					Host:   "test",
 					Close:  false,
 				},
@@ -133,7 +167,7 @@ func TestReadMultipleRequests(t *testing.T) {
 					Method: "GET",
 					URL:    "/index.html",
 					Proto:  "HTTP/1.1",
-					Header: map[string]string{},
+					Header: Header{},
 					Host:   "test",
 					Close:  false,
 				},
@@ -148,7 +182,7 @@ func TestReadMultipleRequests(t *testing.T) {
 					Method: "GET",
 					URL:    "/index.html",
 					Proto:  "HTTP/1.1",
-					Header: map[string]string{},
+					Header: Header{},
 					Host:   "test",
 					Close:  false,
 				},
@@ -171,3 +205,138 @@ func TestReadMultipleRequests(t *testing.T) {
 		})
 	}
 }
+
+func TestReadRequestProtocolErrors(t *testing.T) {
+	var tests = []struct {
+		name       string
+		reqText    string
+		statusWant int
+	}{
+		{"MethodOnly", "GET\r\nHost: test\r\n\r\n", 400},
+		{"ExtraSpace", "GET  /index.html HTTP/1.1\r\nHost: test\r\n\r\n", 400},
+		{"TrailingSpace", "GET /index.html HTTP/1.1 \r\nHost: test\r\n\r\n", 400},
+		{"BadMethod", "G(E)T /index.html HTTP/1.1\r\nHost: test\r\n\r\n", 400},
+		{"BadVersion", "GET /index.html HTTP/1\r\nHost: test\r\n\r\n", 400},
+		{"LowerVersion", "GET /index.html http/1.1\r\nHost: test\r\n\r\n", 400},
+		{"HTTP2", "GET /index.html HTTP/2.0\r\nHost: test\r\n\r\n", 505},
+		{"HTTP09", "GET /index.html HTTP/0.9\r\nHost: test\r\n\r\n", 505},
+		{"RelativeTarget", "GET index.html HTTP/1.1\r\nHost: test\r\n\r\n", 400},
+		{"Fragment", "GET /index.html#top HTTP/1.1\r\nHost: test\r\n\r\n", 400},
+		{"ControlInTarget", "GET /a\x01b HTTP/1.1\r\nHost: test\r\n\r\n", 400},
+		{"AsteriskNotOptions", "GET * HTTP/1.1\r\nHost: test\r\n\r\n", 400},
+		{"BadScheme", "GET ftp://test/index.html HTTP/1.1\r\nHost: test\r\n\r\n", 400},
+		{"UserInfo", "GET http://user@test/ HTTP/1.1\r\nHost: test\r\n\r\n", 400},
+		{"SpaceBeforeColon", "GET / HTTP/1.1\r\nHost : test\r\n\r\n", 400},
+		{"EmptyFieldName", "GET / HTTP/1.1\r\nHost: test\r\n: value\r\n\r\n", 400},
+		{"BadFieldName", "GET / HTTP/1.1\r\nHost: test\r\nBad[Name]: value\r\n\r\n", 400},
+		{"NoColon", "GET / HTTP/1.1\r\nHost: test\r\nNoColon\r\n\r\n", 400},
+		{"ObsFold", "GET / HTTP/1.1\r\nHost: test\r\nX-Long: a\r\n b\r\n\r\n", 400},
+		{"ControlInValue", "GET / HTTP/1.1\r\nHost: test\r\nX-Bad: a\x00b\r\n\r\n", 400},
+		{"DuplicateHost", "GET / HTTP/1.1\r\nHost: test\r\nHost: other\r\n\r\n", 400},
+		{"ConflictingContentLength", "POST / HTTP/1.1\r\nHost: test\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\nab", 400},
+		{"ContentLengthList", "POST / HTTP/1.1\r\nHost: test\r\nContent-Length: 1, 2\r\n\r\nab", 400},
+		{"SignedContentLength", "POST / HTTP/1.1\r\nHost: test\r\nContent-Length: +1\r\n\r\na", 400},
+		{"UnknownTransferEncoding", "POST / HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: gzip\r\n\r\n", 501},
+		{"TooManyEmptyLines", strings.Repeat("\r\n", 10) + "GET / HTTP/1.1\r\nHost: test\r\n\r\n", 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqGot, _, err := ReadRequest(bufio.NewReader(strings.NewReader(tt.reqText)))
+			var pe *ProtocolError
+			if !errors.As(err, &pe) {
+				t.Fatalf("got request: %v, error: %v, want a ProtocolError", reqGot, err)
+			}
+			if pe.StatusCode != tt.statusWant {
+				t.Fatalf("got status: %v (%v), want: %v", pe.StatusCode, err, tt.statusWant)
+			}
+		})
+	}
+}
+
+func TestReadRequestTargetForms(t *testing.T) {
+	var tests = []struct {
+		name     string
+		reqText  string
+		urlWant  string
+		hostWant string
+	}{
+		{"Origin", "GET /a?b=c HTTP/1.1\r\nHost: test\r\n\r\n", "/a?b=c", "test"},
+		{"Absolute", "GET http://Example.com:8080/a?b HTTP/1.1\r\nHost: test\r\n\r\n", "/a?b", "Example.com:8080"},
+		{"AbsoluteNoPath", "GET https://example.com HTTP/1.1\r\nHost: example.com\r\n\r\n", "/", "example.com"},
+		{"AbsoluteQueryOnly", "GET http://example.com?x HTTP/1.1\r\nHost: example.com\r\n\r\n", "/?x", "example.com"},
+		{"Asterisk", "OPTIONS * HTTP/1.1\r\nHost: test\r\n\r\n", "*", "test"},
+		{"LeadingEmptyLines", "\r\n\r\nGET / HTTP/1.1\r\nHost: test\r\n\r\n", "/", "test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _, err := ReadRequest(bufio.NewReader(strings.NewReader(tt.reqText)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.URL != tt.urlWant || req.Host != tt.hostWant {
+				t.Fatalf("got URL %q, Host %q, want: %q, %q", req.URL, req.Host, tt.urlWant, tt.hostWant)
+			}
+		})
+	}
+}
+
+func TestReadRequestRepeatedHeaders(t *testing.T) {
+	reqText := "POST / HTTP/1.1\r\nHost: test\r\n" +
+		"Accept: text/html\r\nAccept: text/plain\r\n" +
+		"Content-Length: 2\r\nContent-Length: 2\r\n\r\nab"
+	req, _, err := ReadRequest(bufio.NewReader(strings.NewReader(reqText)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Join("Accept"); got != "text/html, text/plain" {
+		t.Fatalf("got Accept: %q", got)
+	}
+	if req.ContentLength != 2 {
+		t.Fatalf("got ContentLength: %v", req.ContentLength)
+	}
+}
+
+func TestReadIncompleteRequest(t *testing.T) {
+	_, received, err := ReadRequest(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: te")))
+	if err != io.ErrUnexpectedEOF || !received {
+		t.Fatalf("got error: %v, received: %v, want: %v", err, received, io.ErrUnexpectedEOF)
+	}
+	_, received, err = ReadRequest(bufio.NewReader(strings.NewReader("")))
+	if err != io.EOF || received {
+		t.Fatalf("got error: %v, received: %v, want: %v", err, received, io.EOF)
+	}
+}
+
+func TestReadRequestConnection(t *testing.T) {
+	var tests = []struct {
+		name          string
+		reqText       string
+		closeWant     bool
+		keepAliveWant bool
+	}{
+		{"HTTP11Default", "GET / HTTP/1.1\r\nHost: test\r\n\r\n", false, false},
+		{"HTTP11Close", "GET / HTTP/1.1\r\nHost: test\r\nConnection: Close\r\n\r\n", true, false},
+		{"HTTP11Tokens", "GET / HTTP/1.1\r\nHost: test\r\nConnection: keep-alive, Upgrade\r\n\r\n", false, true},
+		{"HTTP11CloseInList", "GET / HTTP/1.1\r\nHost: test\r\nConnection: Upgrade, close\r\n\r\n", true, false},
+		{"HTTP11CloseOnSecondLine", "GET / HTTP/1.1\r\nHost: test\r\nConnection: TE\r\nConnection: close\r\n\r\n", true, false},
+		{"HTTP11NotAToken", "GET / HTTP/1.1\r\nHost: test\r\nConnection: closed\r\n\r\n", false, false},
+		{"HTTP10Default", "GET / HTTP/1.0\r\n\r\n", true, false},
+		{"HTTP10KeepAlive", "GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n", false, true},
+		{"HTTP10KeepAliveAndClose", "GET / HTTP/1.0\r\nConnection: keep-alive, close\r\n\r\n", true, false},
+		{"HTTP10TransferEncoding", "POST / HTTP/1.0\r\nConnection: keep-alive\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _, err := ReadRequest(bufio.NewReader(strings.NewReader(tt.reqText)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Close != tt.closeWant || req.KeepAlive != tt.keepAliveWant {
+				t.Fatalf("got Close %v, KeepAlive %v, want: %v, %v", req.Close, req.KeepAlive, tt.closeWant, tt.keepAliveWant)
+			}
+			if req.Header.Has("Connection") {
+				t.Fatalf("got Connection in Header: %v", req.Header)
+			}
+		})
+	}
+}
@@ -20,6 +20,14 @@ func TestWriteStatusLine(t *testing.T) {
 			},
 			"HTTP/1.1 200 OK\r\n",
 		},
+		{
+			"MethodNotAllowed",
+			&Response{
+				StatusCode: 405,
+				Proto:      "HTTP/1.1",
+			},
+			"HTTP/1.1 405 Method Not Allowed\r\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -45,10 +53,10 @@ func TestWriteSortedHeaders(t *testing.T) {
 		{
 			"Basic",
 			&Response{
-				Header: map[string]string{
-					"Connection": "close",
-					"Date":       "foobar",
-					"Misc":       "hello world",
+				Header: Header{
+					"Connection": {"close"},
+					"Date":       {"foobar"},
+					"Misc":       {"hello world"},
 				},
 			},
 			"Connection: close\r\n" +
@@ -124,3 +132,18 @@ func TestWriteBody(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteBodyHead(t *testing.T) {
+	res := &Response{
+		StatusCode: 200,
+		FilePath:   "testdata/index.html",
+		Request:    &Request{Method: "HEAD"},
+	}
+	var buffer bytes.Buffer
+	if err := res.WriteBody(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.Len() != 0 {
+		t.Fatalf("got %v body bytes for HEAD, want none", buffer.Len())
+	}
+}
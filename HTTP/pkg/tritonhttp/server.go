@@ -2,13 +2,18 @@ package tritonhttp
 
 import (
 	"bufio"
-	"fmt"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"log"
+	"math"
 	"net"
 	"os"
-	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,31 +25,311 @@ type Server struct {
 
 	// DocRoot specifies the path to the directory to serve static files from.
 	DocRoot string
+
+	// TLSConfig optionally provides a TLS configuration for use by
+	// ServeTLS and ListenAndServeTLS. It is cloned, so changing it
+	// after the server has started has no effect.
+	TLSConfig *tls.Config
+
+	// MIMETypes and Charset control the "Content-Type" of files served
+	// from DocRoot. See FileHandler.MIMETypes and FileHandler.Charset.
+	MIMETypes map[string]string
+	Charset   string
+
+	// Compress, CompressLevel and CompressMinSize control the compression
+	// of files served from DocRoot. See FileHandler.Compress.
+	Compress        bool
+	CompressLevel   int
+	CompressMinSize int64
+
+	// ConfineSymlinks refuses to serve files from DocRoot through
+	// symlinks that lead out of it. See FileHandler.ConfineSymlinks.
+	ConfineSymlinks bool
+
+	// Autoindex enables directory listings when static files are served
+	// from DocRoot. See FileHandler.Autoindex.
+	Autoindex AutoindexFormat
+
+	// ErrorPages customizes the body of the error responses generated
+	// by the server and by the static file handler, with files looked up
+	// below DocRoot. If nil, they get a plain text page.
+	ErrorPages *ErrorPages
+
+	// Handler handles every valid request. If nil, static files are
+	// served from DocRoot. Set it to a ServeMux to combine several handlers.
+	Handler Handler
+
+	// VirtualHosts maps host names to the virtual hosts serving them,
+	// chosen by the "Host" header of each request. Names are lower case,
+	// e.g. "example.com", "example.com:8080" or "*.example.com", the
+	// wildcard standing for any number of leading labels. Requests for
+	// other hosts go to the entry named DefaultHost, or if there is none,
+	// to Handler or DocRoot.
+	VirtualHosts map[string]*VirtualHost
+	DefaultHost  string
+
+	// MaxBodyBytes limits the size of request bodies. Larger requests
+	// are answered with 413 Content Too Large. If zero,
+	// DefaultMaxBodyBytes is used.
+	MaxBodyBytes int64
+
+	// ReadHeaderTimeout is how long the server waits for the first request
+	// on a new connection, and for the request line and headers of each
+	// request once its first byte has arrived. Expiring with part of a
+	// request received yields 408 Request Timeout. If zero,
+	// DefaultReadHeaderTimeout is used.
+	ReadHeaderTimeout time.Duration
+
+	// ReadTimeout is the maximum duration for reading the body of a
+	// request, counted from the end of its headers. Zero means no limit.
+	ReadTimeout time.Duration
+
+	// WriteTimeout is the maximum duration for handling a request and
+	// writing its response, counted from the first byte of the request.
+	// Zero means no limit.
+	WriteTimeout time.Duration
+
+	// IdleTimeout is how long a keep-alive connection may wait for its
+	// next request before being closed. If zero, DefaultIdleTimeout is used.
+	IdleTimeout time.Duration
+
+	// MaxHeaderBytes limits the size of the request line and headers.
+	// Larger requests get 431 Request Header Fields Too Large.
+	// If zero, DefaultMaxHeaderBytes is used.
+	MaxHeaderBytes int
+
+	// MaxRequestsPerConn is the number of requests served on a single
+	// connection before it is closed. Zero means no limit.
+	MaxRequestsPerConn int
+
+	// MaxConns limits the number of simultaneous connections. Further
+	// connections get 503 Service Unavailable. Zero means no limit.
+	MaxConns int
+
+	// H2C enables HTTP/2 over cleartext TCP connections, RFC 9113: a
+	// client may start the connection with the HTTP/2 preface, knowing
+	// beforehand that the server supports it, or ask to switch from
+	// HTTP/1.1 with "Upgrade: h2c". The requests of every stream go to
+	// the same handlers, with Proto set to "HTTP/2.0".
+	H2C bool
+
+	// MaxConcurrentStreams limits the number of streams an HTTP/2 client
+	// may have open at once. If zero, DefaultMaxConcurrentStreams is used.
+	MaxConcurrentStreams uint32
+
+	// AccessLog records one line per request. If nil, no access log is kept.
+	AccessLog *AccessLogger
+
+	// ErrorLog receives errors and diagnostics. If nil, warnings and
+	// errors go to the standard logger of the log package.
+	ErrorLog *ErrorLogger
+
+	inShutdown int32 // accessed atomically
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]connState
 }
 
+const (
+	// DefaultReadHeaderTimeout is used when Server.ReadHeaderTimeout is zero.
+	DefaultReadHeaderTimeout = 5 * time.Second
+
+	// DefaultIdleTimeout is used when Server.IdleTimeout is zero.
+	DefaultIdleTimeout = 5 * time.Second
+
+	// DefaultMaxHeaderBytes is used when Server.MaxHeaderBytes is zero.
+	DefaultMaxHeaderBytes = 1 << 20 // 1 MB
+)
+
+// ErrServerClosed is returned by ListenAndServe and Serve
+// after a call to Shutdown or Close.
+var ErrServerClosed = errors.New("tritonhttp: Server closed")
+
+// connState is the state of a connection tracked by the server.
+type connState int
+
+const (
+	stateIdle   connState = iota // waiting for the next request
+	stateActive                  // reading a request or writing its response
+)
+
 // ListenAndServe listens on the TCP network address s.Addr and then
 // handles requests on incoming connections.
+//
+// ListenAndServe always returns a non-nil error. After Shutdown or Close,
+// the returned error is ErrServerClosed.
 func (s *Server) ListenAndServe() error {
-
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
 	server, err := net.Listen("tcp",s.Addr)
 	if err != nil {
 		return err
 	}
-	defer server.Close()
+	return s.Serve(server)
+}
 
-	// Hint: call HandleConnection
+// Serve accepts incoming connections on the listener l and handles
+// each of them in a new goroutine. It takes ownership of l, which is
+// closed when Serve returns.
+//
+// Serve always returns a non-nil error. After Shutdown or Close,
+// the returned error is ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.trackListener(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
+	defer l.Close()
+
+	var backoff time.Duration
 	for {
 		//accept connection from client
-		conn, err := server.Accept()
+		conn, err := l.Accept()
 		if err != nil {
-			fmt.Println("获取连接出错")
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {// 临时错误 等待一会再继续accept
+				if backoff == 0 {
+					backoff = 5 * time.Millisecond
+				} else if backoff *= 2; backoff > time.Second {
+					backoff = time.Second
+				}
+				s.errorLog().Errorf("accept error: %v; retrying in %v", err, backoff)
+				time.Sleep(backoff)
+				continue
+			}
+			return err
 		}
-		fmt.Println("client["+conn.RemoteAddr().String()+"]:connecting...")
+		backoff = 0
+		s.errorLog().Debugf("%v: connection accepted", conn.RemoteAddr())
 		//handle the connection by goroutine
 		go s.HandleConnection(conn)
 	}
 }
 
+// Shutdown gracefully shuts down the server. It closes all listeners,
+// then closes idle connections, and waits for the active ones to finish
+// their current request before closing them too.
+//
+// Shutdown returns nil once every connection is closed, or the error of
+// ctx if it expires first. In that case the remaining connections are
+// left open; call Close to drop them.
+func (s *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.inShutdown, 1)
+	err := s.closeListeners()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all listeners and all connections,
+// whatever their state. For a graceful shutdown, use Shutdown.
+func (s *Server) Close() error {
+	atomic.StoreInt32(&s.inShutdown, 1)
+	err := s.closeListeners()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+	return err
+}
+
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s.inShutdown) != 0
+}
+
+// trackListener adds or removes l from the listeners closed by Shutdown.
+// It reports false if l cannot be added because the server is shutting down.
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+func (s *Server) closeListeners() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// setConnState records the state of conn for Shutdown.
+func (s *Server) setConnState(conn net.Conn, state connState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]connState)
+	}
+	s.conns[conn] = state
+}
+
+// trackConn starts tracking conn. It reports false when the server
+// already has MaxConns connections, in which case conn is not tracked.
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.MaxConns > 0 && len(s.conns) >= s.MaxConns {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]connState)
+	}
+	s.conns[conn] = stateIdle
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// closeIdleConns closes the idle connections and reports
+// whether no connection is left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c, st := range s.conns {
+		if st == stateIdle {
+			c.Close()
+			delete(s.conns, c)
+		}
+	}
+	return len(s.conns) == 0
+}
+
 // HandleConnection reads requests from the accepted conn and handles them.
 func (s *Server) HandleConnection(conn net.Conn) {
 	if conn == nil {//check the connection
@@ -54,198 +339,452 @@ func (s *Server) HandleConnection(conn net.Conn) {
 		resp.Write(conn)
 		return
 	}
+	sc := &serverConn{srv: s, conn: conn}
+	defer func() {
+		if !sc.hijacked {// 被handler接管的连接由handler关闭
+			lingeringClose(conn)
+		}
+	}()
+	defer s.untrackConn(conn)
+	remoteAddr := conn.RemoteAddr().String()
+	writer := bufio.NewWriter(conn)
+	if !s.trackConn(conn) {// 连接数已达上限 返回503
+		s.errorLog().Warnf("rejecting %v: %v connections open", remoteAddr, s.MaxConns)
+		resp := &Response{}
+		resp.HandleServiceUnavailable()
+		conn.SetWriteDeadline(time.Now().Add(s.readHeaderTimeout()))
+		s.writeResponse(writer, remoteAddr, nil, resp, time.Now())
+		return
+	}
+
+	// TLS握手 失败时直接关闭连接
+	var tlsState *tls.ConnectionState
+	if tc, ok := conn.(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(s.readHeaderTimeout()))
+		if err := tc.Handshake(); err != nil {
+			s.errorLog().Infof("%v: TLS handshake error: %v", remoteAddr, err)
+			return
+		}
+		tc.SetDeadline(time.Time{})
+		state := tc.ConnectionState()
+		tlsState = &state
+	}
 
 	// Hint: use the other methods below
-	var req *Request
-	reader := bufio.NewReaderSize(conn, 128)
-	var bytesReceivied = false
+	// lr limits how much of the request line and headers can be read
+	lr := &io.LimitedReader{R: conn}
+	reader := bufio.NewReaderSize(lr, 128)
+	sc.br = reader
 
-	for {
-		// Set timeout
-		err := conn.SetDeadline(time.Now().Add(5 * time.Second))//5s
-		if err != nil {// Handle timeout
-			if !bytesReceivied {//未收到部分请求时 close
-				defer conn.Close()
-				return
-			} else { //400
-				resp := &Response{}
-				resp.HandleBadRequest()
-				resp.Write(conn)
-				fmt.Println("connection timeout:" + err.Error())
-				return
+	for served := 0; ; served++ {
+		// 服务器正在关闭 不再读取新的请求
+		if s.shuttingDown() {
+			return
+		}
+		s.setConnState(conn, stateIdle)
+
+		// Set timeout: wait for the next request, then for its headers
+		wait := s.idleTimeout()
+		if served == 0 {
+			wait = s.readHeaderTimeout()
+		}
+		lr.N = s.maxHeaderBytes() + 4096 // bufio 会预读 留出余量
+		if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+			return
+		}
+
+		// Wait for the first byte of the next request before marking
+		// the connection active, so that Shutdown can close it while idle
+		start := time.Now()
+		if _, err := reader.Peek(1); err == nil {
+			s.setConnState(conn, stateActive)
+			start = time.Now()
+			conn.SetReadDeadline(start.Add(s.readHeaderTimeout()))
+			if s.WriteTimeout > 0 {
+				conn.SetWriteDeadline(start.Add(s.WriteTimeout))
+			} else {
+				conn.SetWriteDeadline(time.Time{})
+			}
+		}
+
+		// HTTP/2 prior knowledge: 客户端直接发送HTTP/2的preface
+		if served == 0 && s.H2C && tlsState == nil {
+			if b, err := reader.Peek(3); err == nil && string(b) == http2Preface[:3] {
+				if b, err := reader.Peek(len(http2Preface)); err == nil && string(b) == http2Preface {
+					lr.N = math.MaxInt64
+					s.serveHTTP2(conn, reader, writer, nil, nil)
+					return
+				}
 			}
 		}
 
 		// Try to read next request
-		req,bytesReceivied,err = ReadRequest(reader)//读取请求 读完全部请求或者出现错误跳出for循环
+		req,bytesReceivied,err := ReadRequest(reader)//读取请求 读完全部请求或者出现错误跳出for循环
+		if lr.N <= 0 {// 请求行和请求头超过了MaxHeaderBytes 返回431
+			s.errorLog().Infof("%v: request header exceeds %v bytes", remoteAddr, s.maxHeaderBytes())
+			resp := &Response{}
+			resp.HandleHeaderTooLarge()
+			s.writeResponse(writer, remoteAddr, nil, resp, start)
+			return
+		}
+		lr.N = math.MaxInt64 // body的大小由MaxBodyBytes限制
+		if s.ReadTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		if req != nil {
+			req.RemoteAddr = remoteAddr
+			req.TLS = tlsState
+			req.conn = sc
+			if s.MaxRequestsPerConn > 0 && served+1 >= s.MaxRequestsPerConn {
+				req.Close = true // 达到单个连接的请求数上限 响应后关闭连接
+			}
+		}
+
 		if err != nil {
-			fmt.Println("read request error.")
-			if err.Error() == "EOF" {// Handle EOF
-				fmt.Println("Close connection(EOF):" + conn.RemoteAddr().String())
-				res := s.HandleGoodRequest(req)
-				if res.StatusCode == 200 {
-					res.Write(conn)
-					defer conn.Close()
-					return
-				} else if res.StatusCode == 404 {
-					res.Write(conn)
-					//return
-					continue
-				} else if res.StatusCode == 400 {
-					res.Write(conn)
-					defer conn.Close()
-					return
+			if s.shuttingDown() && req == nil {// 空闲连接被Shutdown关闭
+				return
+			}
+			if err.Error() == "EOF" {// Handle EOF 处理完最后一个请求后关闭连接
+				s.errorLog().Debugf("%v: connection closed by client", remoteAddr)
+				if req != nil {
+					s.serve(writer, req, start, 0)
 				}
-				//err = req.HandleUrl(s.DocRoot)//处理url
-				//if err !=nil {
-				//	if err.Error() == "400" {//返回400 关闭连接
-				//		resp := &Response{}
-				//		resp.HandleBadRequest()
-				//		resp.Write(conn)
-				//		defer conn.Close()
-				//		return
-				//	} else if err.Error() == "404" {//返回404 读取下一个请求
-				//		resp := &Response{}
-				//		resp.HandleNotFound(req)
-				//		resp.Write(conn)
-				//		//return
-				//		continue
-				//	}
-				//} else {//200
-				//	resp := &Response{}
-				//	filePath := s.DocRoot + req.URL
-				//	resp.HandleOK(req, filePath)
-				//	resp.Write(conn)
-				//	defer conn.Close()
-				//	return
-				//}
+				return
 			} else if n := strings.Index(err.Error(),"i/o timeout"); n != -1 {// Handle timeout
-				fmt.Println("Close connection(i/o timeout):" + conn.RemoteAddr().String())
-				if !bytesReceivied {//如果之前未收到部分请求 服务器简单的close
-					defer conn.Close()
-					return
-				} else {//如果之前收到了部分请求 返回400
+				s.errorLog().Debugf("%v: read timeout", remoteAddr)
+				if bytesReceivied {//如果之前收到了部分请求 返回408 否则服务器简单的close
 					resp := &Response{}
-					resp.HandleBadRequest()
-					resp.Write(conn)
-					defer conn.Close()
-					return
+					resp.HandleRequestTimeout()
+					s.writeResponse(writer, remoteAddr, nil, resp, start)
 				}
-			} else {//Handle bad request
-				fmt.Println("other error:",err.Error())
-				resp := &Response{}
-				resp.HandleBadRequest()
-				resp.Write(conn)
-				defer conn.Close()
 				return
-			}
-		} else {// 读取请求没有格式错误时
-			fmt.Println("收到Client端发来的请求["+req.Host + "]")
-			res := s.HandleGoodRequest(req)
-			if res.StatusCode == 200 {
-				res.Write(conn)
-				// Close conn if requested
-				if res.Request.Close { // Close conn if requested
-					defer conn.Close()
+			} else if errors.Is(err, ErrMethodNotAllowed) {// 405 请求完整 只是方法不支持
+				resp := &Response{}
+				resp.HandleMethodNotAllowed(req)
+				s.writeResponse(writer, remoteAddr, req, resp, start)
+				if req.Close || req.Body != nil {// 没有读body 不能继续读下一个请求
 					return
 				}
-			} else if res.StatusCode == 404 {
-				res.Write(conn)
-				//return
 				continue
-			} else if res.StatusCode == 400 {
-				res.Write(conn)
-				defer conn.Close()
+			} else {//Handle bad request 400 501 505
+				s.errorLog().Infof("%v: bad request: %v", remoteAddr, err)
+				resp := &Response{}
+				switch errorStatus(err) {
+				case 501:
+					resp.HandleNotImplemented()
+				case 505:
+					resp.HandleHTTPVersionNotSupported()
+				default:
+					resp.HandleBadRequest()
+				}
+				s.writeResponse(writer, remoteAddr, nil, resp, start)
 				return
 			}
+		}
+
+		// Upgrade: h2c 以101响应 之后在同一个连接上使用HTTP/2
+		if s.H2C && tlsState == nil && isH2CUpgrade(req) && s.upgradeH2C(sc, writer, req) {
+			return
+		}
 
-			//err = req.HandleUrl(s.DocRoot)//处理url
-			//if err !=nil {//如果url不符合条件 或者 找不到资源
-			//	if err.Error() == "400" {//格式有误
-			//		resp := &Response{}
-			//		resp.HandleBadRequest()
-			//		resp.Write(conn)
-			//		defer conn.Close()
-			//		return
-			//	} else if err.Error() == "404" {//找不到
-			//		resp := &Response{}
-			//		resp.HandleNotFound(req)
-			//		resp.Write(conn)
-			//		//return
-			//		continue
-			//	}
-			//} else {
-			//	fmt.Println(req.Method, req.Proto, req.URL, req.Close)
-			//	// Handle good request
-			//	resp := s.HandleGoodRequest(req) //init 200
-			//	fmt.Println(resp.Proto, resp.StatusCode, resp.FilePath)
-			//	resp.Write(conn)
-			//
-			//	// Close conn if requested
-			//	if resp.Request.Close { // Close conn if requested
-			//		defer conn.Close()
-			//		return
-			//	}
-			//}
+		// 读取请求没有格式错误时 交给handler处理
+		remaining := 0
+		if s.MaxRequestsPerConn > 0 {
+			remaining = s.MaxRequestsPerConn - served - 1
+		}
+		if closeConn := s.serve(writer, req, start, remaining); closeConn {
+			return
 		}
 	}
 }
 
-// HandleGoodRequest handles the valid req and generates the corresponding res.
-func (s *Server) HandleGoodRequest(req *Request) (res *Response) {//include 200 and 404
-	res = &Response{}
-	res.Proto = "HTTP/1.1"
-	res.Request = req
-	res.Header = req.Header
-	// Hint: use the other methods below
-	//check url format
-	err := req.HandleUrl(s.DocRoot)
-	if err !=nil {
-		if err.Error() == "400" {//返回400 关闭连接
-			res.HandleBadRequest()
-		} else if err.Error() == "404" {//返回404
-			res.HandleNotFound(req)
+// lingeringClose closes conn after giving the client a chance to read
+// the last response. Closing a TCP connection with unread input makes the
+// kernel send RST, which can destroy the response before the client
+// reads it, e.g. after 431 or with pipelined requests left unserved.
+func lingeringClose(conn net.Conn) {
+	defer conn.Close()
+	cw, ok := conn.(interface{ CloseWrite() error })
+	if !ok || cw.CloseWrite() != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	io.Copy(io.Discard, io.LimitReader(conn, 256<<10))
+}
+
+// ServeRequest passes the valid req to the server's handler and writes
+// the response to bw. It reports whether the connection should be
+// closed afterwards.
+func (s *Server) ServeRequest(bw *bufio.Writer, req *Request) (closeConn bool) {
+	return s.serve(bw, req, time.Now(), 0)
+}
+
+// serve implements ServeRequest. start is when the request began to
+// arrive, for the access log, and remaining is how many more requests
+// the connection may carry, or 0 for no limit.
+func (s *Server) serve(bw *bufio.Writer, req *Request, start time.Time, remaining int) (closeConn bool) {
+	w := newResponseWriter(bw, req)
+	w.keepAlive = s.keepAlive(remaining)
+	defer func() {
+		s.logAccess(req.RemoteAddr, req, w.status, w.written, start)
+	}()
+	var body *maxBytesReader
+	if cr, ok := req.Body.(*chunkedReader); ok {
+		cr.maxTrailer = s.maxHeaderBytes()
+	}
+	if req.Body != nil {
+		if req.ContentLength > s.maxBodyBytes() {// Content-Length已经超过上限 不用读body直接413
+			res := &Response{}
+			res.HandlePayloadTooLarge(req)
+			s.errorPage(res)
+			res.Serve(w)
+			w.finish()
+			return true
+		}
+		body = &maxBytesReader{r: req.Body, n: s.maxBodyBytes()}
+		req.Body = body
+	}
+
+	s.handlerFor(req).ServeHTTP(w, req)
+	if w.hijacked {
+		return true
+	}
+	if w.streamDone != nil {
+		// 连接在流式响应之后关闭 不必丢弃body 它可能已经被读走了一部分
+		w.endStream()
+		body = nil
+	}
+
+	if body != nil {
+		// 丢弃handler没有读完的body 否则会被当成下一个请求
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			s.errorLog().Infof("%v: discarding request body: %v", req.RemoteAddr, err)
+			if !w.headerSent {
+				if body.tooLarge {
+					w.reset()
+					res := &Response{}
+					res.HandlePayloadTooLarge(req)
+					s.errorPage(res)
+					res.Serve(w)
+				}
+				w.header.Set("Connection", "close")
+			}
+			w.finish()
+			return true
 		}
-	} else {//200
-		filePath := s.DocRoot + req.URL//拼接绝对路径
-		res.HandleOK(req, filePath)
 	}
-	return
+	if err := w.finish(); err != nil {
+		s.errorLog().Infof("%v: writing response: %v", req.RemoteAddr, err)
+		return true
+	}
+	return w.shouldClose()
+}
+
+// writeResponse writes a response prepared by the server itself, such as
+// 400 Bad Request, to bw. req is nil when the request could not be parsed.
+func (s *Server) writeResponse(bw *bufio.Writer, remoteAddr string, req *Request, res *Response, start time.Time) {
+	w := newResponseWriter(bw, req)
+	w.keepAlive = s.keepAlive(0)
+	s.errorPage(res)
+	res.Serve(w)
+	if err := w.finish(); err != nil {
+		s.errorLog().Debugf("%v: writing response: %v", remoteAddr, err)
+	}
+	s.logAccess(remoteAddr, req, w.status, w.written, start)
+}
+
+// errorPage replaces the body of the error response res with the page
+// configured in s.ErrorPages, if any.
+func (s *Server) errorPage(res *Response) {
+	if s.ErrorPages != nil && res.StatusCode >= 400 {
+		res.setErrorPage(s.ErrorPages, s.DocRoot, s.errorLog())
+	}
+}
+
+// logAccess records the response to req, with status and written body
+// bytes, in the access log, if any.
+func (s *Server) logAccess(remoteAddr string, req *Request, status int, written int64, start time.Time) {
+	if s.AccessLog == nil {
+		return
+	}
+	e := &LogEntry{
+		Time:       start,
+		RemoteAddr: remoteAddr,
+		Status:     status,
+		Bytes:      written,
+		Duration:   time.Since(start),
+	}
+	if req != nil {
+		e.Method = req.Method
+		e.URL = req.URL
+		e.Proto = req.Proto
+		e.UserAgent = req.Header.Get("User-Agent")
+		e.Referer = req.Header.Get("Referer")
+		if req.Method == "HEAD" {
+			e.Bytes = 0
+		}
+	}
+	s.AccessLog.Log(e)
+}
+
+// keepAlive returns the value of the "Keep-Alive" header advertising
+// the idle timeout and, when limited, the remaining requests.
+func (s *Server) keepAlive(remaining int) string {
+	v := "timeout=" + strconv.Itoa(int(s.idleTimeout()/time.Second))
+	if remaining > 0 {
+		v += ", max=" + strconv.Itoa(remaining)
+	}
+	return v
+}
+
+// errorLog returns s.ErrorLog, or the default error log if it is nil.
+func (s *Server) errorLog() *ErrorLogger {
+	if s.ErrorLog != nil {
+		return s.ErrorLog
+	}
+	return defaultErrorLog
+}
+
+// errorLog returns the error log of the server req was read by.
+func (req *Request) errorLog() *ErrorLogger {
+	if req.conn != nil {
+		return req.conn.srv.errorLog()
+	}
+	return defaultErrorLog
+}
+
+func (s *Server) readHeaderTimeout() time.Duration {
+	if s.ReadHeaderTimeout > 0 {
+		return s.ReadHeaderTimeout
+	}
+	return DefaultReadHeaderTimeout
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout > 0 {
+		return s.IdleTimeout
+	}
+	return DefaultIdleTimeout
+}
+
+func (s *Server) maxHeaderBytes() int64 {
+	if s.MaxHeaderBytes > 0 {
+		return int64(s.MaxHeaderBytes)
+	}
+	return DefaultMaxHeaderBytes
+}
+
+func (s *Server) maxBodyBytes() int64 {
+	if s.MaxBodyBytes > 0 {
+		return s.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// handler returns the Handler requests are dispatched to: s.Handler if set,
+// otherwise a FileHandler serving s.DocRoot.
+func (s *Server) handler() Handler {
+	if s.Handler != nil {
+		return s.Handler
+	}
+	return s.FileHandler()
+}
+
+// FileHandler returns a FileHandler serving s.DocRoot with the file
+// settings of s. It lets a custom Handler fall back to static files.
+func (s *Server) FileHandler() *FileHandler {
+	return &FileHandler{
+		DocRoot:         s.DocRoot,
+		MIMETypes:       s.MIMETypes,
+		Charset:         s.Charset,
+		Compress:        s.Compress,
+		CompressLevel:   s.CompressLevel,
+		CompressMinSize: s.CompressMinSize,
+		ConfineSymlinks: s.ConfineSymlinks,
+		Autoindex:       s.Autoindex,
+		ErrorPages:      s.ErrorPages,
+		ErrorLog:        s.ErrorLog,
+	}
+}
+
+// HandleGoodRequest handles the valid req and generates the corresponding res
+// by serving a file from s.DocRoot.
+func (s *Server) HandleGoodRequest(req *Request) (res *Response) {//include 200 and 404
+	return s.FileHandler().Respond(req)
 }
 
 // HandleOK prepares res to be a 200 OK response
 // ready to be written back to client.
 func (res *Response) HandleOK(req *Request, path1 string) {//200
+	if err := res.handleOK(req, path1); err != nil {
+		res.HandleNotFound(req)
+		return
+	}
+	res.Header.Set("Content-Type", ContentType(res.FilePath, nil, ""))
+}
+
+// handleOK is HandleOK without the "Content-Type", left to the caller.
+// It fails if the file cannot be found.
+func (res *Response) handleOK(req *Request, path1 string) error {
 	res.StatusCode = 200
 	res.Request = req
 	res.FilePath = path1
 
-	res.Header = make(map[string]string)
+	res.Header = make(Header)
 	now := time.Now()
-	res.Header[CanonicalHeaderKey("date")] = FormatTime(now)
+	res.Header.Set("Date", FormatTime(now))
 
 	fileInfo,err := os.Stat(res.FilePath)//get file information
 	if err != nil {
-		fmt.Println(err)
-	}
-	fileExt := path.Ext(path.Base(res.FilePath))
-	var fileType string
-	switch fileExt {
-	case ".html":
-		fileType = contentTypeHTML1
-	case "./jpg":
-		fileType = contentTypeJPG1
-	case "./png":
-		fileType = contentTypePNG1
-	default:
-		fileType = "text/plain"
-	}
-	res.Header[CanonicalHeaderKey("last-modified")] = FormatTime(fileInfo.ModTime())
-	res.Header[CanonicalHeaderKey("content-type")] = fileType
-	res.Header[CanonicalHeaderKey("content-length")] = strconv.FormatInt(fileInfo.Size(),10)
+		return err
+	}
+	res.Header.Set("Last-Modified", FormatTime(fileInfo.ModTime()))
+	res.Header.Set("Content-Length", strconv.FormatInt(fileInfo.Size(),10))
+	res.Header.Set("Accept-Ranges", "bytes")
+	res.Header.Set("Etag", FileETag(fileInfo, false))
+
+	if req != nil && req.Close {
+		res.Header.Set("Connection", "close")
+	}
+	return nil
+}
+
+// HandleNotImplemented prepares res to be a 501 Not Implemented response
+// ready to be written back to client. The connection is always closed.
+func (res *Response) HandleNotImplemented() {//501
+	res.StatusCode = 501
+	res.Header = make(Header)
+	now := time.Now()
+	res.Header.Set("Date", FormatTime(now))
+	res.Header.Set("Connection", "close")
+	res.SetErrorPage(nil, "")
+}
 
+// HandleHTTPVersionNotSupported prepares res to be a 505 HTTP Version Not
+// Supported response ready to be written back to client. The connection
+// is always closed.
+func (res *Response) HandleHTTPVersionNotSupported() {//505
+	res.StatusCode = 505
+	res.Header = make(Header)
+	now := time.Now()
+	res.Header.Set("Date", FormatTime(now))
+	res.Header.Set("Connection", "close")
+	res.SetErrorPage(nil, "")
+}
+
+// HandleMovedPermanently prepares res to be a 301 Moved Permanently
+// response redirecting the client to location.
+func (res *Response) HandleMovedPermanently(req *Request, location string) {//301
+	res.StatusCode = 301
+	res.Request = req
+	res.Header = make(Header)
+	now := time.Now()
+	res.Header.Set("Date", FormatTime(now))
+	res.Header.Set("Location", location)
 	if req != nil && req.Close {
-		res.Header[CanonicalHeaderKey("connection")] = "close"
+		res.Header.Set("Connection", "close")
 	}
 }
 
@@ -253,10 +792,25 @@ func (res *Response) HandleOK(req *Request, path1 string) {//200
 // ready to be written back to client.
 func (res *Response) HandleBadRequest() {//400
 	res.StatusCode = 400
-	res.Header = make(map[string]string)
+	res.Header = make(Header)
+	now := time.Now()
+	res.Header.Set("Date", FormatTime(now))
+	res.Header.Set("Connection", "close")
+	res.SetErrorPage(nil, "")
+}
+
+// HandleForbidden prepares res to be a 403 Forbidden response
+// ready to be written back to client.
+func (res *Response) HandleForbidden(req *Request) {//403
+	res.StatusCode = 403
+	res.Request = req
+	res.Header = make(Header)
 	now := time.Now()
-	res.Header[CanonicalHeaderKey("date")] = FormatTime(now)
-	res.Header[CanonicalHeaderKey("connection")] = "close"
+	res.Header.Set("Date", FormatTime(now))
+	if req != nil && req.Close {
+		res.Header.Set("Connection", "close")
+	}
+	res.SetErrorPage(nil, "")
 }
 
 // HandleNotFound prepares res to be a 404 Not Found response
@@ -264,10 +818,131 @@ func (res *Response) HandleBadRequest() {//400
 func (res *Response) HandleNotFound(req *Request) {//404
 	res.StatusCode = 404
 	res.Request = req
-	res.Header = make(map[string]string)
+	res.Header = make(Header)
+	now := time.Now()
+	res.Header.Set("Date", FormatTime(now))
+	if req != nil && req.Close {
+		res.Header.Set("Connection", "close")
+	}
+	res.SetErrorPage(nil, "")
+}
+
+// HandleMethodNotAllowed prepares res to be a 405 Method Not Allowed response
+// ready to be written back to client.
+func (res *Response) HandleMethodNotAllowed(req *Request) {//405
+	res.StatusCode = 405
+	res.Request = req
+	res.Header = make(Header)
+	now := time.Now()
+	res.Header.Set("Date", FormatTime(now))
+	res.Header.Set("Allow", AllowedMethods)
+	if req != nil && req.Close {
+		res.Header.Set("Connection", "close")
+	}
+	res.SetErrorPage(nil, "")
+}
+
+// HandlePayloadTooLarge prepares res to be a 413 Content Too Large response
+// ready to be written back to client. The connection is always closed,
+// since the rest of the body is not read.
+func (res *Response) HandlePayloadTooLarge(req *Request) {//413
+	res.StatusCode = 413
+	res.Request = req
+	res.Header = make(Header)
+	now := time.Now()
+	res.Header.Set("Date", FormatTime(now))
+	res.Header.Set("Connection", "close")
+	res.SetErrorPage(nil, "")
+}
+
+// HandleNotModified turns the 200 OK response prepared by HandleOK into
+// a 304 Not Modified response. Only the validators, "Date", "Vary" and
+// "Connection" are kept, and there is no file to serve.
+func (res *Response) HandleNotModified() {//304
+	res.StatusCode = 304
+	res.FilePath = ""
+	header := make(Header)
+	for _, k := range []string{"Date", "Etag", "Last-Modified", "Vary", "Connection"} {
+		if v, ok := res.Header[k]; ok {
+			header[k] = v
+		}
+	}
+	res.Header = header
+}
+
+// HandlePreconditionFailed prepares res to be a 412 Precondition Failed response
+// ready to be written back to client.
+func (res *Response) HandlePreconditionFailed(req *Request) {//412
+	res.StatusCode = 412
+	res.Request = req
+	res.FilePath = ""
+	res.Header = make(Header)
+	now := time.Now()
+	res.Header.Set("Date", FormatTime(now))
+	if req != nil && req.Close {
+		res.Header.Set("Connection", "close")
+	}
+	res.SetErrorPage(nil, "")
+}
+
+// HandleRequestTimeout prepares res to be a 408 Request Timeout response
+// ready to be written back to client.
+func (res *Response) HandleRequestTimeout() {//408
+	res.StatusCode = 408
+	res.Header = make(Header)
+	now := time.Now()
+	res.Header.Set("Date", FormatTime(now))
+	res.Header.Set("Connection", "close")
+	res.SetErrorPage(nil, "")
+}
+
+// HandleHeaderTooLarge prepares res to be a 431 Request Header Fields Too Large
+// response ready to be written back to client.
+func (res *Response) HandleHeaderTooLarge() {//431
+	res.StatusCode = 431
+	res.Header = make(Header)
+	now := time.Now()
+	res.Header.Set("Date", FormatTime(now))
+	res.Header.Set("Connection", "close")
+	res.SetErrorPage(nil, "")
+}
+
+// HandleServiceUnavailable prepares res to be a 503 Service Unavailable
+// response ready to be written back to client.
+func (res *Response) HandleServiceUnavailable() {//503
+	res.StatusCode = 503
+	res.Header = make(Header)
+	now := time.Now()
+	res.Header.Set("Date", FormatTime(now))
+	res.Header.Set("Connection", "close")
+	res.Header.Set("Retry-After", "1")
+	res.SetErrorPage(nil, "")
+}
+
+// HandleBadGateway prepares res to be a 502 Bad Gateway response
+// ready to be written back to client.
+func (res *Response) HandleBadGateway(req *Request) {//502
+	res.StatusCode = 502
+	res.Request = req
+	res.Header = make(Header)
+	now := time.Now()
+	res.Header.Set("Date", FormatTime(now))
+	if req != nil && req.Close {
+		res.Header.Set("Connection", "close")
+	}
+	res.SetErrorPage(nil, "")
+}
+
+// HandleGatewayTimeout prepares res to be a 504 Gateway Timeout response
+// ready to be written back to client.
+func (res *Response) HandleGatewayTimeout(req *Request) {//504
+	res.StatusCode = 504
+	res.Request = req
+	res.Header = make(Header)
 	now := time.Now()
-	res.Header[CanonicalHeaderKey("date")] = FormatTime(now)
+	res.Header.Set("Date", FormatTime(now))
 	if req != nil && req.Close {
-		res.Header[CanonicalHeaderKey("connection")] = "close"
+		res.Header.Set("Connection", "close")
 	}
+	res.SetErrorPage(nil, "")
 }
@@ -1,8 +1,17 @@
 package tritonhttp
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -42,7 +51,7 @@ func TestHandleGoodRequest(t *testing.T) {
 				Method: "GET",
 				URL:    "/index.html",
 				Proto:  "HTTP/1.1",
-				Header: map[string]string{},
+				Header: Header{},
 				Host:   "test",
 				Close:  false,
 			},
@@ -63,7 +72,7 @@ func TestHandleGoodRequest(t *testing.T) {
 				Method: "GET",
 				URL:    "/index.html",
 				Proto:  "HTTP/1.1",
-				Header: map[string]string{},
+				Header: Header{},
 				Host:   "test",
 				Close:  true,
 			},
@@ -85,7 +94,7 @@ func TestHandleGoodRequest(t *testing.T) {
 				Method: "GET",
 				URL:    "/",
 				Proto:  "HTTP/1.1",
-				Header: map[string]string{},
+				Header: Header{},
 				Host:   "test",
 				Close:  false,
 			},
@@ -106,7 +115,7 @@ func TestHandleGoodRequest(t *testing.T) {
 				Method: "GET",
 				URL:    "/notexist.html",
 				Proto:  "HTTP/1.1",
-				Header: map[string]string{},
+				Header: Header{},
 				Host:   "test",
 				Close:  false,
 			},
@@ -135,7 +144,7 @@ func TestHandleGoodRequest(t *testing.T) {
 				}
 			}
 			for h, vWant := range tt.headerValuesWant {
-				v, ok := res.Header[h]
+				v, ok := res.Header.Get(h), res.Header.Has(h)
 				if !ok {
 					t.Fatalf("missing header %q", h)
 				}
@@ -161,3 +170,376 @@ func TestHandleGoodRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleHeadRequest(t *testing.T) {
+	s := &Server{
+		Addr:    ":0",
+		DocRoot: "testdata",
+	}
+	get := s.HandleGoodRequest(&Request{Method: "GET", URL: "/index.html", Proto: "HTTP/1.1", Header: Header{}, Host: "test"})
+	head := s.HandleGoodRequest(&Request{Method: "HEAD", URL: "/index.html", Proto: "HTTP/1.1", Header: Header{}, Host: "test"})
+	if head.StatusCode != get.StatusCode {
+		t.Fatalf("status code got: %v, want: %v", head.StatusCode, get.StatusCode)
+	}
+	for _, h := range []string{"Content-Length", "Content-Type", "Last-Modified"} {
+		if head.Header.Get(h) != get.Header.Get(h) {
+			t.Fatalf("header %q got: %q, want: %q", h, head.Header.Get(h), get.Header.Get(h))
+		}
+	}
+}
+
+func TestHandleMethodNotAllowed(t *testing.T) {
+	res := &Response{}
+	res.HandleMethodNotAllowed(&Request{Method: "POST", Close: true})
+	if res.StatusCode != 405 {
+		t.Fatalf("status code got: %v, want: 405", res.StatusCode)
+	}
+	if v := res.Header.Get("Allow"); v != AllowedMethods {
+		t.Fatalf("header %q value got: %q, want %q", "Allow", v, AllowedMethods)
+	}
+	if v := res.Header.Get("Connection"); v != "close" {
+		t.Fatalf("header %q value got: %q, want %q", "Connection", v, "close")
+	}
+}
+
+// startServer serves s on a local ":0" listener and returns its address,
+// along with a channel receiving the error Serve returns.
+func startServer(t *testing.T, s *Server) (string, chan error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.Serve(l)
+	}()
+	return l.Addr().String(), errChan
+}
+
+func TestShutdownClosesIdleConns(t *testing.T) {
+	s := &Server{DocRoot: "testdata"}
+	addr, serveErr := startServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n")
+	br := bufio.NewReader(conn)
+	line, err := ReadLine(br)
+	if err != nil || line != "HTTP/1.1 200 OK" {
+		t.Fatalf("got status line: %q, %v", line, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown got: %v, want: nil", err)
+	}
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Fatalf("Serve got: %v, want: %v", err, ErrServerClosed)
+	}
+	// The idle keep-alive connection has been closed by the server
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadAll(br); err != nil {
+		t.Fatalf("reading closed connection got: %v", err)
+	}
+	if err := s.ListenAndServe(); err != ErrServerClosed {
+		t.Fatalf("ListenAndServe got: %v, want: %v", err, ErrServerClosed)
+	}
+}
+
+func TestShutdownWaitsForActiveRequest(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})}
+	addr, _ := startServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: test\r\n\r\n")
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- s.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned %v before the active request finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("Shutdown got: %v, want: nil", err)
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(got), "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(string(got), "\r\n\r\ndone") {
+		t.Fatalf("got response: %q", got)
+	}
+}
+
+func TestShutdownContextExpires(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+		close(started)
+		<-release
+	})}
+	addr, _ := startServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /stuck HTTP/1.1\r\nHost: test\r\n\r\n")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown got: %v, want: %v", err, context.DeadlineExceeded)
+	}
+	// Close drops the connection without waiting for the handler
+	s.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("reading closed connection got: %v", err)
+	}
+}
+
+// dialAndSend connects to addr, sends reqText and returns everything
+// the server writes back before closing the connection.
+func dialAndSend(t *testing.T, addr, reqText string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, reqText)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(got)
+}
+
+var statusLinePattern = regexp.MustCompile(`HTTP/1\.1 \d{3} [^\r]*`)
+
+func TestServerTimeoutsAndLimits(t *testing.T) {
+	var tests = []struct {
+		name      string
+		server    *Server
+		reqText   string
+		linesWant []string
+	}{
+		{
+			"IdleTimeout",
+			&Server{DocRoot: "testdata", IdleTimeout: 50 * time.Millisecond},
+			"GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n",
+			[]string{"HTTP/1.1 200 OK"},
+		},
+		{
+			"PartialRequestTimeout",
+			&Server{DocRoot: "testdata", ReadHeaderTimeout: 50 * time.Millisecond},
+			"GET /index.html HTTP/1.1\r\nHost: te",
+			[]string{"HTTP/1.1 408 Request Timeout"},
+		},
+		{
+			"HeaderTooLarge",
+			&Server{DocRoot: "testdata", MaxHeaderBytes: 100},
+			"GET /index.html HTTP/1.1\r\nHost: test\r\nX-Big: " + strings.Repeat("x", 10000) + "\r\n\r\n",
+			[]string{"HTTP/1.1 431 Request Header Fields Too Large"},
+		},
+		{
+			"MaxRequestsPerConn",
+			&Server{DocRoot: "testdata", MaxRequestsPerConn: 2},
+			strings.Repeat("GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n", 3),
+			[]string{"HTTP/1.1 200 OK", "HTTP/1.1 200 OK"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, _ := startServer(t, tt.server)
+			defer tt.server.Close()
+			got := dialAndSend(t, addr, tt.reqText)
+			lines := statusLinePattern.FindAllString(got, -1)
+			if strings.Join(lines, "|") != strings.Join(tt.linesWant, "|") {
+				t.Fatalf("got status lines: %q, want: %q\nresponse: %q", lines, tt.linesWant, got)
+			}
+		})
+	}
+}
+
+func TestServerMaxConns(t *testing.T) {
+	s := &Server{DocRoot: "testdata", MaxConns: 1}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	// Make sure the first connection is being served
+	io.WriteString(first, "GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n")
+	if line, err := ReadLine(bufio.NewReader(first)); err != nil || line != "HTTP/1.1 200 OK" {
+		t.Fatalf("got status line: %q, %v", line, err)
+	}
+
+	got := dialAndSend(t, addr, "GET /index.html HTTP/1.1\r\nHost: test\r\n\r\n")
+	if !strings.HasPrefix(got, "HTTP/1.1 503 Service Unavailable\r\n") {
+		t.Fatalf("got response: %q, want 503", got)
+	}
+}
+
+func TestServerReadTimeout(t *testing.T) {
+	readErr := make(chan error, 1)
+	s := &Server{
+		ReadTimeout: 50 * time.Millisecond,
+		Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+			_, err := io.ReadAll(req.Body)
+			readErr <- err
+		}),
+	}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: test\r\nContent-Length: 10\r\n\r\nhello")
+	select {
+	case err := <-readErr:
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() {
+			t.Fatalf("got body read error: %v, want a timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("body read did not time out")
+	}
+}
+
+func TestServerAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Server{DocRoot: "testdata", AccessLog: NewAccessLogger(&buf, LogCombined)}
+	addr, _ := startServer(t, s)
+	dialAndSend(t, addr, "GET /index.html HTTP/1.1\r\nHost: test\r\nUser-Agent: tester\r\n\r\n"+
+		"HEAD /index.html HTTP/1.1\r\nHost: test\r\n\r\n"+
+		"GET /missing.html HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	dialAndSend(t, addr, "GET\r\n\r\n")
+	s.Close()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	wants := []string{
+		`"GET /index.html HTTP/1.1" 200 12 "-" "tester"`,
+		`"HEAD /index.html HTTP/1.1" 200 - "-" "-"`,
+		`"GET /missing.html HTTP/1.1" 404 `,
+		`"-" 400 `,
+	}
+	if len(lines) != len(wants) {
+		t.Fatalf("got %v access log lines, want %v:\n%v", len(lines), len(wants), buf.String())
+	}
+	for i, want := range wants {
+		if !strings.HasPrefix(lines[i], "127.0.0.1 - - [") || !strings.Contains(lines[i], want) {
+			t.Errorf("line %v got: %q, want it to contain: %q", i, lines[i], want)
+		}
+	}
+}
+
+func TestServerPersistentConnections(t *testing.T) {
+	large := strings.Repeat("x", bufferBeforeChunking+1)
+	mux := NewServeMux()
+	mux.Handle("/index.html", FileServer("testdata"))
+	mux.HandleFunc("/large", func(w ResponseWriter, req *Request) {
+		io.WriteString(w, large)
+	})
+
+	var tests = []struct {
+		name      string
+		reqText   string
+		linesWant []string
+		contains  []string
+		excludes  []string
+	}{
+		{
+			"HTTP10ClosesByDefault",
+			strings.Repeat("GET /index.html HTTP/1.0\r\n\r\n", 2),
+			[]string{"HTTP/1.1 200 OK"},
+			[]string{"Connection: close\r\n"},
+			[]string{"Keep-Alive"},
+		},
+		{
+			"HTTP10KeepAlive",
+			"GET /index.html HTTP/1.0\r\nConnection: keep-alive\r\n\r\n" +
+				"GET /index.html HTTP/1.0\r\nConnection: keep-alive\r\n\r\n" +
+				"GET /index.html HTTP/1.0\r\nConnection: keep-alive\r\n\r\n",
+			[]string{"HTTP/1.1 200 OK", "HTTP/1.1 200 OK", "HTTP/1.1 200 OK"},
+			[]string{
+				"Connection: keep-alive\r\nContent-Length: 12\r\n",
+				"Keep-Alive: timeout=1, max=2\r\n",
+				"Keep-Alive: timeout=1, max=1\r\n",
+				"Connection: close\r\n",
+			},
+			nil,
+		},
+		{
+			"HTTP11KeepAliveToken",
+			"GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: keep-alive, TE\r\n\r\n" +
+				"GET /index.html HTTP/1.1\r\nHost: test\r\nConnection: TE, close\r\n\r\n",
+			[]string{"HTTP/1.1 200 OK", "HTTP/1.1 200 OK"},
+			[]string{"Keep-Alive: timeout=1, max=2\r\n", "Connection: close\r\n"},
+			nil,
+		},
+		{
+			"HTTP10NoChunking",
+			"GET /large HTTP/1.0\r\nConnection: keep-alive\r\n\r\n" +
+				"GET /index.html HTTP/1.0\r\nConnection: keep-alive\r\n\r\n",
+			[]string{"HTTP/1.1 200 OK"},
+			[]string{"Connection: close\r\n", "\r\n\r\n" + large},
+			[]string{"Transfer-Encoding", "Keep-Alive", "Content-Length"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Handler: mux, IdleTimeout: time.Second, MaxRequestsPerConn: 3}
+			addr, _ := startServer(t, s)
+			defer s.Close()
+			got := dialAndSend(t, addr, tt.reqText)
+			lines := statusLinePattern.FindAllString(got, -1)
+			if strings.Join(lines, "|") != strings.Join(tt.linesWant, "|") {
+				t.Fatalf("got status lines: %q, want: %q\nresponse: %q", lines, tt.linesWant, got)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(got, s) {
+					t.Fatalf("got response: %q, want it to contain %q", got, s)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(got, s) {
+					t.Fatalf("got response: %q, want it not to contain %q", got, s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHeartbeat is used when EventSource.Heartbeat is zero.
+	DefaultHeartbeat = 15 * time.Second
+
+	// DefaultEventBufferSize is used when EventSource.BufferSize is zero.
+	DefaultEventBufferSize = 100
+)
+
+// clientEventQueue is how many events may wait for a slow client before
+// it is disconnected. It reconnects and resumes from the buffer.
+const clientEventQueue = 64
+
+// An Event is a message of a Server-Sent Events stream, as defined by
+// the HTML Living Standard, section 9.2.
+type Event struct {
+	// ID is sent as the "id" field. The client sends the last ID it
+	// received in the "Last-Event-ID" header when it reconnects.
+	ID string
+
+	// Event is the type of the event, sent as the "event" field.
+	// The client dispatches events without one as "message".
+	Event string
+
+	// Data is the payload, sent as one "data" field per line.
+	Data string
+
+	// Retry, if positive, tells the client how long to wait before
+	// reconnecting, sent as the "retry" field in milliseconds.
+	Retry time.Duration
+}
+
+// WriteTo writes e to w in the text/event-stream format.
+func (e *Event) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	// 字段值中不能有换行 id中也不能有NUL
+	if e.ID != "" {
+		b.WriteString("id: " + strings.NewReplacer("\r", "", "\n", "", "\x00", "").Replace(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + strings.NewReplacer("\r", "", "\n", "").Replace(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(int64(e.Retry/time.Millisecond), 10) + "\n")
+	}
+	data := strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(e.Data)
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// An EventSource is a Handler streaming the events it publishes to every
+// connected client as text/event-stream, the Server-Sent Events format.
+//
+// Streams stay open past the timeouts of the server, until the client
+// goes away or the EventSource is closed. A comment line is sent every
+// Heartbeat so that idle connections are not dropped by proxies. The
+// last BufferSize events are kept, so that a client reconnecting with
+// "Last-Event-ID" receives the events it missed.
+//
+// The zero value is ready to use.
+type EventSource struct {
+	// Heartbeat is the interval of the comment lines sent to keep
+	// connections open. If zero, DefaultHeartbeat is used.
+	Heartbeat time.Duration
+
+	// BufferSize is how many recent events are kept for clients
+	// resuming a stream. If zero, DefaultEventBufferSize is used.
+	BufferSize int
+
+	// Retry, if positive, is sent to clients when they connect as the
+	// time to wait before reconnecting.
+	Retry time.Duration
+
+	mu      sync.Mutex
+	buf     []Event // ring of the last events
+	start   int     // index of the oldest event in buf
+	nextID  uint64
+	clients map[chan Event]struct{}
+	closed  bool
+}
+
+// Publish sends e to every connected client and keeps it for the ones
+// reconnecting. An event without ID is given the next number. A client
+// too slow to keep up is disconnected.
+func (s *EventSource) Publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.nextID++
+	if e.ID == "" {
+		e.ID = strconv.FormatUint(s.nextID, 10)
+	}
+	size := s.bufferSize()
+	if len(s.buf) < size {
+		s.buf = append(s.buf, e)
+	} else {
+		s.buf[s.start] = e
+		s.start = (s.start + 1) % len(s.buf)
+	}
+	for ch := range s.clients {
+		select {
+		case ch <- e:
+		default:
+			// 客户端跟不上 断开后它会带着Last-Event-ID重连
+			close(ch)
+			delete(s.clients, ch)
+		}
+	}
+}
+
+// Clients returns the number of connected clients.
+func (s *EventSource) Clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// Close ends every stream. Later calls to Publish do nothing, and
+// clients connecting are answered with 503 Service Unavailable.
+func (s *EventSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for ch := range s.clients {
+		close(ch)
+		delete(s.clients, ch)
+	}
+}
+
+// subscribe registers a client resuming after the event lastID, and
+// returns the events it missed along with its channel.
+func (s *EventSource) subscribe(lastID string) ([]Event, chan Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, nil
+	}
+	var missed []Event
+	if lastID != "" {
+		n := len(s.buf)
+		for i := 0; i < n; i++ {
+			if s.buf[(s.start+i)%n].ID == lastID {
+				for j := i + 1; j < n; j++ {
+					missed = append(missed, s.buf[(s.start+j)%n])
+				}
+				break
+			}
+		}
+	}
+	ch := make(chan Event, clientEventQueue)
+	if s.clients == nil {
+		s.clients = make(map[chan Event]struct{})
+	}
+	s.clients[ch] = struct{}{}
+	return missed, ch
+}
+
+// unsubscribe removes the client of ch, unless it was dropped already.
+func (s *EventSource) unsubscribe(ch chan Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[ch]; ok {
+		close(ch)
+		delete(s.clients, ch)
+	}
+}
+
+// ServeHTTP streams the events to the client of req until it goes away.
+func (s *EventSource) ServeHTTP(w ResponseWriter, req *Request) {
+	if req.Method != "GET" {
+		res := &Response{}
+		res.HandleMethodNotAllowed(req)
+		res.Header.Set("Allow", "GET")
+		res.Serve(w)
+		return
+	}
+	missed, ch := s.subscribe(req.Header.Get("Last-Event-ID"))
+	if ch == nil {
+		res := &Response{}
+		res.HandleServiceUnavailable()
+		res.Serve(w)
+		return
+	}
+	defer s.unsubscribe(ch)
+
+	var gone <-chan struct{}
+	if st, ok := w.(interface{ stream() <-chan struct{} }); ok {
+		gone = st.stream()
+	}
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+
+	flusher, _ := w.(Flusher)
+	bw := bufio.NewWriter(w)
+	flush := func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+	if s.Retry > 0 {
+		io.WriteString(bw, "retry: "+strconv.FormatInt(int64(s.Retry/time.Millisecond), 10)+"\n\n")
+	}
+	for i := range missed {
+		missed[i].WriteTo(bw)
+	}
+	if err := flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(s.heartbeat())
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			e.WriteTo(bw)
+			// 一次发送所有已经到达的事件
+			for more := true; more; {
+				select {
+				case e, ok = <-ch:
+					if !ok {
+						flush()
+						return
+					}
+					e.WriteTo(bw)
+				default:
+					more = false
+				}
+			}
+		case <-heartbeat.C:
+			io.WriteString(bw, ":\n\n")
+		case <-gone:
+			return
+		}
+		if err := flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *EventSource) heartbeat() time.Duration {
+	if s.Heartbeat > 0 {
+		return s.Heartbeat
+	}
+	return DefaultHeartbeat
+}
+
+func (s *EventSource) bufferSize() int {
+	if s.BufferSize > 0 {
+		return s.BufferSize
+	}
+	return DefaultEventBufferSize
+}
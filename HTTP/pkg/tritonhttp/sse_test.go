@@ -0,0 +1,176 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventWriteTo(t *testing.T) {
+	var tests = []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"Data", Event{Data: "hello"}, "data: hello\n\n"},
+		{"Empty", Event{}, "data: \n\n"},
+		{
+			"AllFields",
+			Event{ID: "7", Event: "update", Data: "x", Retry: 2500 * time.Millisecond},
+			"id: 7\nevent: update\nretry: 2500\ndata: x\n\n",
+		},
+		{"Multiline", Event{Data: "a\r\nb\rc\nd"}, "data: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{"NewlineInID", Event{ID: "1\n2", Event: "a\nb", Data: "x"}, "id: 12\nevent: ab\ndata: x\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			n, err := tt.event.WriteTo(&b)
+			if err != nil || b.String() != tt.want || n != int64(len(tt.want)) {
+				t.Fatalf("got %q, %v, %v, want %q", b.String(), n, err, tt.want)
+			}
+		})
+	}
+}
+
+// openEventStream requests an event stream from the server at addr and
+// returns the reader of its body.
+func openEventStream(t *testing.T, addr string, lastID string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	return openEventStreamWith(t, addr, lastID, "")
+}
+
+// openEventStreamWith is openEventStream sending body with the request.
+func openEventStreamWith(t *testing.T, addr string, lastID, body string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	reqText := "GET /events HTTP/1.1\r\nHost: test\r\n"
+	if lastID != "" {
+		reqText += "Last-Event-ID: " + lastID + "\r\n"
+	}
+	if body != "" {
+		reqText += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n"
+	}
+	io.WriteString(conn, reqText+"\r\n"+body)
+	br := bufio.NewReader(conn)
+	res, err := readResponseHeader(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 || res.Header.Get("Content-Type") != "text/event-stream; charset=utf-8" ||
+		res.Header.Get("Connection") != "close" {
+		t.Fatalf("got response: %v %v", res.StatusCode, res.Header)
+	}
+	return conn, bufio.NewReader(&chunkedReader{br: br, req: &Request{}})
+}
+
+// readEvent reads the lines of the stream up to the next blank line,
+// skipping heartbeats unless keepHeartbeats.
+func readEvent(t *testing.T, br *bufio.Reader, keepHeartbeats bool) string {
+	t.Helper()
+	var b strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read %q: %v", b.String(), err)
+		}
+		if line != "\n" {
+			b.WriteString(line)
+		} else if keepHeartbeats || b.String() != ":\n" {
+			return b.String()
+		} else {
+			b.Reset()
+		}
+	}
+}
+
+func TestEventSource(t *testing.T) {
+	es := &EventSource{Heartbeat: 100 * time.Millisecond, BufferSize: 3, Retry: time.Second}
+	defer es.Close()
+	mux := NewServeMux()
+	mux.Handle("/events", es)
+	s := &Server{Handler: mux, ReadTimeout: 200 * time.Millisecond, WriteTimeout: 200 * time.Millisecond}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	conn, br := openEventStream(t, addr, "")
+	if got := readEvent(t, br, false); got != "retry: 1000\n" {
+		t.Fatalf("got %q, want the retry field", got)
+	}
+	for es.Clients() != 1 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	es.Publish(Event{Data: "one"})
+	if got := readEvent(t, br, false); got != "id: 1\ndata: one\n" {
+		t.Fatalf("got %q", got)
+	}
+
+	// 超过服务器的超时时间 连接仍然打开 只收到心跳
+	time.Sleep(300 * time.Millisecond)
+	if got := readEvent(t, br, true); got != ":\n" {
+		t.Fatalf("got %q, want a heartbeat", got)
+	}
+	es.Publish(Event{Event: "update", Data: "two"})
+	if got := readEvent(t, br, false); got != "id: 2\nevent: update\ndata: two\n" {
+		t.Fatalf("got %q", got)
+	}
+
+	conn.Close()
+	for i := 0; es.Clients() != 0; i++ {
+		if i == 100 {
+			t.Fatal("client still registered after disconnecting")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Event 1 dropped out of the buffer, 2 to 4 are left
+	es.Publish(Event{Data: "three"})
+	es.Publish(Event{Data: "four"})
+	conn, br = openEventStream(t, addr, "2")
+	defer conn.Close()
+	readEvent(t, br, false) // retry
+	for _, want := range []string{"id: 3\ndata: three\n", "id: 4\ndata: four\n"} {
+		if got := readEvent(t, br, false); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	// Close ends the stream
+	es.Close()
+	if _, err := io.ReadAll(br); err != nil {
+		t.Fatalf("got %v, want the end of the stream", err)
+	}
+}
+
+func TestEventSourceRequestBody(t *testing.T) {
+	// 带body的请求 连接由监视断开的goroutine读取 服务器不能再同时读它
+	es := &EventSource{}
+	defer es.Close()
+	s := &Server{Handler: es}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	conn, br := openEventStreamWith(t, addr, "", strings.Repeat("x", 100))
+	defer conn.Close()
+	for es.Clients() != 1 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	es.Publish(Event{Data: "one"})
+	if got := readEvent(t, br, false); got != "id: 1\ndata: one\n" {
+		t.Fatalf("got %q", got)
+	}
+	es.Close()
+	if _, err := io.ReadAll(br); err != nil {
+		t.Fatalf("got %v, want the end of the stream", err)
+	}
+}
@@ -0,0 +1,235 @@
+package tritonhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCertCheckInterval is used when CertReloader.CheckInterval is zero.
+const DefaultCertCheckInterval = 10 * time.Second
+
+// ListenAndServeTLS listens on the TCP network address s.Addr and then
+// handles requests on incoming TLS connections. See ServeTLS.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeTLS(l, certFile, keyFile)
+}
+
+// ServeTLS accepts incoming connections on l and serves them over TLS,
+// configured by a copy of s.TLSConfig.
+//
+// If certFile and keyFile are given, the certificate is loaded from them
+// and reloaded whenever the files change, unless s.TLSConfig already sets
+// GetCertificate. Otherwise s.TLSConfig must provide the certificates,
+// e.g. through a CertReloader holding one pair per hostname.
+func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	if config.GetCertificate == nil && (certFile != "" || keyFile != "") {
+		r := &CertReloader{ErrorLog: s.errorLog()}
+		if err := r.Add(certFile, keyFile); err != nil {
+			l.Close()
+			return err
+		}
+		config.GetCertificate = r.GetCertificate
+	}
+	if config.GetCertificate == nil && len(config.Certificates) == 0 {
+		l.Close()
+		return errors.New("tritonhttp: ServeTLS needs a certificate")
+	}
+	hasHTTP11 := false
+	for _, p := range config.NextProtos {
+		hasHTTP11 = hasHTTP11 || p == "http/1.1"
+	}
+	if !hasHTTP11 {
+		config.NextProtos = append(config.NextProtos, "http/1.1")
+	}
+	return s.Serve(tls.NewListener(l, config))
+}
+
+// A CertReloader holds certificates loaded from files and picks one for
+// each TLS handshake by the server name the client asks for (SNI).
+// Its GetCertificate method is meant for tls.Config.GetCertificate.
+//
+// The files are checked at most once per CheckInterval, during a
+// handshake, and reloaded when their modification time changes. Open
+// connections keep the certificate they were established with. If a
+// reload fails, e.g. because the files are half written, the previous
+// certificate stays in use.
+type CertReloader struct {
+	// CheckInterval is how often the files are checked for changes.
+	// If zero, DefaultCertCheckInterval is used; if negative, files are
+	// only reloaded by Reload.
+	CheckInterval time.Duration
+
+	// ErrorLog receives the failed reloads. If nil, they are logged to
+	// the default error log.
+	ErrorLog *ErrorLogger
+
+	mu        sync.RWMutex
+	pairs     []*certPair
+	lastCheck time.Time
+}
+
+// certPair is a certificate and the files it is loaded from.
+type certPair struct {
+	certFile, keyFile string
+	cert              *tls.Certificate
+	names             []string // lower case DNS names, possibly wildcards
+	modTime           time.Time
+}
+
+// Add loads the certificate in certFile and its key in keyFile. The
+// certificate is used for the hostnames it is valid for; the first one
+// added is the default for clients not sending SNI or asking for an
+// unknown name.
+func (r *CertReloader) Add(certFile, keyFile string) error {
+	p := &certPair{certFile: certFile, keyFile: keyFile}
+	if err := p.load(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pairs = append(r.pairs, p)
+	if r.lastCheck.IsZero() {
+		r.lastCheck = time.Now()
+	}
+	return nil
+}
+
+// Reload reloads every certificate whose files have changed. It returns
+// the first error met, keeping the previous certificate in that case.
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastCheck = time.Now()
+	var firstErr error
+	for _, p := range r.pairs {
+		if !p.changed() {
+			continue
+		}
+		if err := p.load(); err != nil {
+			r.errorLog().Warnf("reloading certificate %v: %v", p.certFile, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (r *CertReloader) errorLog() *ErrorLogger {
+	if r.ErrorLog != nil {
+		return r.ErrorLog
+	}
+	return defaultErrorLog
+}
+
+// GetCertificate returns the certificate for the server name of hello.
+func (r *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	interval := r.CheckInterval
+	if interval == 0 {
+		interval = DefaultCertCheckInterval
+	}
+	r.mu.RLock()
+	due := interval > 0 && time.Since(r.lastCheck) >= interval
+	r.mu.RUnlock()
+	if due {
+		r.Reload()
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.pairs) == 0 {
+		return nil, errors.New("tritonhttp: no certificate")
+	}
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	for _, p := range r.pairs {
+		if p.matches(name) {
+			return p.cert, nil
+		}
+	}
+	return r.pairs[0].cert, nil
+}
+
+// load reads the certificate files of p.
+func (p *certPair) load() error {
+	modTime, err := p.fileModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+	p.names = p.names[:0]
+	for _, n := range names {
+		p.names = append(p.names, strings.ToLower(n))
+	}
+	p.cert = &cert
+	p.modTime = modTime
+	return nil
+}
+
+// fileModTime returns the latest modification time of the files of p.
+func (p *certPair) fileModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{p.certFile, p.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// changed reports whether the files of p were modified since loaded.
+func (p *certPair) changed() bool {
+	modTime, err := p.fileModTime()
+	return err == nil && !modTime.Equal(p.modTime)
+}
+
+// matches reports whether p is valid for the host name, where
+// "*.example.com" matches one label in place of the "*".
+func (p *certPair) matches(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, n := range p.names {
+		if n == name {
+			return true
+		}
+		if strings.HasPrefix(n, "*.") {
+			if i := strings.IndexByte(name, '.'); i > 0 && name[i:] == n[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert generates a self-signed certificate for names and
+// writes it and its key to dir as PEM files named after prefix.
+func writeTestCert(t *testing.T, dir, prefix string, names ...string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: names[0]},
+		DNSNames:              names,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, prefix+".crt")
+	keyFile = filepath.Join(dir, prefix+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// certPool returns a pool trusting the certificate in certFile.
+func certPool(t *testing.T, certFile string) *x509.CertPool {
+	t.Helper()
+	pemData, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pemData)
+	return pool
+}
+
+// tlsGet sends a GET for /index.html on conn and returns the status line.
+func tlsGet(t *testing.T, conn *tls.Conn, br *bufio.Reader) string {
+	t.Helper()
+	io.WriteString(conn, "GET /index.html HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	line, err := ReadLine(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		// Skip the headers and the 12 bytes of testdata/index.html
+		h, err := ReadLine(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h == "" {
+			io.ReadFull(br, make([]byte, 12))
+			return line
+		}
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "server", "localhost")
+	s := &Server{DocRoot: "testdata"}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeTLS(l, certFile, keyFile)
+	defer s.Close()
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{ServerName: "localhost", RootCAs: certPool(t, certFile)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := conn.ConnectionState().NegotiatedProtocol; got != "" && got != "http/1.1" {
+		t.Fatalf("got protocol: %q", got)
+	}
+	if line := tlsGet(t, conn, bufio.NewReader(conn)); line != "HTTP/1.1 200 OK" {
+		t.Fatalf("got status line: %q", line)
+	}
+}
+
+func TestServeTLSWithoutCertificate(t *testing.T) {
+	s := &Server{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ServeTLS(l, "", ""); err == nil {
+		t.Fatal("got no error")
+	}
+}
+
+func TestCertReloaderSNI(t *testing.T) {
+	dir := t.TempDir()
+	r := &CertReloader{}
+	for _, c := range []struct{ prefix, name string }{{"a", "a.test"}, {"b", "*.b.test"}} {
+		if err := r.Add(writeTestCert(t, dir, c.prefix, c.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var tests = []struct {
+		serverName string
+		want       string
+	}{
+		{"a.test", "a.test"},
+		{"A.TEST.", "a.test"},
+		{"www.b.test", "*.b.test"},
+		{"b.test", "a.test"},
+		{"x.www.b.test", "a.test"},
+		{"", "a.test"},
+	}
+	for _, tt := range tests {
+		cert, err := r.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cert.Leaf.Subject.CommonName; got != tt.want {
+			t.Errorf("server name %q got certificate: %q, want: %q", tt.serverName, got, tt.want)
+		}
+	}
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "server", "localhost")
+	r := &CertReloader{CheckInterval: time.Millisecond}
+	if err := r.Add(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{DocRoot: "testdata", TLSConfig: &tls.Config{GetCertificate: r.GetCertificate}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeTLS(l, "", "")
+	defer s.Close()
+
+	oldPool := certPool(t, certFile)
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{ServerName: "localhost", RootCAs: oldPool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	tlsGet(t, conn, br)
+
+	// Replace the certificate on disk
+	newCert, newKey := writeTestCert(t, dir, "renewed", "localhost")
+	for _, f := range [][2]string{{newCert, certFile}, {newKey, keyFile}} {
+		if err := os.Rename(f[0], f[1]); err != nil {
+			t.Fatal(err)
+		}
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(f[1], future, future)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// New connections get the new certificate
+	newPool := certPool(t, certFile)
+	conn2, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{ServerName: "localhost", RootCAs: newPool})
+	if err != nil {
+		t.Fatalf("dialing after reload: %v", err)
+	}
+	conn2.Close()
+	if _, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{ServerName: "localhost", RootCAs: oldPool}); err == nil {
+		t.Fatal("old certificate still served after reload")
+	}
+
+	// The connection opened before the reload keeps working
+	if line := tlsGet(t, conn, br); line != "HTTP/1.1 200 OK" {
+		t.Fatalf("got status line: %q", line)
+	}
+}
+
+func TestCertReloaderKeepsCertOnError(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "server", "localhost")
+	r := &CertReloader{CheckInterval: -1}
+	if err := r.Add(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := r.GetCertificate(&tls.ClientHelloInfo{})
+	os.WriteFile(certFile, []byte("half written"), 0644)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if err := r.Reload(); err == nil {
+		t.Fatal("got no error reloading a broken certificate")
+	}
+	if after, _ := r.GetCertificate(&tls.ClientHelloInfo{}); after != before {
+		t.Fatal("got a different certificate after a failed reload")
+	}
+}
@@ -2,6 +2,7 @@ package tritonhttp
 
 import (
 	"bufio"
+	"errors"
 	"mime"
 	"net/textproto"
 	"strings"
@@ -29,6 +30,26 @@ func FormatTime(t time.Time) string {
 	return s
 }
 
+// timeFormats are the date formats HTTP recipients must accept:
+// IMF-fixdate, the obsolete RFC 850 format and ANSI C's asctime().
+var timeFormats = []string{
+	"Mon, 02 Jan 2006 15:04:05 GMT",
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// ParseTime parses a date header value such as "If-Modified-Since",
+// trying each of the formats allowed by the HTTP spec.
+func ParseTime(s string) (t time.Time, err error) {
+	for _, layout := range timeFormats {
+		t, err = time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return t, err
+}
+
 // MIMETypeByExtension returns the MIME type associated with the
 // file extension ext. The extension ext should begin with a
 // leading dot, as in ".html". When ext has no associated type,
@@ -59,3 +80,28 @@ func ReadLine(br *bufio.Reader) (string, error) {
 		}
 	}
 }
+
+// errLineTooLong is returned by readLineLimit.
+var errLineTooLong = errors.New("tritonhttp: line too long")
+
+// readLineLimit is ReadLine reading at most limit bytes, line end
+// included, and failing with errLineTooLong beyond them.
+func readLineLimit(br *bufio.Reader, limit int64) (string, error) {
+	var line []byte
+	for {
+		s, err := br.ReadSlice('\n')
+		if int64(len(line)+len(s)) > limit {
+			return "", errLineTooLong
+		}
+		line = append(line, s...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return string(line), err
+		}
+		if n := len(line); n >= 2 && line[n-2] == '\r' {
+			return string(line[:n-2]), nil
+		}
+	}
+}
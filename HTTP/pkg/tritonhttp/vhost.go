@@ -0,0 +1,110 @@
+package tritonhttp
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// A VirtualHost serves the requests whose "Host" header names it.
+// See Server.VirtualHosts.
+type VirtualHost struct {
+	// DocRoot is the directory the host serves static files from,
+	// with the other file settings of the Server.
+	DocRoot string
+
+	// Handler handles the requests of the host. If set, DocRoot is ignored.
+	Handler Handler
+}
+
+// ValidHost reports whether host is a valid "Host" header value: a
+// registered name, an IPv4 address or a bracketed IPv6 address,
+// optionally followed by ":" and a port number.
+func ValidHost(host string) bool {
+	name, port := splitHostPort(host)
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n > 65535 || strings.Trim(port, "0123456789") != "" {
+			return false
+		}
+	}
+	if strings.HasPrefix(name, "[") {
+		return strings.HasSuffix(name, "]") && net.ParseIP(name[1:len(name)-1]) != nil
+	}
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		// reg-name = *( unreserved / pct-encoded / sub-delims ), RFC 3986
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("-._~%!$&'()*+,;=", c) != -1:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// splitHostPort splits host into its name and port, either of which
+// may be empty. Unlike net.SplitHostPort, the port is optional.
+func splitHostPort(host string) (name, port string) {
+	i := strings.LastIndexByte(host, ':')
+	if i == -1 || strings.LastIndexByte(host, ']') > i {
+		return host, ""
+	}
+	return host[:i], host[i+1:]
+}
+
+// virtualHost returns the virtual host req is for: the entry of
+// s.VirtualHosts matching its "Host" header, else the one named by
+// s.DefaultHost, else nil.
+//
+// A name with a port, e.g. "example.com:8080", is preferred over the same
+// name without one, which matches any port. Exact names are preferred
+// over wildcards, and longer wildcards over shorter ones.
+func (s *Server) virtualHost(req *Request) *VirtualHost {
+	if len(s.VirtualHosts) == 0 {
+		return nil
+	}
+	name, port := splitHostPort(strings.ToLower(req.Host))
+	name = strings.TrimSuffix(name, ".")
+	if port == "" {
+		port = "80"
+		if req.TLS != nil {
+			port = "443"
+		}
+	}
+	if name != "" {
+		candidates := []string{name + ":" + port, name}
+		for i := strings.IndexByte(name, '.'); i != -1; {
+			wildcard := "*" + name[i:]
+			candidates = append(candidates, wildcard+":"+port, wildcard)
+			j := strings.IndexByte(name[i+1:], '.')
+			if j == -1 {
+				break
+			}
+			i += 1 + j
+		}
+		for _, c := range candidates {
+			if vh, ok := s.VirtualHosts[c]; ok {
+				return vh
+			}
+		}
+	}
+	return s.VirtualHosts[s.DefaultHost]
+}
+
+// handlerFor returns the Handler for req, taking virtual hosts into account.
+func (s *Server) handlerFor(req *Request) Handler {
+	vh := s.virtualHost(req)
+	if vh == nil {
+		return s.handler()
+	}
+	if vh.Handler != nil {
+		return vh.Handler
+	}
+	f := s.FileHandler()
+	f.DocRoot = vh.DocRoot
+	return f
+}
@@ -0,0 +1,129 @@
+package tritonhttp
+
+import (
+	"crypto/tls"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidHost(t *testing.T) {
+	var tests = []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"Example.COM:8080", true},
+		{"localhost:", true},
+		{"127.0.0.1", true},
+		{"[::1]:80", true},
+		{"", false},
+		{":80", false},
+		{"::1", false},
+		{"exa mple.com", false},
+		{"example.com:http", false},
+		{"example.com:99999", false},
+		{"a:b:c", false},
+		{"[::1", false},
+		{"example.com/path", false},
+		{"user@example.com", false},
+	}
+	for _, tt := range tests {
+		if got := ValidHost(tt.host); got != tt.want {
+			t.Errorf("ValidHost(%q) got: %v, want: %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestVirtualHostLookup(t *testing.T) {
+	hosts := map[string]*VirtualHost{
+		"example.com":       {DocRoot: "example"},
+		"example.com:8080":  {DocRoot: "example-8080"},
+		"*.example.com":     {DocRoot: "wildcard"},
+		"*.api.example.com": {DocRoot: "api-wildcard"},
+		"secure.test:443":   {DocRoot: "secure"},
+		"default.test":      {DocRoot: "default"},
+	}
+	var tests = []struct {
+		host        string
+		tls         bool
+		defaultHost string
+		want        string
+	}{
+		{"example.com", false, "", "example"},
+		{"EXAMPLE.com.", false, "", "example"},
+		{"example.com:8080", false, "", "example-8080"},
+		{"example.com:9090", false, "", "example"},
+		{"www.example.com", false, "", "wildcard"},
+		{"a.b.example.com:8080", false, "", "wildcard"},
+		{"v1.api.example.com", false, "", "api-wildcard"},
+		{"secure.test", true, "", "secure"},
+		{"secure.test", false, "", ""},
+		{"other.test", false, "", ""},
+		{"other.test", false, "default.test", "default"},
+		{"", false, "default.test", "default"},
+	}
+	for _, tt := range tests {
+		s := &Server{VirtualHosts: hosts, DefaultHost: tt.defaultHost}
+		req := &Request{Host: tt.host}
+		if tt.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		got := ""
+		if vh := s.virtualHost(req); vh != nil {
+			got = vh.DocRoot
+		}
+		if got != tt.want {
+			t.Errorf("host %q (TLS %v, default %q) got: %q, want: %q", tt.host, tt.tls, tt.defaultHost, got, tt.want)
+		}
+	}
+}
+
+func TestServerVirtualHosts(t *testing.T) {
+	base := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		os.MkdirAll(filepath.Join(base, name), 0755)
+		if err := os.WriteFile(filepath.Join(base, name, "index.html"), []byte("site "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{
+		DocRoot: "testdata",
+		VirtualHosts: map[string]*VirtualHost{
+			"a.test":   {DocRoot: filepath.Join(base, "a")},
+			"*.b.test": {DocRoot: filepath.Join(base, "b")},
+			"api.test": {Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+				io.WriteString(w, "api "+req.Host)
+			})},
+		},
+	}
+
+	var tests = []struct {
+		name     string
+		reqText  string
+		wantLine string
+		wantBody string
+	}{
+		{"DocRoot", "GET / HTTP/1.1\r\nHost: a.test\r\n", "HTTP/1.1 200 OK", "site a"},
+		{"Wildcard", "GET / HTTP/1.1\r\nHost: www.B.test:8080\r\n", "HTTP/1.1 200 OK", "site b"},
+		{"Handler", "GET / HTTP/1.1\r\nHost: api.test\r\n", "HTTP/1.1 200 OK", "api api.test"},
+		{"Fallback", "GET / HTTP/1.1\r\nHost: unknown.test\r\n", "HTTP/1.1 200 OK", "Hello World\n"},
+		{"HTTP10WithoutHost", "GET / HTTP/1.0\r\n", "HTTP/1.1 200 OK", "Hello World\n"},
+		{"MissingHost", "GET / HTTP/1.1\r\n", "HTTP/1.1 400 Bad Request", ""},
+		{"EmptyHost", "GET / HTTP/1.1\r\nHost:\r\n", "HTTP/1.1 400 Bad Request", ""},
+		{"MalformedHost", "GET / HTTP/1.1\r\nHost: a.test/evil\r\n", "HTTP/1.1 400 Bad Request", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := roundTrip(t, s, tt.reqText+"Connection: close\r\n\r\n")
+			if !strings.HasPrefix(got, tt.wantLine+"\r\n") {
+				t.Fatalf("got response: %q, want status line %q", got, tt.wantLine)
+			}
+			if tt.wantBody != "" && !strings.HasSuffix(got, "\r\n\r\n"+tt.wantBody) {
+				t.Fatalf("got response: %q, want body %q", got, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,530 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// websocketGUID is appended to the "Sec-WebSocket-Key" of the client to
+// compute "Sec-WebSocket-Accept", RFC 6455 section 1.3.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageBytes is used when WebSocket.MaxMessageBytes is zero.
+const DefaultMaxMessageBytes = 1 << 20
+
+// closeTimeout is how long Close waits for the peer to answer
+// the close handshake.
+const closeTimeout = time.Second
+
+// The message types of WebSocket, which are the opcodes of their frames.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// The status codes of a WebSocket close frame, RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005 // never sent: the close frame had no code
+	CloseAbnormal        = 1006 // never sent: the connection was lost
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// ErrWebSocketClosed is returned when writing to a WebSocket after the
+// close frame has been sent.
+var ErrWebSocketClosed = errors.New("tritonhttp: websocket closed")
+
+// A CloseError ends a WebSocket connection. It carries the status code
+// and the reason of the close frame received from the peer, or of the
+// one sent to it when the peer broke the protocol.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("tritonhttp: websocket closed: %v", e.Code)
+	}
+	return fmt.Sprintf("tritonhttp: websocket closed: %v %v", e.Code, e.Reason)
+}
+
+// A WebSocket is a connection speaking the WebSocket protocol of RFC 6455.
+//
+// ReadMessage and Close must be called from a single goroutine, while
+// WriteMessage, NextWriter and Ping may be called from others.
+// Pings are answered automatically while reading.
+type WebSocket struct {
+	// MaxMessageBytes limits the size of the messages read. A longer
+	// message closes the connection with CloseMessageTooBig. If zero,
+	// DefaultMaxMessageBytes is used.
+	MaxMessageBytes int64
+
+	// Subprotocol is the protocol agreed on during the handshake, or "".
+	Subprotocol string
+
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // the frames sent are masked, and the frames read are not
+
+	msgMu     sync.Mutex // held while a data message is written
+	wmu       sync.Mutex // protects the frames written and closeSent
+	closeSent bool
+
+	readErr error // once set, returned by every ReadMessage
+}
+
+// newWebSocket returns a WebSocket speaking over conn, reading through br.
+func newWebSocket(conn net.Conn, br *bufio.Reader, client bool) *WebSocket {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &WebSocket{conn: conn, br: br, client: client}
+}
+
+// UpgradeWebSocket checks that req opens a WebSocket, answers it with
+// 101 Switching Protocols and takes over its connection through the
+// Hijacker interface of w. protocols lists the subprotocols supported by
+// the server, by order of preference; the first one the client offers in
+// "Sec-WebSocket-Protocol" is chosen.
+//
+// A request which is not a valid opening handshake is answered with 400
+// Bad Request, or 426 Upgrade Required for another WebSocket version,
+// and an error is returned. Checking the "Origin" header is left to the
+// caller, before the upgrade.
+func UpgradeWebSocket(w ResponseWriter, req *Request, protocols ...string) (*WebSocket, error) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	var err error
+	switch {
+	case req.Method != "GET" || req.Proto != "HTTP/1.1":
+		err = errors.New("websocket handshake must be an HTTP/1.1 GET request")
+	case !req.Upgrade || !hasToken(req.Header.Join("Upgrade"), "websocket"):
+		err = errors.New("missing Connection: upgrade or Upgrade: websocket")
+	case req.Header.Get("Sec-WebSocket-Version") != "13":
+		res := &Response{StatusCode: 426, Request: req, Header: make(Header)}
+		res.Header.Set("Upgrade", "websocket")
+		res.Header.Set("Sec-WebSocket-Version", "13")
+		res.SetErrorPage(nil, "")
+		res.Serve(w)
+		return nil, fmt.Errorf("tritonhttp: unsupported websocket version %q", req.Header.Get("Sec-WebSocket-Version"))
+	default:
+		if k, kerr := base64.StdEncoding.DecodeString(key); kerr != nil || len(k) != 16 {
+			err = fmt.Errorf("invalid Sec-WebSocket-Key %q", key)
+		}
+	}
+	if err != nil {
+		res := &Response{}
+		res.HandleBadRequest()
+		res.Serve(w)
+		return nil, fmt.Errorf("tritonhttp: %v", err)
+	}
+	hj, ok := w.(Hijacker)
+	if !ok {
+		return nil, errors.New("tritonhttp: response writer cannot be hijacked")
+	}
+
+	subprotocol := ""
+	for _, p := range protocols {
+		if hasToken(req.Header.Join("Sec-WebSocket-Protocol"), p) {
+			subprotocol = p
+			break
+		}
+	}
+	h := w.Header()
+	for k := range h {
+		delete(h, k)
+	}
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", websocketAccept(key))
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	w.WriteHeader(101)
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	ws := newWebSocket(conn, rw.Reader, false)
+	ws.Subprotocol = subprotocol
+	return ws, nil
+}
+
+// websocketAccept returns the "Sec-WebSocket-Accept" answering key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// A WebSocketHandler is a Handler upgrading each request to a WebSocket,
+// which it passes to the function. The connection is closed with
+// CloseNormal once the function returns, unless it was closed already.
+type WebSocketHandler func(ws *WebSocket, req *Request)
+
+// ServeHTTP upgrades req and calls h.
+func (h WebSocketHandler) ServeHTTP(w ResponseWriter, req *Request) {
+	ws, err := UpgradeWebSocket(w, req)
+	if err != nil {
+		req.errorLog().Infof("%v: %v", req.RemoteAddr, err)
+		return
+	}
+	defer ws.Close(CloseNormal, "")
+	h(ws, req)
+}
+
+// ReadMessage returns the next data message, TextMessage or
+// BinaryMessage, reassembled from its fragments. Pings are answered and
+// pongs dropped on the way.
+//
+// When the peer closes the connection, its close frame is echoed and
+// the error is a *CloseError with its status code. A peer breaking the
+// protocol, e.g. with unmasked frames or invalid UTF-8 in a text
+// message, is sent a close frame with the matching code, which is also
+// returned as a *CloseError.
+func (ws *WebSocket) ReadMessage() (messageType int, p []byte, err error) {
+	if ws.readErr != nil {
+		return 0, nil, ws.readErr
+	}
+	messageType = -1
+	var msg []byte
+	for {
+		fin, opcode, payload, err := ws.readFrame(ws.maxMessageBytes() - int64(len(msg)))
+		if err != nil {
+			return 0, nil, ws.fail(err)
+		}
+		switch opcode {
+		case PingMessage:
+			if err := ws.writeFrame(PongMessage, true, payload); err != nil && err != ErrWebSocketClosed {
+				return 0, nil, ws.fail(err)
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			return 0, nil, ws.closed(payload)
+		case TextMessage, BinaryMessage:
+			if messageType != -1 {
+				return 0, nil, ws.fail(&CloseError{CloseProtocolError, "new message inside a fragmented message"})
+			}
+			messageType = opcode
+			msg = payload
+		case continuationFrame:
+			if messageType == -1 {
+				return 0, nil, ws.fail(&CloseError{CloseProtocolError, "continuation frame without a message"})
+			}
+			msg = append(msg, payload...)
+		default:
+			return 0, nil, ws.fail(&CloseError{CloseProtocolError, fmt.Sprintf("unknown opcode %v", opcode)})
+		}
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(msg) {
+				return 0, nil, ws.fail(&CloseError{CloseInvalidPayload, "invalid UTF-8 in text message"})
+			}
+			return messageType, msg, nil
+		}
+	}
+}
+
+// readFrame reads a frame whose payload may be up to limit bytes long,
+// RFC 6455 section 5.2. The payload is returned unmasked.
+func (ws *WebSocket) readFrame(limit int64) (fin bool, opcode int, payload []byte, err error) {
+	var h [8]byte
+	if _, err := io.ReadFull(ws.br, h[:2]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = h[0]&0x80 != 0
+	opcode = int(h[0] & 0x0f)
+	masked := h[1]&0x80 != 0
+	n := uint64(h[1] & 0x7f)
+	// 没有协商扩展 保留位必须为0
+	if h[0]&0x70 != 0 {
+		return false, 0, nil, &CloseError{CloseProtocolError, "reserved bits set"}
+	}
+	switch n {
+	case 126:
+		if _, err := io.ReadFull(ws.br, h[:2]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(h[:2]))
+	case 127:
+		if _, err := io.ReadFull(ws.br, h[:8]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(h[:8])
+		if n>>63 != 0 {
+			return false, 0, nil, &CloseError{CloseProtocolError, "invalid payload length"}
+		}
+	}
+	if opcode >= 8 && (!fin || n > 125) {
+		return false, 0, nil, &CloseError{CloseProtocolError, "fragmented or oversized control frame"}
+	}
+	// 客户端发送的帧必须加掩码 服务器发送的帧不能加
+	if masked == ws.client {
+		return false, 0, nil, &CloseError{CloseProtocolError, "wrong frame masking"}
+	}
+	if opcode < 8 && n > uint64(limit) {
+		return false, 0, nil, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(ws.br, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		maskBytes(key, payload)
+	}
+	return fin, opcode, payload, nil
+}
+
+// maskBytes masks or unmasks p with key, RFC 6455 section 5.3.
+func maskBytes(key [4]byte, p []byte) {
+	for i := range p {
+		p[i] ^= key[i%4]
+	}
+}
+
+// closed handles the close frame received with payload: the frame is
+// echoed, unless Close already sent one, and the connection is closed.
+func (ws *WebSocket) closed(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return ws.fail(&CloseError{CloseProtocolError, "invalid close frame"})
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Reason = string(payload[2:])
+		if !validCloseCode(ce.Code) {
+			return ws.fail(&CloseError{CloseProtocolError, fmt.Sprintf("invalid close code %v", ce.Code)})
+		}
+		if !utf8.ValidString(ce.Reason) {
+			return ws.fail(&CloseError{CloseInvalidPayload, "invalid UTF-8 in close reason"})
+		}
+	}
+	code := ce.Code
+	if code == CloseNoStatus {
+		code = CloseNormal
+	}
+	ws.writeClose(code, "")
+	ws.conn.Close()
+	ws.readErr = ce
+	return ce
+}
+
+// validCloseCode reports whether code may be sent in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999: // 注册给库和应用使用
+		return true
+	}
+	return false
+}
+
+// fail ends the connection after err. A *CloseError is sent to the peer
+// in a close frame; other errors mean the connection was lost.
+func (ws *WebSocket) fail(err error) error {
+	ce, ok := err.(*CloseError)
+	if ok {
+		ws.writeClose(ce.Code, ce.Reason)
+	} else {
+		ce = &CloseError{CloseAbnormal, err.Error()}
+	}
+	ws.conn.Close()
+	ws.readErr = ce
+	return ce
+}
+
+// WriteMessage sends data as a single frame of messageType, TextMessage
+// or BinaryMessage. A text message must be valid UTF-8.
+func (ws *WebSocket) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("tritonhttp: invalid websocket message type %v", messageType)
+	}
+	if messageType == TextMessage && !utf8.Valid(data) {
+		return errors.New("tritonhttp: invalid UTF-8 in websocket text message")
+	}
+	ws.msgMu.Lock()
+	defer ws.msgMu.Unlock()
+	return ws.writeFrame(messageType, true, data)
+}
+
+// NextWriter returns a writer sending a message of messageType in
+// fragments: every Write sends one frame, and Close the final one.
+// Other messages wait until the writer is closed; control frames may
+// be sent in between.
+func (ws *WebSocket) NextWriter(messageType int) (io.WriteCloser, error) {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return nil, fmt.Errorf("tritonhttp: invalid websocket message type %v", messageType)
+	}
+	ws.msgMu.Lock()
+	return &messageWriter{ws: ws, opcode: messageType}, nil
+}
+
+// messageWriter is the writer returned by NextWriter.
+type messageWriter struct {
+	ws     *WebSocket
+	opcode int // of the next frame
+	closed bool
+}
+
+func (mw *messageWriter) Write(p []byte) (int, error) {
+	if mw.closed {
+		return 0, ErrWebSocketClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := mw.ws.writeFrame(mw.opcode, false, p); err != nil {
+		return 0, err
+	}
+	mw.opcode = continuationFrame
+	return len(p), nil
+}
+
+func (mw *messageWriter) Close() error {
+	if mw.closed {
+		return nil
+	}
+	mw.closed = true
+	defer mw.ws.msgMu.Unlock()
+	return mw.ws.writeFrame(mw.opcode, true, nil)
+}
+
+// Ping sends a ping frame carrying up to 125 bytes of data.
+func (ws *WebSocket) Ping(data []byte) error {
+	if len(data) > 125 {
+		return errors.New("tritonhttp: websocket ping data too long")
+	}
+	return ws.writeFrame(PingMessage, true, data)
+}
+
+// Close starts the close handshake with code and reason, waits for the
+// peer to answer, discarding the messages still on their way, and closes
+// the connection. It does nothing if the connection is closed already.
+func (ws *WebSocket) Close(code int, reason string) error {
+	if ws.readErr != nil {
+		return nil
+	}
+	if err := ws.writeClose(code, reason); err != nil {
+		ws.conn.Close()
+		ws.readErr = ErrWebSocketClosed
+		return err
+	}
+	ws.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			break
+		}
+	}
+	ws.conn.Close()
+	return nil
+}
+
+// writeClose sends a close frame, unless one has been sent already.
+func (ws *WebSocket) writeClose(code int, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+		for !utf8.ValidString(reason) {
+			reason = reason[:len(reason)-1]
+		}
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	err := ws.writeFrame(CloseMessage, true, payload)
+	if err == ErrWebSocketClosed {
+		return nil
+	}
+	return err
+}
+
+// writeFrame sends a frame, masked if ws is a client.
+func (ws *WebSocket) writeFrame(opcode int, fin bool, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+	frame := make([]byte, 0, 14+len(payload))
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	frame = append(frame, b0)
+	var maskBit byte
+	if ws.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(n))
+		frame = append(frame, maskBit|127)
+		frame = append(frame, size[:]...)
+	}
+	if ws.client {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		frame = append(frame, key[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		maskBytes(key, frame[start:])
+	} else {
+		frame = append(frame, payload...)
+	}
+	if opcode == CloseMessage {
+		ws.closeSent = true
+	}
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+func (ws *WebSocket) maxMessageBytes() int64 {
+	if ws.MaxMessageBytes > 0 {
+		return ws.MaxMessageBytes
+	}
+	return DefaultMaxMessageBytes
+}
+
+// SetReadDeadline sets the deadline of ReadMessage on the connection.
+func (ws *WebSocket) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
+}
+
+// RemoteAddr returns the network address of the peer.
+func (ws *WebSocket) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
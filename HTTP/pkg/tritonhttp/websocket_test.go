@@ -0,0 +1,217 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialWebSocket opens a WebSocket to the server at addr, checking the
+// handshake of the server.
+func dialWebSocket(t *testing.T, addr string) *WebSocket {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err := readResponseHeader(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The accept value of the example of RFC 6455 section 1.3
+	if res.StatusCode != 101 || res.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" ||
+		res.Header.Get("Upgrade") != "websocket" || res.Header.Get("Connection") != "Upgrade" {
+		t.Fatalf("got handshake: %v %v", res.StatusCode, res.Header)
+	}
+	return newWebSocket(conn, br, true)
+}
+
+// startEchoServer starts a server echoing WebSocket messages of up to
+// 64 bytes. The error ending each connection is sent to the channel.
+func startEchoServer(t *testing.T) (string, *Server, chan error) {
+	t.Helper()
+	errs := make(chan error, 1)
+	mux := NewServeMux()
+	mux.Handle("/ws", WebSocketHandler(func(ws *WebSocket, req *Request) {
+		ws.MaxMessageBytes = 64
+		for {
+			typ, p, err := ws.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := ws.WriteMessage(typ, p); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}))
+	s := &Server{Handler: mux}
+	addr, _ := startServer(t, s)
+	return addr, s, errs
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	addr, s, errs := startEchoServer(t)
+	defer s.Close()
+	ws := dialWebSocket(t, addr)
+
+	if err := ws.WriteMessage(TextMessage, []byte("héllo")); err != nil {
+		t.Fatal(err)
+	}
+	if typ, p, err := ws.ReadMessage(); err != nil || typ != TextMessage || string(p) != "héllo" {
+		t.Fatalf("got: %v %q %v", typ, p, err)
+	}
+
+	// A fragmented message, with a ping in the middle
+	mw, err := ws.NextWriter(BinaryMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(mw, "frag")
+	if err := ws.Ping([]byte("are you there")); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(mw, "mented")
+	mw.Close()
+	fin, opcode, payload, err := ws.readFrame(125)
+	if err != nil || !fin || opcode != PongMessage || string(payload) != "are you there" {
+		t.Fatalf("got frame: %v %v %q %v, want pong", fin, opcode, payload, err)
+	}
+	if typ, p, err := ws.ReadMessage(); err != nil || typ != BinaryMessage || string(p) != "fragmented" {
+		t.Fatalf("got: %v %q %v", typ, p, err)
+	}
+
+	// Close handshake
+	if err := ws.Close(CloseGoingAway, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	var ce *CloseError
+	if err := <-errs; !errors.As(err, &ce) || ce.Code != CloseGoingAway || ce.Reason != "bye" {
+		t.Fatalf("server got: %v", err)
+	}
+	if _, _, err := ws.ReadMessage(); !errors.As(err, &ce) || ce.Code != CloseGoingAway {
+		t.Fatalf("client got: %v", err)
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	var tests = []struct {
+		name     string
+		send     func(ws *WebSocket)
+		codeWant int
+	}{
+		{
+			"InvalidUTF8",
+			func(ws *WebSocket) { ws.writeFrame(TextMessage, true, []byte{0xff, 0xfe}) },
+			CloseInvalidPayload,
+		},
+		{
+			"Unmasked",
+			func(ws *WebSocket) {
+				ws.client = false
+				ws.writeFrame(BinaryMessage, true, []byte("x"))
+				ws.client = true
+			},
+			CloseProtocolError,
+		},
+		{
+			"FragmentedPing",
+			func(ws *WebSocket) { ws.writeFrame(PingMessage, false, []byte("x")) },
+			CloseProtocolError,
+		},
+		{
+			"UnexpectedContinuation",
+			func(ws *WebSocket) { ws.writeFrame(continuationFrame, true, []byte("x")) },
+			CloseProtocolError,
+		},
+		{
+			"TooBig",
+			func(ws *WebSocket) {
+				ws.writeFrame(TextMessage, false, []byte(strings.Repeat("x", 40)))
+				ws.writeFrame(continuationFrame, true, []byte(strings.Repeat("x", 40)))
+			},
+			CloseMessageTooBig,
+		},
+		{
+			"InvalidCloseCode",
+			func(ws *WebSocket) { ws.writeFrame(CloseMessage, true, []byte{0x03, 0xed}) }, // 1005
+			CloseProtocolError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, s, errs := startEchoServer(t)
+			defer s.Close()
+			ws := dialWebSocket(t, addr)
+			defer ws.conn.Close()
+			tt.send(ws)
+			var ce *CloseError
+			if _, _, err := ws.ReadMessage(); !errors.As(err, &ce) || ce.Code != tt.codeWant {
+				t.Fatalf("client got: %v, want close code %v", err, tt.codeWant)
+			}
+			if err := <-errs; !errors.As(err, &ce) || ce.Code != tt.codeWant {
+				t.Fatalf("server got: %v, want close code %v", err, tt.codeWant)
+			}
+		})
+	}
+}
+
+func TestWebSocketBadHandshake(t *testing.T) {
+	handler := WebSocketHandler(func(ws *WebSocket, req *Request) {
+		t.Error("upgraded a bad handshake")
+	})
+	var tests = []struct {
+		name     string
+		reqText  string
+		wantLine string
+		contains string
+	}{
+		{
+			"Post",
+			"POST / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n",
+			"HTTP/1.1 400 Bad Request",
+			"",
+		},
+		{
+			"NoUpgradeToken",
+			"GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\n" +
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n",
+			"HTTP/1.1 400 Bad Request",
+			"",
+		},
+		{
+			"ShortKey",
+			"GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+				"Sec-WebSocket-Key: c2hvcnQ=\r\nSec-WebSocket-Version: 13\r\n\r\n",
+			"HTTP/1.1 400 Bad Request",
+			"",
+		},
+		{
+			"OldVersion",
+			"GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n\r\n",
+			"HTTP/1.1 426 Upgrade Required",
+			"Sec-Websocket-Version: 13\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := roundTrip(t, &Server{Handler: handler}, tt.reqText+"GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+			if !strings.HasPrefix(got, tt.wantLine+"\r\n") || !strings.Contains(got, tt.contains) {
+				t.Fatalf("got: %q, want %q with %q", got, tt.wantLine, tt.contains)
+			}
+		})
+	}
+}
@@ -0,0 +1,444 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBodyNotAllowed is returned by ResponseWriter.Write when the status
+// code or the request method does not permit a body.
+var ErrBodyNotAllowed = errors.New("tritonhttp: request method or response status code does not allow body")
+
+// ErrContentLength is returned by ResponseWriter.Write when a handler
+// writes more bytes than it declared in the "Content-Length" header.
+var ErrContentLength = errors.New("tritonhttp: wrote more than the declared Content-Length")
+
+// ErrHijacked is returned by ResponseWriter.Write once the connection
+// has been taken over with Hijack.
+var ErrHijacked = errors.New("tritonhttp: connection has been hijacked")
+
+// bufferBeforeChunking is how much of a body of unknown length is
+// buffered before the response falls back to chunked encoding.
+const bufferBeforeChunking = 4096
+
+// The Flusher interface is implemented by ResponseWriters that allow
+// a handler to send buffered data to the client.
+//
+// Flushing a response without "Content-Length" switches it to
+// "Transfer-Encoding: chunked".
+type Flusher interface {
+	Flush()
+}
+
+// The Hijacker interface is implemented by ResponseWriters that let a
+// handler take over the connection, e.g. to switch to WebSocket after
+// a 101 Switching Protocols response.
+//
+// Hijack flushes what has been written so far, clears the deadlines of
+// the connection and returns it, along with a reader holding any data
+// the client already sent past the request. The server forgets the
+// connection: the handler must close it, and Shutdown does not wait
+// for it.
+type Hijacker interface {
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+// serverConn is the connection a request was read from, with the
+// reader the server reads requests through.
+type serverConn struct {
+	srv      *Server
+	conn     net.Conn
+	br       *bufio.Reader
+	hijacked bool
+}
+
+// responseWriter is the ResponseWriter the server hands to handlers.
+// It writes the response to the buffered writer of the connection.
+//
+// When the handler declares "Content-Length", the status line and headers
+// are sent right away and the body is streamed. Otherwise the body is
+// buffered: if the handler returns before bufferBeforeChunking bytes are
+// written, "Content-Length" is set from the buffer, else the response is
+// sent with chunked encoding.
+//
+// Trailers are declared by listing their names in the "Trailer" header
+// before WriteHeader; their values are taken from Header() once the
+// handler returns, and are sent after the last chunk.
+//
+// HTTP/1.0 clients do not understand chunked encoding: a long body of
+// unknown length is sent as is instead, ended by closing the connection,
+// and trailers are sent as ordinary headers.
+type responseWriter struct {
+	bw  *bufio.Writer
+	req *Request
+
+	header      Header
+	status      int
+	wroteHeader bool // WriteHeader has been called
+	headerSent  bool // status line and headers have been written to bw
+	hijacked    bool // the handler has taken over the connection
+	chunked     bool // body is sent with chunked encoding
+	closeAfter  bool // body is ended by closing the connection
+
+	// keepAlive is the value of the "Keep-Alive" header sent when the
+	// client asked for a persistent connection, e.g. "timeout=5, max=99".
+	keepAlive string
+
+	contentLength int64 // declared by the handler, or -1 if unknown
+	written       int64 // body bytes written by the handler
+	body          bytes.Buffer
+
+	// streamDone is closed when the goroutine started by stream, which
+	// reads the connection, has returned. It is nil without one.
+	streamDone chan struct{}
+}
+
+func newResponseWriter(bw *bufio.Writer, req *Request) *responseWriter {
+	return &responseWriter{
+		bw:            bw,
+		req:           req,
+		header:        make(Header),
+		contentLength: -1,
+	}
+}
+
+// Header returns the header map that will be sent by WriteHeader.
+func (w *responseWriter) Header() Header {
+	return w.header
+}
+
+// WriteHeader records statusCode and sends the headers when possible.
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.hijacked {
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = statusCode
+	if !w.header.Has("Date") {
+		w.header.Set("Date", FormatTime(time.Now()))
+	}
+	if statusCode == 101 {
+		// 切换协议时 "Connection: Upgrade" 由handler设置
+	} else if w.req != nil && w.req.Close {
+		w.header.Set("Connection", "close")
+	} else if w.req != nil && w.req.KeepAlive && !w.shouldClose() {
+		w.header.Set("Connection", "keep-alive")
+		if w.keepAlive != "" {
+			w.header.Set("Keep-Alive", w.keepAlive)
+		}
+	}
+	if !bodyAllowedForStatus(statusCode) {
+		w.header.Del("Content-Length")
+		w.header.Del("Trailer")
+		w.sendHeader()
+		return
+	}
+	if w.header.Has("Trailer") && !w.canChunk() {
+		// 不能分块时 trailer的值只能随头部一起发送
+		w.header.Del("Trailer")
+	}
+	if w.header.Has("Trailer") {
+		// Trailers can only follow a chunked body
+		w.header.Del("Content-Length")
+		return
+	}
+	if w.header.Has("Content-Length") {
+		if n, err := strconv.ParseInt(w.header.Get("Content-Length"), 10, 64); err == nil && n >= 0 {
+			w.header.Set("Content-Length", strconv.FormatInt(n, 10))
+			w.contentLength = n
+			w.sendHeader()
+		} else {
+			w.header.Del("Content-Length")
+		}
+	}
+}
+
+// Write writes p as part of the response body.
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if !bodyAllowedForStatus(w.status) {
+		return 0, ErrBodyNotAllowed
+	}
+	if w.contentLength != -1 && w.written+int64(len(p)) > w.contentLength {
+		return 0, ErrContentLength
+	}
+	w.written += int64(len(p))
+	if w.req != nil && w.req.Method == "HEAD" {
+		// HEAD gets the same headers as GET, so the body is counted but discarded
+		return len(p), nil
+	}
+	if w.headerSent {
+		if w.chunked {
+			return w.writeChunk(p)
+		}
+		return w.bw.Write(p)
+	}
+	w.body.Write(p)
+	if w.body.Len() > bufferBeforeChunking {
+		if err := w.startChunking(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends the headers and any buffered body to the client.
+func (w *responseWriter) Flush() {
+	if w.hijacked {
+		return
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if !w.headerSent && !(w.req != nil && w.req.Method == "HEAD") {
+		w.startChunking()
+	}
+	w.bw.Flush()
+}
+
+// Hijack implements the Hijacker interface.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.req == nil || w.req.conn == nil {
+		return nil, nil, errors.New("tritonhttp: connection cannot be hijacked")
+	}
+	if w.wroteHeader && !w.headerSent {
+		if err := w.sendHeader(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := w.bw.Flush(); err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	sc := w.req.conn
+	sc.hijacked = true
+	sc.srv.untrackConn(sc.conn)
+	sc.conn.SetDeadline(time.Time{})
+	return sc.conn, bufio.NewReadWriter(sc.br, w.bw), nil
+}
+
+// stream prepares w for a response outliving the timeouts of the server,
+// such as an event stream: the deadlines of the connection are cleared
+// and the connection is closed after the response. The returned channel
+// is closed when the client goes away, or is nil if that cannot be told.
+func (w *responseWriter) stream() <-chan struct{} {
+	if w.req == nil || w.req.conn == nil || w.hijacked {
+		return nil
+	}
+	if w.streamDone != nil {
+		return w.streamDone
+	}
+	sc := w.req.conn
+	w.req.Close = true
+	sc.conn.SetDeadline(time.Time{})
+	done := make(chan struct{})
+	w.streamDone = done
+	go func() {
+		// 客户端不会再发送数据 读到EOF或者错误说明连接已经断开
+		io.Copy(io.Discard, sc.br)
+		close(done)
+	}()
+	return done
+}
+
+// endStream stops the goroutine started by stream and waits for it to
+// return, so that the connection can be read again.
+func (w *responseWriter) endStream() {
+	if w.streamDone == nil {
+		return
+	}
+	w.req.conn.conn.SetReadDeadline(time.Unix(1, 0))
+	<-w.streamDone
+}
+
+// finish completes the response after the handler has returned
+// and flushes it to the connection.
+func (w *responseWriter) finish() error {
+	if w.hijacked {
+		return nil
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if !w.headerSent {
+		if w.header.Has("Trailer") && w.req != nil && w.req.Method != "HEAD" {
+			if err := w.startChunking(); err != nil {
+				return err
+			}
+		} else {
+			w.header.Set("Content-Length", strconv.FormatInt(w.written, 10))
+			if err := w.sendHeader(); err != nil {
+				return err
+			}
+			if _, err := w.bw.Write(w.body.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	if w.chunked {
+		if err := w.writeTrailer(); err != nil {
+			return err
+		}
+	}
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	if w.contentLength != -1 && w.written < w.contentLength &&
+		bodyAllowedForStatus(w.status) && !(w.req != nil && w.req.Method == "HEAD") {
+		// body比声明的短 客户端会把下一个响应当成body的一部分 只能关闭连接
+		w.closeAfter = true
+		return fmt.Errorf("tritonhttp: wrote %v of the %v bytes declared by Content-Length", w.written, w.contentLength)
+	}
+	return nil
+}
+
+// startChunking sends the headers announcing a chunked body,
+// followed by what has been buffered so far. For a client which cannot
+// receive chunks, the body is sent as is and the connection closed
+// after it.
+func (w *responseWriter) startChunking() error {
+	w.header.Del("Content-Length")
+	if !w.canChunk() {
+		w.closeAfter = true
+		w.header.Set("Connection", "close")
+		w.header.Del("Keep-Alive")
+		if err := w.sendHeader(); err != nil {
+			return err
+		}
+		_, err := w.bw.Write(w.body.Bytes())
+		w.body.Reset()
+		return err
+	}
+	w.chunked = true
+	w.header.Set("Transfer-Encoding", "chunked")
+	if err := w.sendHeader(); err != nil {
+		return err
+	}
+	_, err := w.writeChunk(w.body.Bytes())
+	w.body.Reset()
+	return err
+}
+
+// canChunk reports whether the client understands chunked encoding,
+// which HTTP/1.0 predates.
+func (w *responseWriter) canChunk() bool {
+	return w.req == nil || w.req.Proto != "HTTP/1.0"
+}
+
+// writeChunk writes p as a single chunk. Empty chunks are skipped,
+// as a zero-size chunk marks the end of the body.
+func (w *responseWriter) writeChunk(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(w.bw, "%x"+CRLF, len(p)); err != nil {
+		return 0, err
+	}
+	n, err := w.bw.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = w.bw.WriteString(CRLF)
+	return n, err
+}
+
+// writeTrailer writes the last chunk and the declared trailer fields.
+func (w *responseWriter) writeTrailer() error {
+	trailer := make(Header)
+	for _, k := range w.trailerKeys() {
+		if v, ok := w.header[k]; ok {
+			trailer[k] = v
+		}
+	}
+	if _, err := w.bw.WriteString("0" + CRLF); err != nil {
+		return err
+	}
+	if err := trailer.Write(w.bw); err != nil {
+		return err
+	}
+	_, err := w.bw.WriteString(CRLF)
+	return err
+}
+
+// trailerKeys returns the canonical field names listed in the "Trailer" header.
+func (w *responseWriter) trailerKeys() []string {
+	var keys []string
+	for _, k := range strings.Split(w.header.Join("Trailer"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, CanonicalHeaderKey(k))
+		}
+	}
+	return keys
+}
+
+// reset discards the response prepared so far.
+// It must not be called once the headers have been sent.
+func (w *responseWriter) reset() {
+	w.header = make(Header)
+	w.status = 0
+	w.wroteHeader = false
+	w.chunked = false
+	w.closeAfter = false
+	w.contentLength = -1
+	w.written = 0
+	w.body.Reset()
+}
+
+// sendHeader writes the status line and headers to bw.
+// Fields declared as trailers are held back.
+func (w *responseWriter) sendHeader() error {
+	w.headerSent = true
+	header := w.header
+	if keys := w.trailerKeys(); len(keys) > 0 {
+		header = w.header.Clone()
+		for _, k := range keys {
+			header.Del(k)
+		}
+	}
+	res := &Response{
+		StatusCode: w.status,
+		Proto:      "HTTP/1.1",
+		Header:     header,
+		Request:    w.req,
+	}
+	if err := res.WriteStatusLine(w.bw); err != nil {
+		return err
+	}
+	return res.WriteSortedHeaders(w.bw)
+}
+
+// shouldClose reports whether the connection should be closed
+// once this response has been written.
+func (w *responseWriter) shouldClose() bool {
+	return (w.req != nil && w.req.Close) || w.closeAfter || hasToken(w.header.Join("Connection"), "close")
+}
+
+// bodyAllowedForStatus reports whether a response with the given
+// status code may carry a body.
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == 204, status == 304:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// writeResponse runs handler against a responseWriter for req
+// and returns the raw response bytes.
+func writeResponse(t *testing.T, req *Request, handler HandlerFunc) string {
+	t.Helper()
+	var buffer bytes.Buffer
+	w := newResponseWriter(bufio.NewWriter(&buffer), req)
+	handler(w, req)
+	if err := w.finish(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.String()
+}
+
+func TestResponseWriterFraming(t *testing.T) {
+	large := strings.Repeat("x", bufferBeforeChunking+1)
+	var tests = []struct {
+		name     string
+		method   string
+		handler  HandlerFunc
+		contains []string
+		excludes []string
+		bodyWant string
+	}{
+		{
+			"SmallUnknownLength",
+			"GET",
+			func(w ResponseWriter, req *Request) {
+				io.WriteString(w, "hello")
+			},
+			[]string{"Content-Length: 5\r\n"},
+			[]string{"Transfer-Encoding"},
+			"hello",
+		},
+		{
+			"DeclaredLength",
+			"GET",
+			func(w ResponseWriter, req *Request) {
+				w.Header().Set("Content-Length", "5")
+				io.WriteString(w, "hello")
+			},
+			[]string{"Content-Length: 5\r\n"},
+			[]string{"Transfer-Encoding"},
+			"hello",
+		},
+		{
+			"LargeUnknownLength",
+			"GET",
+			func(w ResponseWriter, req *Request) {
+				io.WriteString(w, large)
+			},
+			[]string{"Transfer-Encoding: chunked\r\n"},
+			[]string{"Content-Length"},
+			large,
+		},
+		{
+			"Flush",
+			"GET",
+			func(w ResponseWriter, req *Request) {
+				io.WriteString(w, "hello")
+				w.(Flusher).Flush()
+				io.WriteString(w, " world")
+			},
+			[]string{"Transfer-Encoding: chunked\r\n", "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"},
+			[]string{"Content-Length"},
+			"hello world",
+		},
+		{
+			"Trailer",
+			"GET",
+			func(w ResponseWriter, req *Request) {
+				w.Header().Set("Trailer", "Checksum")
+				io.WriteString(w, "hello")
+				w.Header().Set("Checksum", "abc")
+			},
+			[]string{"Transfer-Encoding: chunked\r\n", "0\r\nChecksum: abc\r\n\r\n"},
+			[]string{"Content-Length", "\r\nChecksum: abc\r\n5\r\n"},
+			"hello",
+		},
+		{
+			"Head",
+			"HEAD",
+			func(w ResponseWriter, req *Request) {
+				io.WriteString(w, "hello")
+			},
+			[]string{"Content-Length: 5\r\n"},
+			[]string{"hello"},
+			"",
+		},
+		{
+			"NoContent",
+			"GET",
+			func(w ResponseWriter, req *Request) {
+				w.WriteHeader(204)
+				if _, err := io.WriteString(w, "hello"); err != ErrBodyNotAllowed {
+					t.Errorf("got error: %v, want: %v", err, ErrBodyNotAllowed)
+				}
+			},
+			nil,
+			[]string{"Content-Length", "Transfer-Encoding"},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Method: tt.method, URL: "/", Proto: "HTTP/1.1"}
+			got := writeResponse(t, req, tt.handler)
+			for _, s := range tt.contains {
+				if !strings.Contains(got, s) {
+					t.Fatalf("got response: %q, want it to contain %q", got, s)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(got, s) {
+					t.Fatalf("got response: %q, want it not to contain %q", got, s)
+				}
+			}
+			i := strings.Index(got, "\r\n\r\n")
+			body := got[i+4:]
+			if strings.Contains(got[:i], "Transfer-Encoding: chunked") {
+				decoded, err := io.ReadAll(&chunkedReader{br: bufio.NewReader(strings.NewReader(body)), req: &Request{}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = string(decoded)
+			}
+			if body != tt.bodyWant {
+				t.Fatalf("body got %v bytes, want %v bytes", len(body), len(tt.bodyWant))
+			}
+		})
+	}
+}
+
+func TestResponseWriterTooLong(t *testing.T) {
+	writeResponse(t, &Request{Method: "GET"}, func(w ResponseWriter, req *Request) {
+		w.Header().Set("Content-Length", "2")
+		if _, err := io.WriteString(w, "hello"); err != ErrContentLength {
+			t.Fatalf("got error: %v, want: %v", err, ErrContentLength)
+		}
+		io.WriteString(w, "he")
+	})
+}
+
+func TestResponseWriterTooShort(t *testing.T) {
+	// 声明的长度已经发出 body却更短 连接必须关闭 不能再处理下一个请求
+	s := &Server{Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+		w.Header().Set("Content-Length", "10")
+		io.WriteString(w, "hello")
+	})}
+	got := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: test\r\n\r\nGET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	if !strings.HasSuffix(got, "\r\n\r\nhello") || strings.Count(got, "HTTP/1.1 200 OK") != 1 {
+		t.Fatalf("got response: %q", got)
+	}
+}
@@ -6,9 +6,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 
-	"go-http/pkg/tritonhttp"
+	"NetworkProtocol/HTTP/pkg/tritonhttp"
 )
 
 type ResponseChecker struct {
@@ -16,6 +17,10 @@ type ResponseChecker struct {
 	FilePath    string
 	ContentType string
 	Close       bool
+
+	// Chunked means the body is expected with "Transfer-Encoding: chunked"
+	// instead of "Content-Length".
+	Chunked bool
 }
 
 type HeaderSpec struct {
@@ -27,6 +32,7 @@ var statusLineWant = map[int]string{
 	200: "HTTP/1.1 200 OK",
 	400: "HTTP/1.1 400 Bad Request",
 	404: "HTTP/1.1 404 Not Found",
+	408: "HTTP/1.1 408 Request Timeout",
 }
 
 func (rc *ResponseChecker) Check(br *bufio.Reader) error {
@@ -51,21 +57,31 @@ func (rc *ResponseChecker) Check(br *bufio.Reader) error {
 			return err
 		}
 		specs = []HeaderSpec{
+			{"Accept-Ranges", "bytes"},
 			{"Content-Length", fmt.Sprint(fi.Size())},
 			{"Content-Type", rc.ContentType},
 			{"Date", ""},
+			{"Etag", ""},
 			{"Last-Modified", ""},
 		}
+		if rc.Chunked {
+			specs = append(append(specs[:1], specs[2:]...), HeaderSpec{"Transfer-Encoding", "chunked"})
+		}
 		if rc.Close {
-			specs = append(connCloseHeader, specs...)
+			// "Connection" sorts right after "Accept-Ranges"
+			specs = append(append(specs[:1:1], connCloseHeader...), specs[1:]...)
 		}
-	case 400:
+	case 400, 408:
 		specs = []HeaderSpec{
 			{"Connection", "close"},
+			{"Content-Length", ""},
+			{"Content-Type", ""},
 			{"Date", ""},
 		}
 	case 404:
 		specs = []HeaderSpec{
+			{"Content-Length", ""},
+			{"Content-Type", ""},
 			{"Date", ""},
 		}
 		if rc.Close {
@@ -80,7 +96,11 @@ func (rc *ResponseChecker) Check(br *bufio.Reader) error {
 
 	// Check body
 	if rc.StatusCode == 200 {
-		if err := checkBody(br, rc.FilePath); err != nil {
+		if rc.Chunked {
+			if err := checkChunkedBody(br, rc.FilePath); err != nil {
+				return err
+			}
+		} else if err := checkBody(br, rc.FilePath); err != nil {
 			return err
 		}
 	}
@@ -150,3 +170,52 @@ func checkBody(br *bufio.Reader, path string) error {
 	}
 	return nil
 }
+
+// checkChunkedBody decodes a chunked body from br, including an
+// optional trailer section, and compares it with the file at path.
+func checkChunkedBody(br *bufio.Reader, path string) error {
+	var buffer bytes.Buffer
+	for {
+		line, err := tritonhttp.ReadLine(br)
+		if err != nil {
+			return err
+		}
+		if i := strings.Index(line, ";"); i != -1 {
+			line = line[:i]
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		if err != nil {
+			return fmt.Errorf("invalid chunk size line: %q", line)
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&buffer, br, size); err != nil {
+			return err
+		}
+		if line, err := tritonhttp.ReadLine(br); err != nil {
+			return err
+		} else if line != "" {
+			return fmt.Errorf("got: %q, want: empty line after chunk", line)
+		}
+	}
+	// Skip trailer fields up to the final empty line
+	for {
+		line, err := tritonhttp.ReadLine(br)
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+	}
+
+	bytesWant, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(buffer.Bytes(), bytesWant) {
+		return fmt.Errorf("chunked body bytes are different from the file\ngot: %v bytes, want: %v bytes", buffer.Len(), len(bytesWant))
+	}
+	return nil
+}
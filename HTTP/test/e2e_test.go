@@ -82,7 +82,7 @@ func TestSingleRequest(t *testing.T) {
 		{
 			"BadRequestTimeout",
 			&ResponseChecker{
-				StatusCode: 400,
+				StatusCode: 408,
 			},
 		},
 		{